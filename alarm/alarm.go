@@ -0,0 +1,313 @@
+// Package alarm provides a rules engine that evaluates the readings of the
+// fridge compartments and raises and clears alarms locally.
+package alarm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Condition is used to specify what a rule checks.
+type Condition string
+
+// Conditions supported by the rules.
+const (
+	// Above holds while the temperature is above the threshold.
+	Above Condition = "above"
+	// Below holds while the temperature is below the threshold.
+	Below Condition = "below"
+	// Rate holds while the temperature changes faster than the threshold
+	// in degrees per minute in either direction. The rate is the slope of
+	// the readings taken within the last RateWindow.
+	Rate Condition = "rate"
+)
+
+// RateWindow is the span of the readings the rate of change is computed
+// over. It smooths out the noise of the sensors, which would make the rate
+// between two consecutive readings flap around the threshold. The rate isn't
+// evaluated until the readings span at least a half of the window.
+const RateWindow = time.Minute
+
+// State is used to specify whether an alarm has been raised or cleared.
+type State string
+
+// States of the alarms.
+const (
+	Raised  State = "raised"
+	Cleared State = "cleared"
+)
+
+// Rule is used to store an alarm rule of a compartment. The alarm is raised
+// once Cond has held for Duration and is cleared once the value has returned
+// past Threshold by more than Hysteresis. Thresholds are in the units of the
+// compartment readings.
+type Rule struct {
+	Name       string
+	Compart    string
+	Cond       Condition
+	Threshold  float32
+	Duration   time.Duration
+	Hysteresis float32
+}
+
+// Event is used to represent raising or clearing of an alarm.
+// Time is a unix timestamp in milliseconds.
+type Event struct {
+	ID        string
+	Rule      string
+	Compart   string
+	State     State
+	Cond      Condition
+	Threshold float32
+	Temp      float32
+	Time      int64
+}
+
+// ParseRule creates a rule from its textual specification of the form
+// "name:compartment:condition:threshold[:duration[:hysteresis]]", e.g.
+// "TopWarm:TopCompart:above:8:10m:0.5".
+// It returns initialized rule.
+func ParseRule(spec string) (Rule, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 4 || len(parts) > 6 {
+		return Rule{}, fmt.Errorf("alarm: rule must be name:compartment:condition:threshold[:duration[:hysteresis]]: %q", spec)
+	}
+
+	r := Rule{
+		Name:    strings.TrimSpace(parts[0]),
+		Compart: strings.TrimSpace(parts[1]),
+		Cond:    Condition(strings.TrimSpace(parts[2])),
+	}
+	if r.Name == "" || r.Compart == "" {
+		return Rule{}, fmt.Errorf("alarm: rule name and compartment must not be empty: %q", spec)
+	}
+	switch r.Cond {
+	case Above, Below, Rate:
+	default:
+		return Rule{}, fmt.Errorf("alarm: unknown condition %q", r.Cond)
+	}
+
+	threshold, err := strconv.ParseFloat(strings.TrimSpace(parts[3]), 32)
+	if err != nil {
+		return Rule{}, fmt.Errorf("alarm: invalid threshold: %s", err)
+	}
+	if r.Cond == Rate && threshold <= 0 {
+		return Rule{}, fmt.Errorf("alarm: rate threshold must be positive: %v", threshold)
+	}
+	r.Threshold = float32(threshold)
+
+	if len(parts) > 4 && parts[4] != "" {
+		if r.Duration, err = time.ParseDuration(strings.TrimSpace(parts[4])); err != nil {
+			return Rule{}, fmt.Errorf("alarm: invalid duration: %s", err)
+		}
+		if r.Duration < 0 {
+			return Rule{}, fmt.Errorf("alarm: duration must not be negative: %s", r.Duration)
+		}
+	}
+
+	if len(parts) > 5 && parts[5] != "" {
+		hysteresis, err := strconv.ParseFloat(strings.TrimSpace(parts[5]), 32)
+		if err != nil {
+			return Rule{}, fmt.Errorf("alarm: invalid hysteresis: %s", err)
+		}
+		if hysteresis < 0 {
+			return Rule{}, fmt.Errorf("alarm: hysteresis must not be negative: %v", hysteresis)
+		}
+		r.Hysteresis = float32(hysteresis)
+	}
+	if r.Cond == Rate && r.Hysteresis >= r.Threshold {
+		return Rule{}, fmt.Errorf("alarm: rate hysteresis must be less than threshold: %v", r.Hysteresis)
+	}
+
+	return r, nil
+}
+
+// reading is used to store a reading within the rate window.
+type reading struct {
+	t    time.Time
+	temp float32
+}
+
+// ruleState is used to store the evaluation state of a rule.
+type ruleState struct {
+	Rule
+	active bool
+	since  time.Time
+	window []reading
+	raised Event
+}
+
+// Engine is used to evaluate the rules against the readings of the compartments.
+// It is safe for concurrent use.
+type Engine struct {
+	mu    sync.Mutex
+	rules []*ruleState
+}
+
+// NewEngine creates and initializes new Engine object.
+// It returns initialized object.
+func NewEngine(rules []Rule) *Engine {
+	e := &Engine{rules: make([]*ruleState, 0, len(rules))}
+	for _, r := range rules {
+		e.rules = append(e.rules, &ruleState{Rule: r})
+	}
+	return e
+}
+
+// Evaluate applies the reading of the compartment taken at t to the rules
+// of that compartment and returns the alarms that have been raised or cleared.
+func (e *Engine) Evaluate(compart string, t time.Time, temp float32) []Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var events []Event
+	for _, r := range e.rules {
+		if r.Compart != compart {
+			continue
+		}
+		if ev, ok := r.evaluate(t, temp); ok {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// Active returns the events that have raised the alarms that are still active.
+func (e *Engine) Active() []Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var events []Event
+	for _, r := range e.rules {
+		if r.active {
+			events = append(events, r.raised)
+		}
+	}
+	return events
+}
+
+// Restore marks the alarms raised by the events as active, e.g. the ones
+// that have been active before a restart, so that they are cleared rather
+// than raised again. The events of the rules that no longer exist are ignored.
+func (e *Engine) Restore(active []Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, ev := range active {
+		if ev.State != Raised {
+			continue
+		}
+		for _, r := range e.rules {
+			if r.Name == ev.Rule && r.Compart == ev.Compart {
+				r.active = true
+				r.since = time.Time{}
+				r.raised = ev
+			}
+		}
+	}
+}
+
+func (r *ruleState) evaluate(t time.Time, temp float32) (Event, bool) {
+	value, ok := r.value(t, temp)
+	if !ok {
+		return Event{}, false
+	}
+
+	if r.active {
+		if r.cleared(value) {
+			r.active = false
+			r.since = time.Time{}
+			return r.event(Cleared, t, temp), true
+		}
+		return Event{}, false
+	}
+
+	if !r.holds(value) {
+		r.since = time.Time{}
+		return Event{}, false
+	}
+	if r.since.IsZero() {
+		r.since = t
+	}
+	if t.Sub(r.since) < r.Duration {
+		return Event{}, false
+	}
+
+	r.active = true
+	r.raised = r.event(Raised, t, temp)
+	return r.raised, true
+}
+
+// value returns the value the threshold is compared with: the temperature
+// itself or its rate of change in degrees per minute.
+func (r *ruleState) value(t time.Time, temp float32) (float32, bool) {
+	if r.Cond != Rate {
+		return temp, true
+	}
+
+	if n := len(r.window); n > 0 && !t.After(r.window[n-1].t) {
+		return 0, false
+	}
+	r.window = append(r.window, reading{t: t, temp: temp})
+	// the previous reading is kept even if it's out of the window,
+	// so the rate of rare readings is computed between the last two
+	i := 0
+	for i < len(r.window)-2 && t.Sub(r.window[i].t) > RateWindow {
+		i++
+	}
+	r.window = append(r.window[:0], r.window[i:]...)
+
+	if t.Sub(r.window[0].t) < RateWindow/2 {
+		return 0, false
+	}
+	return float32(math.Abs(slope(r.window))), true
+}
+
+// slope returns the least squares slope of the readings in degrees per minute.
+func slope(rs []reading) float64 {
+	var sumX, sumY, sumXY, sumXX float64
+	for _, r := range rs {
+		x := r.t.Sub(rs[0].t).Minutes()
+		y := float64(r.temp)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	n := float64(len(rs))
+	d := n*sumXX - sumX*sumX
+	if d == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / d
+}
+
+func (r *ruleState) holds(value float32) bool {
+	if r.Cond == Below {
+		return value < r.Threshold
+	}
+	return value > r.Threshold
+}
+
+func (r *ruleState) cleared(value float32) bool {
+	if r.Cond == Below {
+		return value > r.Threshold+r.Hysteresis
+	}
+	return value < r.Threshold-r.Hysteresis
+}
+
+func (r *ruleState) event(s State, t time.Time, temp float32) Event {
+	return Event{
+		Rule:      r.Name,
+		Compart:   r.Compart,
+		State:     s,
+		Cond:      r.Cond,
+		Threshold: r.Threshold,
+		Temp:      temp,
+		Time:      t.UnixNano() / int64(time.Millisecond),
+	}
+}
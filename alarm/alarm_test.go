@@ -0,0 +1,209 @@
+package alarm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThreshold(t *testing.T) {
+	start := time.Unix(1543846800, 0)
+
+	// reading is taken at the offset from start and is expected to
+	// produce the event with the state want, none if want is empty.
+	type reading struct {
+		at   time.Duration
+		temp float32
+		want State
+	}
+
+	tests := []struct {
+		name     string
+		rule     Rule
+		readings []reading
+	}{
+		{
+			name: "above",
+			rule: Rule{Cond: Above, Threshold: 8},
+			readings: []reading{
+				{at: 0, temp: 7},
+				{at: time.Minute, temp: 8},
+				{at: time.Minute * 2, temp: 8.5, want: Raised},
+				{at: time.Minute * 3, temp: 9},
+				{at: time.Minute * 4, temp: 7.9, want: Cleared},
+				{at: time.Minute * 5, temp: 7},
+			},
+		},
+		{
+			name: "below",
+			rule: Rule{Cond: Below, Threshold: -15},
+			readings: []reading{
+				{at: 0, temp: -14},
+				{at: time.Minute, temp: -15},
+				{at: time.Minute * 2, temp: -16, want: Raised},
+				{at: time.Minute * 3, temp: -20},
+				{at: time.Minute * 4, temp: -14.9, want: Cleared},
+			},
+		},
+		{
+			name: "duration",
+			rule: Rule{Cond: Above, Threshold: 8, Duration: time.Minute * 10},
+			readings: []reading{
+				{at: 0, temp: 9},
+				{at: time.Minute * 5, temp: 9},
+				// the condition has stopped holding, so it's held since the next reading
+				{at: time.Minute * 6, temp: 7},
+				{at: time.Minute * 7, temp: 9},
+				{at: time.Minute*17 - time.Second, temp: 9},
+				{at: time.Minute * 17, temp: 9, want: Raised},
+				{at: time.Minute * 18, temp: 7, want: Cleared},
+				// it must be held again after clearing
+				{at: time.Minute * 19, temp: 9},
+				{at: time.Minute * 29, temp: 9, want: Raised},
+			},
+		},
+		{
+			name: "above with hysteresis",
+			rule: Rule{Cond: Above, Threshold: 8, Hysteresis: 1},
+			readings: []reading{
+				{at: 0, temp: 9, want: Raised},
+				{at: time.Minute, temp: 7.5},
+				{at: time.Minute * 2, temp: 7},
+				{at: time.Minute * 3, temp: 8.5},
+				{at: time.Minute * 4, temp: 6.9, want: Cleared},
+				{at: time.Minute * 5, temp: 7.5},
+				{at: time.Minute * 6, temp: 8.1, want: Raised},
+			},
+		},
+		{
+			name: "below with hysteresis",
+			rule: Rule{Cond: Below, Threshold: -15, Hysteresis: 1},
+			readings: []reading{
+				{at: 0, temp: -16, want: Raised},
+				{at: time.Minute, temp: -14.5},
+				{at: time.Minute * 2, temp: -14},
+				{at: time.Minute * 3, temp: -13.9, want: Cleared},
+				{at: time.Minute * 4, temp: -15.1, want: Raised},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name, tt.rule.Compart = "Threshold", "TopCompart"
+			e := NewEngine([]Rule{tt.rule})
+
+			for i, r := range tt.readings {
+				// the readings of the other compartments must be ignored
+				if events := e.Evaluate("BotCompart", start.Add(r.at), r.temp); len(events) != 0 {
+					t.Fatalf("reading %d of BotCompart has produced %v", i, events)
+				}
+
+				events := e.Evaluate("TopCompart", start.Add(r.at), r.temp)
+				if r.want == "" {
+					if len(events) != 0 {
+						t.Fatalf("reading %d (%v) has produced %v, want none", i, r.temp, events)
+					}
+					continue
+				}
+				if len(events) != 1 || events[0].State != r.want || events[0].Temp != r.temp {
+					t.Fatalf("reading %d (%v) has produced %v, want the alarm %s", i, r.temp, events, r.want)
+				}
+				if active := len(e.Active()) == 1; active != (r.want == Raised) {
+					t.Fatalf("alarm is active %t after reading %d, want %t", active, i, !active)
+				}
+			}
+		})
+	}
+}
+
+func TestRate(t *testing.T) {
+	rule := Rule{Name: "FastChange", Compart: "TopCompart", Cond: Rate, Threshold: 2, Hysteresis: 0.5}
+	start := time.Unix(1543846800, 0)
+
+	tests := []struct {
+		name string
+		// temp returns the reading taken i seconds after start.
+		temp       func(i int) float32
+		wantRaised bool
+	}{
+		{
+			name: "steady with noise",
+			temp: func(i int) float32 {
+				if i%2 == 0 {
+					return 4.2
+				}
+				return 3.8
+			},
+		},
+		{
+			name:       "warming up fast",
+			temp:       func(i int) float32 { return 4 + float32(i)*0.1 },
+			wantRaised: true,
+		},
+		{
+			name: "warming up fast with noise",
+			temp: func(i int) float32 {
+				if i%2 == 0 {
+					return 4.2 + float32(i)*0.1
+				}
+				return 3.8 + float32(i)*0.1
+			},
+			wantRaised: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine([]Rule{rule})
+			raised := 0
+			for i := 0; i < 300; i++ {
+				for _, ev := range e.Evaluate("TopCompart", start.Add(time.Duration(i)*time.Second), tt.temp(i)) {
+					if ev.State == Raised {
+						raised++
+					}
+				}
+			}
+			if tt.wantRaised && raised != 1 {
+				t.Errorf("alarm has been raised %d times, want once", raised)
+			}
+			if !tt.wantRaised && raised != 0 {
+				t.Errorf("alarm has been raised %d times, want none", raised)
+			}
+		})
+	}
+}
+
+func TestRateOfRareReadings(t *testing.T) {
+	e := NewEngine([]Rule{{Name: "FastChange", Compart: "TopCompart", Cond: Rate, Threshold: 2}})
+	start := time.Unix(1543846800, 0)
+
+	if events := e.Evaluate("TopCompart", start, 4); len(events) != 0 {
+		t.Fatalf("first reading has produced %v", events)
+	}
+	events := e.Evaluate("TopCompart", start.Add(2*time.Minute), 10)
+	if len(events) != 1 || events[0].State != Raised {
+		t.Errorf("rate of 3 degrees per minute has produced %v, want the alarm raised", events)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	rule := Rule{Name: "TopWarm", Compart: "TopCompart", Cond: Above, Threshold: 8}
+	start := time.Unix(1543846800, 0)
+
+	e := NewEngine([]Rule{rule})
+	e.Restore([]Event{
+		{Rule: "TopWarm", Compart: "TopCompart", State: Raised, Temp: 9},
+		// the rule doesn't exist anymore
+		{Rule: "BotWarm", Compart: "BotCompart", State: Raised, Temp: -10},
+	})
+	if active := e.Active(); len(active) != 1 || active[0].Rule != "TopWarm" {
+		t.Fatalf("active alarms = %v, want TopWarm", active)
+	}
+
+	if events := e.Evaluate("TopCompart", start, 9); len(events) != 0 {
+		t.Fatalf("restored alarm has produced %v, want none", events)
+	}
+	if events := e.Evaluate("TopCompart", start.Add(time.Minute), 7); len(events) != 1 || events[0].State != Cleared {
+		t.Errorf("restored alarm has produced %v, want it cleared", events)
+	}
+}
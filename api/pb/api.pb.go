@@ -9,11 +9,25 @@ It is generated from these files:
 
 It has these top-level messages:
 	EventStore
+	ConfigPatch
+	ConfigAck
+	ReportedState
+	Alarm
+	AlarmAck
+	ConfigPatchReject
 	DevMeta
+	RegisterDevRequest
+	RegisterDevResponse
+	FridgeConfig
+	FridgeReading
+	CompartmentSeries
+	FridgeData
 	SetDevInitConfigRequest
 	SetDevInitConfigResponse
 	SaveDevDataRequest
 	SaveDevDataResponse
+	DevDataBatch
+	DevDataAck
 */
 package api
 
@@ -37,6 +51,53 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+// PayloadFormat is the encoding of the fridge data and config. JSON is the
+// legacy encoding carried in the bytes fields, PROTO means the typed fields
+// are used instead.
+type PayloadFormat int32
+
+const (
+	PayloadFormat_JSON  PayloadFormat = 0
+	PayloadFormat_PROTO PayloadFormat = 1
+)
+
+var PayloadFormat_name = map[int32]string{
+	0: "JSON",
+	1: "PROTO",
+}
+var PayloadFormat_value = map[string]int32{
+	"JSON":  0,
+	"PROTO": 1,
+}
+
+func (x PayloadFormat) String() string {
+	return proto.EnumName(PayloadFormat_name, int32(x))
+}
+func (PayloadFormat) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+// Quality is the quality of a reading. BAD readings are out of the bounds
+// of the compartment and are likely to be sensor faults.
+type Quality int32
+
+const (
+	Quality_GOOD Quality = 0
+	Quality_BAD  Quality = 1
+)
+
+var Quality_name = map[int32]string{
+	0: "GOOD",
+	1: "BAD",
+}
+var Quality_value = map[string]int32{
+	"GOOD": 0,
+	"BAD":  1,
+}
+
+func (x Quality) String() string {
+	return proto.EnumName(Quality_name, int32(x))
+}
+func (Quality) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
 // EventStore is for NATS pub/sub
 type EventStore struct {
 	AggregateId   string `protobuf:"bytes,1,opt,name=aggregate_id,json=aggregateId" json:"aggregate_id,omitempty"`
@@ -44,6 +105,18 @@ type EventStore struct {
 	EventId       string `protobuf:"bytes,3,opt,name=event_id,json=eventId" json:"event_id,omitempty"`
 	EventType     string `protobuf:"bytes,4,opt,name=event_type,json=eventType" json:"event_type,omitempty"`
 	EventData     string `protobuf:"bytes,5,opt,name=event_data,json=eventData" json:"event_data,omitempty"`
+	// version is the config revision produced by a config patch. Zero means
+	// the patch is unversioned and is applied regardless of the revision.
+	Version uint64 `protobuf:"varint,6,opt,name=version" json:"version,omitempty"`
+	// config_patch is the typed config patch. If it isn't set, event_data
+	// carries the legacy JSON encoded patch.
+	ConfigPatch *ConfigPatch `protobuf:"bytes,7,opt,name=config_patch,json=configPatch" json:"config_patch,omitempty"`
+	// config_ack is set in the events of ConfigAck type published by the device.
+	ConfigAck *ConfigAck `protobuf:"bytes,8,opt,name=config_ack,json=configAck" json:"config_ack,omitempty"`
+	// reported_state is set in the events of ReportedState type published by the device.
+	ReportedState *ReportedState `protobuf:"bytes,9,opt,name=reported_state,json=reportedState" json:"reported_state,omitempty"`
+	// alarm is set in the events of AlarmRaised and AlarmCleared types published by the device.
+	Alarm *Alarm `protobuf:"bytes,10,opt,name=alarm" json:"alarm,omitempty"`
 }
 
 func (m *EventStore) Reset()                    { *m = EventStore{} }
@@ -86,16 +159,306 @@ func (m *EventStore) GetEventData() string {
 	return ""
 }
 
+func (m *EventStore) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *EventStore) GetConfigPatch() *ConfigPatch {
+	if m != nil {
+		return m.ConfigPatch
+	}
+	return nil
+}
+
+func (m *EventStore) GetConfigAck() *ConfigAck {
+	if m != nil {
+		return m.ConfigAck
+	}
+	return nil
+}
+
+func (m *EventStore) GetReportedState() *ReportedState {
+	if m != nil {
+		return m.ReportedState
+	}
+	return nil
+}
+
+func (m *EventStore) GetAlarm() *Alarm {
+	if m != nil {
+		return m.Alarm
+	}
+	return nil
+}
+
+// ConfigPatch changes the fields of the fridge config listed in update_mask
+// (turned_on, collect_freq, send_freq, downsample, downsample_window,
+// downsample_deviation). An empty mask changes all of them.
+type ConfigPatch struct {
+	Config     *FridgeConfig `protobuf:"bytes,1,opt,name=config" json:"config,omitempty"`
+	UpdateMask []string      `protobuf:"bytes,2,rep,name=update_mask,json=updateMask" json:"update_mask,omitempty"`
+}
+
+func (m *ConfigPatch) Reset()                    { *m = ConfigPatch{} }
+func (m *ConfigPatch) String() string            { return proto.CompactTextString(m) }
+func (*ConfigPatch) ProtoMessage()               {}
+func (*ConfigPatch) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *ConfigPatch) GetConfig() *FridgeConfig {
+	if m != nil {
+		return m.Config
+	}
+	return nil
+}
+
+func (m *ConfigPatch) GetUpdateMask() []string {
+	if m != nil {
+		return m.UpdateMask
+	}
+	return nil
+}
+
+// ConfigAck reports the result of a config patch: applied, rejected or ignored.
+// config and version are the ones the device runs with after the patch.
+type ConfigAck struct {
+	PatchEventId string        `protobuf:"bytes,1,opt,name=patch_event_id,json=patchEventId" json:"patch_event_id,omitempty"`
+	PatchVersion uint64        `protobuf:"varint,2,opt,name=patch_version,json=patchVersion" json:"patch_version,omitempty"`
+	Result       string        `protobuf:"bytes,3,opt,name=result" json:"result,omitempty"`
+	Reason       string        `protobuf:"bytes,4,opt,name=reason" json:"reason,omitempty"`
+	Config       *FridgeConfig `protobuf:"bytes,5,opt,name=config" json:"config,omitempty"`
+	Version      uint64        `protobuf:"varint,6,opt,name=version" json:"version,omitempty"`
+}
+
+func (m *ConfigAck) Reset()                    { *m = ConfigAck{} }
+func (m *ConfigAck) String() string            { return proto.CompactTextString(m) }
+func (*ConfigAck) ProtoMessage()               {}
+func (*ConfigAck) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *ConfigAck) GetPatchEventId() string {
+	if m != nil {
+		return m.PatchEventId
+	}
+	return ""
+}
+
+func (m *ConfigAck) GetPatchVersion() uint64 {
+	if m != nil {
+		return m.PatchVersion
+	}
+	return 0
+}
+
+func (m *ConfigAck) GetResult() string {
+	if m != nil {
+		return m.Result
+	}
+	return ""
+}
+
+func (m *ConfigAck) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+func (m *ConfigAck) GetConfig() *FridgeConfig {
+	if m != nil {
+		return m.Config
+	}
+	return nil
+}
+
+func (m *ConfigAck) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+// ReportedState is the state the device actually runs with. The center
+// compares it to the desired state to detect drift.
+type ReportedState struct {
+	Time          int64         `protobuf:"varint,1,opt,name=time" json:"time,omitempty"`
+	Config        *FridgeConfig `protobuf:"bytes,2,opt,name=config" json:"config,omitempty"`
+	ConfigVersion uint64        `protobuf:"varint,3,opt,name=config_version,json=configVersion" json:"config_version,omitempty"`
+	PayloadFormat PayloadFormat `protobuf:"varint,4,opt,name=payload_format,json=payloadFormat,enum=api.PayloadFormat" json:"payload_format,omitempty"`
+}
+
+func (m *ReportedState) Reset()                    { *m = ReportedState{} }
+func (m *ReportedState) String() string            { return proto.CompactTextString(m) }
+func (*ReportedState) ProtoMessage()               {}
+func (*ReportedState) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *ReportedState) GetTime() int64 {
+	if m != nil {
+		return m.Time
+	}
+	return 0
+}
+
+func (m *ReportedState) GetConfig() *FridgeConfig {
+	if m != nil {
+		return m.Config
+	}
+	return nil
+}
+
+func (m *ReportedState) GetConfigVersion() uint64 {
+	if m != nil {
+		return m.ConfigVersion
+	}
+	return 0
+}
+
+func (m *ReportedState) GetPayloadFormat() PayloadFormat {
+	if m != nil {
+		return m.PayloadFormat
+	}
+	return PayloadFormat_JSON
+}
+
+// Alarm is raised or cleared by the alarm rules evaluated on the device.
+// The device keeps publishing it until the center replies with AlarmAck.
+type Alarm struct {
+	Rule        string `protobuf:"bytes,1,opt,name=rule" json:"rule,omitempty"`
+	Compartment string `protobuf:"bytes,2,opt,name=compartment" json:"compartment,omitempty"`
+	// state is raised or cleared
+	State string `protobuf:"bytes,3,opt,name=state" json:"state,omitempty"`
+	// condition is above, below or rate
+	Condition string  `protobuf:"bytes,4,opt,name=condition" json:"condition,omitempty"`
+	Threshold float32 `protobuf:"fixed32,5,opt,name=threshold" json:"threshold,omitempty"`
+	Temp      float32 `protobuf:"fixed32,6,opt,name=temp" json:"temp,omitempty"`
+	// time is a unix timestamp in milliseconds
+	Time int64 `protobuf:"varint,7,opt,name=time" json:"time,omitempty"`
+}
+
+func (m *Alarm) Reset()                    { *m = Alarm{} }
+func (m *Alarm) String() string            { return proto.CompactTextString(m) }
+func (*Alarm) ProtoMessage()               {}
+func (*Alarm) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *Alarm) GetRule() string {
+	if m != nil {
+		return m.Rule
+	}
+	return ""
+}
+
+func (m *Alarm) GetCompartment() string {
+	if m != nil {
+		return m.Compartment
+	}
+	return ""
+}
+
+func (m *Alarm) GetState() string {
+	if m != nil {
+		return m.State
+	}
+	return ""
+}
+
+func (m *Alarm) GetCondition() string {
+	if m != nil {
+		return m.Condition
+	}
+	return ""
+}
+
+func (m *Alarm) GetThreshold() float32 {
+	if m != nil {
+		return m.Threshold
+	}
+	return 0
+}
+
+func (m *Alarm) GetTemp() float32 {
+	if m != nil {
+		return m.Temp
+	}
+	return 0
+}
+
+func (m *Alarm) GetTime() int64 {
+	if m != nil {
+		return m.Time
+	}
+	return 0
+}
+
+type AlarmAck struct {
+	EventId string `protobuf:"bytes,1,opt,name=event_id,json=eventId" json:"event_id,omitempty"`
+}
+
+func (m *AlarmAck) Reset()                    { *m = AlarmAck{} }
+func (m *AlarmAck) String() string            { return proto.CompactTextString(m) }
+func (*AlarmAck) ProtoMessage()               {}
+func (*AlarmAck) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *AlarmAck) GetEventId() string {
+	if m != nil {
+		return m.EventId
+	}
+	return ""
+}
+
+// ConfigPatchReject is published by the device if it has rejected a config patch.
+type ConfigPatchReject struct {
+	EventId string `protobuf:"bytes,1,opt,name=event_id,json=eventId" json:"event_id,omitempty"`
+	Version uint64 `protobuf:"varint,2,opt,name=version" json:"version,omitempty"`
+	Mac     string `protobuf:"bytes,3,opt,name=mac" json:"mac,omitempty"`
+	Reason  string `protobuf:"bytes,4,opt,name=reason" json:"reason,omitempty"`
+}
+
+func (m *ConfigPatchReject) Reset()                    { *m = ConfigPatchReject{} }
+func (m *ConfigPatchReject) String() string            { return proto.CompactTextString(m) }
+func (*ConfigPatchReject) ProtoMessage()               {}
+func (*ConfigPatchReject) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *ConfigPatchReject) GetEventId() string {
+	if m != nil {
+		return m.EventId
+	}
+	return ""
+}
+
+func (m *ConfigPatchReject) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *ConfigPatchReject) GetMac() string {
+	if m != nil {
+		return m.Mac
+	}
+	return ""
+}
+
+func (m *ConfigPatchReject) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
 type DevMeta struct {
 	Type string `protobuf:"bytes,1,opt,name=type" json:"type,omitempty"`
 	Name string `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
 	Mac  string `protobuf:"bytes,3,opt,name=mac" json:"mac,omitempty"`
+	// id is generated by the device on its first boot and never changes.
+	Id string `protobuf:"bytes,4,opt,name=id" json:"id,omitempty"`
 }
 
 func (m *DevMeta) Reset()                    { *m = DevMeta{} }
 func (m *DevMeta) String() string            { return proto.CompactTextString(m) }
 func (*DevMeta) ProtoMessage()               {}
-func (*DevMeta) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+func (*DevMeta) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
 
 func (m *DevMeta) GetType() string {
 	if m != nil {
@@ -118,15 +481,253 @@ func (m *DevMeta) GetMac() string {
 	return ""
 }
 
+func (m *DevMeta) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+// RegisterDevRequest carries the PEM encoded certificate request of the
+// device whose common name is the device MAC.
+type RegisterDevRequest struct {
+	Meta   *DevMeta `protobuf:"bytes,1,opt,name=meta" json:"meta,omitempty"`
+	Serial string   `protobuf:"bytes,2,opt,name=serial" json:"serial,omitempty"`
+	Token  string   `protobuf:"bytes,3,opt,name=token" json:"token,omitempty"`
+	Csr    []byte   `protobuf:"bytes,4,opt,name=csr,proto3" json:"csr,omitempty"`
+}
+
+func (m *RegisterDevRequest) Reset()                    { *m = RegisterDevRequest{} }
+func (m *RegisterDevRequest) String() string            { return proto.CompactTextString(m) }
+func (*RegisterDevRequest) ProtoMessage()               {}
+func (*RegisterDevRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *RegisterDevRequest) GetMeta() *DevMeta {
+	if m != nil {
+		return m.Meta
+	}
+	return nil
+}
+
+func (m *RegisterDevRequest) GetSerial() string {
+	if m != nil {
+		return m.Serial
+	}
+	return ""
+}
+
+func (m *RegisterDevRequest) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
+func (m *RegisterDevRequest) GetCsr() []byte {
+	if m != nil {
+		return m.Csr
+	}
+	return nil
+}
+
+// RegisterDevResponse carries the PEM encoded device certificate issued for
+// the request, the CA certificates to verify the center and, optionally,
+// the NATS user credentials of the device.
+type RegisterDevResponse struct {
+	Certificate []byte `protobuf:"bytes,1,opt,name=certificate,proto3" json:"certificate,omitempty"`
+	CaCert      []byte `protobuf:"bytes,2,opt,name=ca_cert,json=caCert,proto3" json:"ca_cert,omitempty"`
+	NatsCreds   []byte `protobuf:"bytes,3,opt,name=nats_creds,json=natsCreds,proto3" json:"nats_creds,omitempty"`
+}
+
+func (m *RegisterDevResponse) Reset()                    { *m = RegisterDevResponse{} }
+func (m *RegisterDevResponse) String() string            { return proto.CompactTextString(m) }
+func (*RegisterDevResponse) ProtoMessage()               {}
+func (*RegisterDevResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *RegisterDevResponse) GetCertificate() []byte {
+	if m != nil {
+		return m.Certificate
+	}
+	return nil
+}
+
+func (m *RegisterDevResponse) GetCaCert() []byte {
+	if m != nil {
+		return m.CaCert
+	}
+	return nil
+}
+
+func (m *RegisterDevResponse) GetNatsCreds() []byte {
+	if m != nil {
+		return m.NatsCreds
+	}
+	return nil
+}
+
+type FridgeConfig struct {
+	TurnedOn    bool  `protobuf:"varint,1,opt,name=turned_on,json=turnedOn" json:"turned_on,omitempty"`
+	CollectFreq int64 `protobuf:"varint,2,opt,name=collect_freq,json=collectFreq" json:"collect_freq,omitempty"`
+	SendFreq    int64 `protobuf:"varint,3,opt,name=send_freq,json=sendFreq" json:"send_freq,omitempty"`
+	// downsample is the mode of reducing the readings before upload: empty for
+	// none, min, max, mean, last, deadband or swingingdoor
+	Downsample string `protobuf:"bytes,4,opt,name=downsample" json:"downsample,omitempty"`
+	// downsample_window is the window of min, max, mean and last in milliseconds
+	DownsampleWindow int64 `protobuf:"varint,5,opt,name=downsample_window,json=downsampleWindow" json:"downsample_window,omitempty"`
+	// downsample_deviation is the deviation of deadband and swingingdoor
+	DownsampleDeviation float32 `protobuf:"fixed32,6,opt,name=downsample_deviation,json=downsampleDeviation" json:"downsample_deviation,omitempty"`
+}
+
+func (m *FridgeConfig) Reset()                    { *m = FridgeConfig{} }
+func (m *FridgeConfig) String() string            { return proto.CompactTextString(m) }
+func (*FridgeConfig) ProtoMessage()               {}
+func (*FridgeConfig) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *FridgeConfig) GetTurnedOn() bool {
+	if m != nil {
+		return m.TurnedOn
+	}
+	return false
+}
+
+func (m *FridgeConfig) GetCollectFreq() int64 {
+	if m != nil {
+		return m.CollectFreq
+	}
+	return 0
+}
+
+func (m *FridgeConfig) GetSendFreq() int64 {
+	if m != nil {
+		return m.SendFreq
+	}
+	return 0
+}
+
+func (m *FridgeConfig) GetDownsample() string {
+	if m != nil {
+		return m.Downsample
+	}
+	return ""
+}
+
+func (m *FridgeConfig) GetDownsampleWindow() int64 {
+	if m != nil {
+		return m.DownsampleWindow
+	}
+	return 0
+}
+
+func (m *FridgeConfig) GetDownsampleDeviation() float32 {
+	if m != nil {
+		return m.DownsampleDeviation
+	}
+	return 0
+}
+
+type FridgeReading struct {
+	// time is a unix timestamp in milliseconds
+	Time    int64   `protobuf:"varint,1,opt,name=time" json:"time,omitempty"`
+	Temp    float32 `protobuf:"fixed32,2,opt,name=temp" json:"temp,omitempty"`
+	Unit    string  `protobuf:"bytes,3,opt,name=unit" json:"unit,omitempty"`
+	Quality Quality `protobuf:"varint,4,opt,name=quality,enum=api.Quality" json:"quality,omitempty"`
+}
+
+func (m *FridgeReading) Reset()                    { *m = FridgeReading{} }
+func (m *FridgeReading) String() string            { return proto.CompactTextString(m) }
+func (*FridgeReading) ProtoMessage()               {}
+func (*FridgeReading) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *FridgeReading) GetTime() int64 {
+	if m != nil {
+		return m.Time
+	}
+	return 0
+}
+
+func (m *FridgeReading) GetTemp() float32 {
+	if m != nil {
+		return m.Temp
+	}
+	return 0
+}
+
+func (m *FridgeReading) GetUnit() string {
+	if m != nil {
+		return m.Unit
+	}
+	return ""
+}
+
+func (m *FridgeReading) GetQuality() Quality {
+	if m != nil {
+		return m.Quality
+	}
+	return Quality_GOOD
+}
+
+// CompartmentSeries keeps the readings of a compartment in the order they
+// have been taken. Readings taken in the same millisecond are all kept.
+type CompartmentSeries struct {
+	Name     string           `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Unit     string           `protobuf:"bytes,2,opt,name=unit" json:"unit,omitempty"`
+	Readings []*FridgeReading `protobuf:"bytes,3,rep,name=readings" json:"readings,omitempty"`
+}
+
+func (m *CompartmentSeries) Reset()                    { *m = CompartmentSeries{} }
+func (m *CompartmentSeries) String() string            { return proto.CompactTextString(m) }
+func (*CompartmentSeries) ProtoMessage()               {}
+func (*CompartmentSeries) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *CompartmentSeries) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *CompartmentSeries) GetUnit() string {
+	if m != nil {
+		return m.Unit
+	}
+	return ""
+}
+
+func (m *CompartmentSeries) GetReadings() []*FridgeReading {
+	if m != nil {
+		return m.Readings
+	}
+	return nil
+}
+
+type FridgeData struct {
+	Compartments []*CompartmentSeries `protobuf:"bytes,1,rep,name=compartments" json:"compartments,omitempty"`
+}
+
+func (m *FridgeData) Reset()                    { *m = FridgeData{} }
+func (m *FridgeData) String() string            { return proto.CompactTextString(m) }
+func (*FridgeData) ProtoMessage()               {}
+func (*FridgeData) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *FridgeData) GetCompartments() []*CompartmentSeries {
+	if m != nil {
+		return m.Compartments
+	}
+	return nil
+}
+
 type SetDevInitConfigRequest struct {
 	Time int64    `protobuf:"varint,1,opt,name=time" json:"time,omitempty"`
 	Meta *DevMeta `protobuf:"bytes,2,opt,name=meta" json:"meta,omitempty"`
+	// payload_formats lists the formats supported by the device, the preferred
+	// one first. Older devices don't set it and get JSON.
+	PayloadFormats []PayloadFormat `protobuf:"varint,3,rep,packed,name=payload_formats,json=payloadFormats,enum=api.PayloadFormat" json:"payload_formats,omitempty"`
 }
 
 func (m *SetDevInitConfigRequest) Reset()                    { *m = SetDevInitConfigRequest{} }
 func (m *SetDevInitConfigRequest) String() string            { return proto.CompactTextString(m) }
 func (*SetDevInitConfigRequest) ProtoMessage()               {}
-func (*SetDevInitConfigRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+func (*SetDevInitConfigRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
 
 func (m *SetDevInitConfigRequest) GetTime() int64 {
 	if m != nil {
@@ -142,14 +743,29 @@ func (m *SetDevInitConfigRequest) GetMeta() *DevMeta {
 	return nil
 }
 
+func (m *SetDevInitConfigRequest) GetPayloadFormats() []PayloadFormat {
+	if m != nil {
+		return m.PayloadFormats
+	}
+	return nil
+}
+
 type SetDevInitConfigResponse struct {
+	// config is set if payload_format is JSON
 	Config []byte `protobuf:"bytes,1,opt,name=config,proto3" json:"config,omitempty"`
+	// payload_format is the format chosen by the center. Older centers don't
+	// set it, so JSON is used.
+	PayloadFormat PayloadFormat `protobuf:"varint,2,opt,name=payload_format,json=payloadFormat,enum=api.PayloadFormat" json:"payload_format,omitempty"`
+	// typed_config is set if payload_format is PROTO
+	TypedConfig *FridgeConfig `protobuf:"bytes,3,opt,name=typed_config,json=typedConfig" json:"typed_config,omitempty"`
+	// config_version is the revision of the config
+	ConfigVersion uint64 `protobuf:"varint,4,opt,name=config_version,json=configVersion" json:"config_version,omitempty"`
 }
 
 func (m *SetDevInitConfigResponse) Reset()                    { *m = SetDevInitConfigResponse{} }
 func (m *SetDevInitConfigResponse) String() string            { return proto.CompactTextString(m) }
 func (*SetDevInitConfigResponse) ProtoMessage()               {}
-func (*SetDevInitConfigResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+func (*SetDevInitConfigResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
 
 func (m *SetDevInitConfigResponse) GetConfig() []byte {
 	if m != nil {
@@ -158,16 +774,40 @@ func (m *SetDevInitConfigResponse) GetConfig() []byte {
 	return nil
 }
 
+func (m *SetDevInitConfigResponse) GetPayloadFormat() PayloadFormat {
+	if m != nil {
+		return m.PayloadFormat
+	}
+	return PayloadFormat_JSON
+}
+
+func (m *SetDevInitConfigResponse) GetTypedConfig() *FridgeConfig {
+	if m != nil {
+		return m.TypedConfig
+	}
+	return nil
+}
+
+func (m *SetDevInitConfigResponse) GetConfigVersion() uint64 {
+	if m != nil {
+		return m.ConfigVersion
+	}
+	return 0
+}
+
 type SaveDevDataRequest struct {
 	Time int64    `protobuf:"varint,1,opt,name=time" json:"time,omitempty"`
 	Meta *DevMeta `protobuf:"bytes,2,opt,name=meta" json:"meta,omitempty"`
-	Data []byte   `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	// data is set if the negotiated format is JSON
+	Data []byte `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	// typed_data is set if the negotiated format is PROTO
+	TypedData *FridgeData `protobuf:"bytes,4,opt,name=typed_data,json=typedData" json:"typed_data,omitempty"`
 }
 
 func (m *SaveDevDataRequest) Reset()                    { *m = SaveDevDataRequest{} }
 func (m *SaveDevDataRequest) String() string            { return proto.CompactTextString(m) }
 func (*SaveDevDataRequest) ProtoMessage()               {}
-func (*SaveDevDataRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+func (*SaveDevDataRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
 
 func (m *SaveDevDataRequest) GetTime() int64 {
 	if m != nil {
@@ -190,6 +830,13 @@ func (m *SaveDevDataRequest) GetData() []byte {
 	return nil
 }
 
+func (m *SaveDevDataRequest) GetTypedData() *FridgeData {
+	if m != nil {
+		return m.TypedData
+	}
+	return nil
+}
+
 type SaveDevDataResponse struct {
 	Status string `protobuf:"bytes,1,opt,name=status" json:"status,omitempty"`
 }
@@ -197,7 +844,7 @@ type SaveDevDataResponse struct {
 func (m *SaveDevDataResponse) Reset()                    { *m = SaveDevDataResponse{} }
 func (m *SaveDevDataResponse) String() string            { return proto.CompactTextString(m) }
 func (*SaveDevDataResponse) ProtoMessage()               {}
-func (*SaveDevDataResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+func (*SaveDevDataResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{17} }
 
 func (m *SaveDevDataResponse) GetStatus() string {
 	if m != nil {
@@ -206,13 +853,86 @@ func (m *SaveDevDataResponse) GetStatus() string {
 	return ""
 }
 
+type DevDataBatch struct {
+	Seq     uint64              `protobuf:"varint,1,opt,name=seq" json:"seq,omitempty"`
+	Request *SaveDevDataRequest `protobuf:"bytes,2,opt,name=request" json:"request,omitempty"`
+}
+
+func (m *DevDataBatch) Reset()                    { *m = DevDataBatch{} }
+func (m *DevDataBatch) String() string            { return proto.CompactTextString(m) }
+func (*DevDataBatch) ProtoMessage()               {}
+func (*DevDataBatch) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{18} }
+
+func (m *DevDataBatch) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *DevDataBatch) GetRequest() *SaveDevDataRequest {
+	if m != nil {
+		return m.Request
+	}
+	return nil
+}
+
+type DevDataAck struct {
+	Seq    uint64 `protobuf:"varint,1,opt,name=seq" json:"seq,omitempty"`
+	Status string `protobuf:"bytes,2,opt,name=status" json:"status,omitempty"`
+	// error is set if the center has rejected the batch
+	Error string `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *DevDataAck) Reset()                    { *m = DevDataAck{} }
+func (m *DevDataAck) String() string            { return proto.CompactTextString(m) }
+func (*DevDataAck) ProtoMessage()               {}
+func (*DevDataAck) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{19} }
+
+func (m *DevDataAck) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *DevDataAck) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+func (m *DevDataAck) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*EventStore)(nil), "api.EventStore")
+	proto.RegisterType((*ConfigPatch)(nil), "api.ConfigPatch")
+	proto.RegisterType((*ConfigAck)(nil), "api.ConfigAck")
+	proto.RegisterType((*ReportedState)(nil), "api.ReportedState")
+	proto.RegisterType((*Alarm)(nil), "api.Alarm")
+	proto.RegisterType((*AlarmAck)(nil), "api.AlarmAck")
+	proto.RegisterType((*ConfigPatchReject)(nil), "api.ConfigPatchReject")
 	proto.RegisterType((*DevMeta)(nil), "api.DevMeta")
+	proto.RegisterType((*RegisterDevRequest)(nil), "api.RegisterDevRequest")
+	proto.RegisterType((*RegisterDevResponse)(nil), "api.RegisterDevResponse")
+	proto.RegisterType((*FridgeConfig)(nil), "api.FridgeConfig")
+	proto.RegisterType((*FridgeReading)(nil), "api.FridgeReading")
+	proto.RegisterType((*CompartmentSeries)(nil), "api.CompartmentSeries")
+	proto.RegisterType((*FridgeData)(nil), "api.FridgeData")
 	proto.RegisterType((*SetDevInitConfigRequest)(nil), "api.SetDevInitConfigRequest")
 	proto.RegisterType((*SetDevInitConfigResponse)(nil), "api.SetDevInitConfigResponse")
 	proto.RegisterType((*SaveDevDataRequest)(nil), "api.SaveDevDataRequest")
 	proto.RegisterType((*SaveDevDataResponse)(nil), "api.SaveDevDataResponse")
+	proto.RegisterType((*DevDataBatch)(nil), "api.DevDataBatch")
+	proto.RegisterType((*DevDataAck)(nil), "api.DevDataAck")
+	proto.RegisterEnum("api.PayloadFormat", PayloadFormat_name, PayloadFormat_value)
+	proto.RegisterEnum("api.Quality", Quality_name, Quality_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -228,6 +948,12 @@ const _ = grpc.SupportPackageIsVersion4
 type CenterServiceClient interface {
 	SetDevInitConfig(ctx context.Context, in *SetDevInitConfigRequest, opts ...grpc.CallOption) (*SetDevInitConfigResponse, error)
 	SaveDevData(ctx context.Context, in *SaveDevDataRequest, opts ...grpc.CallOption) (*SaveDevDataResponse, error)
+	// StreamDevData keeps a long-lived stream per device: the device pushes
+	// batches with sequence numbers and the center acks each of them.
+	StreamDevData(ctx context.Context, opts ...grpc.CallOption) (CenterService_StreamDevDataClient, error)
+	// RegisterDev provisions the device on its first boot: the center checks
+	// the provisioning token and issues the device credentials.
+	RegisterDev(ctx context.Context, in *RegisterDevRequest, opts ...grpc.CallOption) (*RegisterDevResponse, error)
 }
 
 type centerServiceClient struct {
@@ -256,11 +982,57 @@ func (c *centerServiceClient) SaveDevData(ctx context.Context, in *SaveDevDataRe
 	return out, nil
 }
 
+func (c *centerServiceClient) StreamDevData(ctx context.Context, opts ...grpc.CallOption) (CenterService_StreamDevDataClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_CenterService_serviceDesc.Streams[0], c.cc, "/api.CenterService/StreamDevData", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &centerServiceStreamDevDataClient{stream}
+	return x, nil
+}
+
+type CenterService_StreamDevDataClient interface {
+	Send(*DevDataBatch) error
+	Recv() (*DevDataAck, error)
+	grpc.ClientStream
+}
+
+type centerServiceStreamDevDataClient struct {
+	grpc.ClientStream
+}
+
+func (x *centerServiceStreamDevDataClient) Send(m *DevDataBatch) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *centerServiceStreamDevDataClient) Recv() (*DevDataAck, error) {
+	m := new(DevDataAck)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *centerServiceClient) RegisterDev(ctx context.Context, in *RegisterDevRequest, opts ...grpc.CallOption) (*RegisterDevResponse, error) {
+	out := new(RegisterDevResponse)
+	err := grpc.Invoke(ctx, "/api.CenterService/RegisterDev", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for CenterService service
 
 type CenterServiceServer interface {
 	SetDevInitConfig(context.Context, *SetDevInitConfigRequest) (*SetDevInitConfigResponse, error)
 	SaveDevData(context.Context, *SaveDevDataRequest) (*SaveDevDataResponse, error)
+	// StreamDevData keeps a long-lived stream per device: the device pushes
+	// batches with sequence numbers and the center acks each of them.
+	StreamDevData(CenterService_StreamDevDataServer) error
+	// RegisterDev provisions the device on its first boot: the center checks
+	// the provisioning token and issues the device credentials.
+	RegisterDev(context.Context, *RegisterDevRequest) (*RegisterDevResponse, error)
 }
 
 func RegisterCenterServiceServer(s *grpc.Server, srv CenterServiceServer) {
@@ -303,6 +1075,50 @@ func _CenterService_SaveDevData_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CenterService_StreamDevData_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CenterServiceServer).StreamDevData(&centerServiceStreamDevDataServer{stream})
+}
+
+type CenterService_StreamDevDataServer interface {
+	Send(*DevDataAck) error
+	Recv() (*DevDataBatch, error)
+	grpc.ServerStream
+}
+
+type centerServiceStreamDevDataServer struct {
+	grpc.ServerStream
+}
+
+func (x *centerServiceStreamDevDataServer) Send(m *DevDataAck) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *centerServiceStreamDevDataServer) Recv() (*DevDataBatch, error) {
+	m := new(DevDataBatch)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _CenterService_RegisterDev_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterDevRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CenterServiceServer).RegisterDev(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.CenterService/RegisterDev",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CenterServiceServer).RegisterDev(ctx, req.(*RegisterDevRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _CenterService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "api.CenterService",
 	HandlerType: (*CenterServiceServer)(nil),
@@ -315,36 +1131,104 @@ var _CenterService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "SaveDevData",
 			Handler:    _CenterService_SaveDevData_Handler,
 		},
+		{
+			MethodName: "RegisterDev",
+			Handler:    _CenterService_RegisterDev_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamDevData",
+			Handler:       _CenterService_StreamDevData_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "api.proto",
 }
 
 func init() { proto.RegisterFile("api.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 353 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x92, 0x4d, 0x4f, 0xfa, 0x70,
-	0x0c, 0xc7, 0xff, 0xfb, 0x0f, 0x41, 0x0a, 0x18, 0x52, 0x13, 0x99, 0x44, 0x12, 0x5c, 0x62, 0xe2,
-	0x45, 0x0e, 0xf8, 0x0e, 0x64, 0x1e, 0x38, 0x18, 0xe3, 0xe6, 0x59, 0x53, 0x59, 0x5d, 0x76, 0xd8,
-	0x83, 0x5b, 0x59, 0xc2, 0x1b, 0xf2, 0xea, 0x5b, 0x34, 0xeb, 0xc6, 0x83, 0x12, 0x4e, 0xde, 0xda,
-	0x7e, 0xdb, 0x4f, 0xbf, 0xbf, 0xe6, 0x07, 0x6d, 0x4a, 0xc3, 0x49, 0x9a, 0x25, 0x92, 0xa0, 0x49,
-	0x69, 0x68, 0x7f, 0x19, 0x00, 0xf7, 0x05, 0xc7, 0xe2, 0x49, 0x92, 0x31, 0x5e, 0x42, 0x97, 0x82,
-	0x20, 0xe3, 0x80, 0x84, 0x5f, 0x43, 0xdf, 0x32, 0xc6, 0xc6, 0x75, 0xdb, 0xed, 0x6c, 0x6a, 0x73,
-	0x1f, 0xaf, 0xe0, 0x64, 0xdb, 0x22, 0xab, 0x94, 0xad, 0xff, 0xda, 0xd4, 0xdb, 0x54, 0x9f, 0x57,
-	0x29, 0xe3, 0x39, 0x1c, 0x73, 0xc9, 0x2d, 0x29, 0xa6, 0x36, 0xb4, 0x34, 0x9f, 0xfb, 0x38, 0x02,
-	0xa8, 0x24, 0x9d, 0x6e, 0xa8, 0xd8, 0xd6, 0x8a, 0x4e, 0x6e, 0x64, 0x9f, 0x84, 0xac, 0xa3, 0x1d,
-	0xd9, 0x21, 0x21, 0x7b, 0x06, 0x2d, 0x87, 0x8b, 0x07, 0x16, 0x42, 0x84, 0x86, 0x22, 0x2a, 0x97,
-	0x1a, 0x97, 0xb5, 0x98, 0xa2, 0xb5, 0x29, 0x8d, 0xb1, 0x0f, 0x66, 0x44, 0x8b, 0xda, 0x46, 0x19,
-	0xda, 0x8f, 0x30, 0xf0, 0x58, 0x1c, 0x2e, 0xe6, 0x71, 0x28, 0xb3, 0x24, 0x7e, 0x0f, 0x03, 0x97,
-	0x3f, 0x96, 0x9c, 0x8b, 0x42, 0xc3, 0xa8, 0x82, 0x9a, 0xae, 0xc6, 0x38, 0x86, 0x46, 0xc4, 0x42,
-	0x0a, 0xed, 0x4c, 0xbb, 0x93, 0xf2, 0x88, 0xb5, 0x09, 0x57, 0x15, 0x7b, 0x0a, 0xd6, 0x3e, 0x30,
-	0x4f, 0x93, 0x38, 0x67, 0x3c, 0x83, 0xe6, 0x42, 0x2b, 0xca, 0xec, 0xba, 0x75, 0x66, 0xbf, 0x00,
-	0x7a, 0x54, 0xb0, 0xc3, 0x45, 0xf9, 0xb0, 0x3f, 0xed, 0x2f, 0xa7, 0xf4, 0x5c, 0xa6, 0x6e, 0xd0,
-	0xd8, 0xbe, 0x81, 0xd3, 0x1f, 0xfc, 0xad, 0x9d, 0x5c, 0x48, 0x96, 0x79, 0x7d, 0xb7, 0x3a, 0x9b,
-	0x7e, 0x1a, 0xd0, 0x9b, 0x71, 0x2c, 0x9c, 0x79, 0x9c, 0x15, 0xe1, 0x82, 0xf1, 0x09, 0xfa, 0xbf,
-	0x1f, 0x85, 0x17, 0xba, 0xfc, 0xc0, 0xf1, 0x86, 0xa3, 0x03, 0x6a, 0xb5, 0xda, 0xfe, 0x87, 0x77,
-	0xd0, 0xd9, 0xf1, 0x84, 0x83, 0xaa, 0x7f, 0xef, 0x0a, 0x43, 0x6b, 0x5f, 0x58, 0x33, 0xde, 0x9a,
-	0xfa, 0x7f, 0x6f, 0xbf, 0x03, 0x00, 0x00, 0xff, 0xff, 0x40, 0xe3, 0xf0, 0x1c, 0xcc, 0x02, 0x00,
-	0x00,
+	// 1271 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x57, 0x5f, 0x73, 0xdb, 0x44,
+	0x10, 0x8f, 0x24, 0x27, 0xb6, 0xd7, 0x7f, 0xea, 0x5c, 0x3b, 0xad, 0x08, 0x2d, 0x18, 0xd1, 0x32,
+	0xa1, 0x4c, 0x33, 0xb4, 0xe5, 0xa5, 0xf0, 0x94, 0xc6, 0x2d, 0x94, 0xa1, 0xa4, 0x3d, 0x77, 0x60,
+	0x78, 0xf2, 0x1c, 0xd2, 0xc6, 0x15, 0xb6, 0x25, 0xe5, 0x74, 0x76, 0x26, 0x5f, 0x81, 0x61, 0xf8,
+	0x16, 0xbc, 0xf3, 0xc6, 0x77, 0x80, 0x8f, 0xc2, 0x97, 0x60, 0x6e, 0xef, 0x24, 0xcb, 0xb5, 0x4d,
+	0x99, 0xe1, 0xed, 0x76, 0x7f, 0xbb, 0xda, 0xff, 0xbb, 0x36, 0x34, 0x45, 0x16, 0x1f, 0x65, 0x32,
+	0x55, 0x29, 0xf3, 0x44, 0x16, 0x07, 0x3f, 0x7b, 0x00, 0x4f, 0x16, 0x98, 0xa8, 0xa1, 0x4a, 0x25,
+	0xb2, 0x0f, 0xa0, 0x2d, 0xc6, 0x63, 0x89, 0x63, 0xa1, 0x70, 0x14, 0x47, 0xbe, 0xd3, 0x77, 0x0e,
+	0x9b, 0xbc, 0x55, 0xf2, 0x9e, 0x45, 0xec, 0x0e, 0x74, 0x97, 0x22, 0xea, 0x32, 0x43, 0xdf, 0x25,
+	0xa1, 0x4e, 0xc9, 0x7d, 0x75, 0x99, 0x21, 0x7b, 0x07, 0x1a, 0xa8, 0xbf, 0xab, 0xbf, 0xe2, 0x91,
+	0x40, 0x9d, 0xe8, 0x67, 0x11, 0xbb, 0x05, 0x60, 0x20, 0xd2, 0xae, 0x11, 0xd8, 0x24, 0x0e, 0x69,
+	0x96, 0x70, 0x24, 0x94, 0xf0, 0x77, 0x2b, 0xf0, 0x40, 0x28, 0xc1, 0x7c, 0xa8, 0x2f, 0x50, 0xe6,
+	0x71, 0x9a, 0xf8, 0x7b, 0x7d, 0xe7, 0xb0, 0xc6, 0x0b, 0x92, 0x3d, 0x84, 0x76, 0x98, 0x26, 0x67,
+	0xf1, 0x78, 0x94, 0x09, 0x15, 0xbe, 0xf6, 0xeb, 0x7d, 0xe7, 0xb0, 0xf5, 0xa0, 0x77, 0xa4, 0x43,
+	0x3e, 0x21, 0xe0, 0x85, 0xe6, 0xf3, 0x56, 0xb8, 0x24, 0xd8, 0x3d, 0x00, 0xab, 0x24, 0xc2, 0x89,
+	0xdf, 0x20, 0x95, 0x6e, 0x45, 0xe5, 0x38, 0x9c, 0xf0, 0x66, 0x58, 0x3c, 0xd9, 0x23, 0xe8, 0x4a,
+	0xcc, 0x52, 0xa9, 0x30, 0x1a, 0xe5, 0x4a, 0x28, 0xf4, 0x9b, 0xa4, 0xc2, 0x48, 0x85, 0x5b, 0x68,
+	0xa8, 0x11, 0xde, 0x91, 0x55, 0x92, 0xf5, 0x61, 0x57, 0x4c, 0x85, 0x9c, 0xf9, 0x40, 0x1a, 0x40,
+	0x1a, 0xc7, 0x9a, 0xc3, 0x0d, 0x10, 0xfc, 0x00, 0xad, 0x8a, 0x9f, 0xec, 0x63, 0xd8, 0x33, 0x86,
+	0xa9, 0x0c, 0xad, 0x07, 0xfb, 0xa4, 0xf1, 0x54, 0xc6, 0xd1, 0x18, 0x8d, 0x1c, 0xb7, 0x02, 0xec,
+	0x7d, 0x68, 0xcd, 0xb3, 0x48, 0x57, 0x64, 0x26, 0xf2, 0x89, 0xef, 0xf6, 0xbd, 0xc3, 0x26, 0x07,
+	0xc3, 0x7a, 0x2e, 0xf2, 0x49, 0xf0, 0x97, 0x03, 0xcd, 0x32, 0x20, 0x76, 0x1b, 0xba, 0x94, 0xa2,
+	0x51, 0x59, 0x22, 0x53, 0xe8, 0x36, 0x71, 0x9f, 0xd8, 0x3a, 0x7d, 0x08, 0x1d, 0x23, 0x55, 0xe4,
+	0xdb, 0xa5, 0x7c, 0x1b, 0xa1, 0xef, 0x6c, 0xd2, 0xaf, 0xc3, 0x9e, 0xc4, 0x7c, 0x3e, 0x55, 0xb6,
+	0xca, 0x96, 0x32, 0x7c, 0x91, 0xa7, 0x89, 0x2d, 0xb0, 0xa5, 0x2a, 0x41, 0xed, 0xbe, 0x2d, 0xa8,
+	0xad, 0x95, 0x0e, 0x7e, 0x77, 0xa0, 0xb3, 0x92, 0x6b, 0xc6, 0xa0, 0xa6, 0xe2, 0x19, 0x52, 0x1c,
+	0x1e, 0xa7, 0x77, 0xc5, 0x94, 0xfb, 0x36, 0x53, 0x77, 0xa0, 0x6b, 0xbb, 0xa0, 0xb0, 0xe8, 0x91,
+	0xc5, 0x8e, 0xe1, 0x16, 0xc1, 0x3e, 0xd2, 0x79, 0xbb, 0x9c, 0xa6, 0x22, 0x1a, 0x9d, 0xa5, 0x72,
+	0x26, 0x14, 0x05, 0xd7, 0xb5, 0xd5, 0x7f, 0x61, 0xa0, 0xa7, 0x84, 0xf0, 0x4e, 0x56, 0x25, 0x83,
+	0x3f, 0x1c, 0xd8, 0xa5, 0x62, 0x6b, 0x57, 0xe5, 0x7c, 0x8a, 0x36, 0xe5, 0xf4, 0x66, 0x7d, 0x68,
+	0x85, 0xe9, 0x2c, 0x13, 0x52, 0xcd, 0x30, 0x51, 0x76, 0xa2, 0xaa, 0x2c, 0x76, 0x0d, 0x76, 0x4d,
+	0xbf, 0x99, 0x34, 0x1b, 0x82, 0xdd, 0x04, 0xdd, 0x9b, 0x51, 0xac, 0xe2, 0x32, 0xd1, 0x4b, 0x86,
+	0x46, 0xd5, 0x6b, 0x89, 0xf9, 0xeb, 0x74, 0x1a, 0x51, 0xba, 0x5d, 0xbe, 0x64, 0x50, 0xca, 0x70,
+	0x96, 0x51, 0x6e, 0x5d, 0x4e, 0xef, 0x32, 0x8d, 0xf5, 0x65, 0x1a, 0x83, 0x3b, 0xd0, 0x20, 0xc7,
+	0x75, 0xe3, 0x54, 0xa7, 0xda, 0x59, 0x99, 0xea, 0x40, 0xc2, 0x7e, 0x75, 0xc8, 0xf0, 0x27, 0x0c,
+	0xd5, 0xbf, 0xc8, 0x57, 0xab, 0xeb, 0xae, 0xce, 0x71, 0x0f, 0xbc, 0x99, 0x08, 0x6d, 0xa0, 0xfa,
+	0xb9, 0xad, 0x99, 0x82, 0x21, 0xd4, 0x07, 0xb8, 0x78, 0x8e, 0x4a, 0x90, 0xe7, 0x7a, 0x9d, 0xd8,
+	0xac, 0xea, 0xb7, 0xe6, 0x25, 0x62, 0x56, 0x2c, 0x28, 0x7a, 0x6f, 0xf8, 0x78, 0x17, 0xdc, 0x38,
+	0xb2, 0x1f, 0x76, 0xe3, 0x28, 0x58, 0x00, 0xe3, 0x38, 0x8e, 0x73, 0x85, 0x72, 0x80, 0x0b, 0x8e,
+	0xe7, 0x73, 0xcc, 0x15, 0xeb, 0x43, 0x6d, 0x86, 0x4a, 0xd8, 0x51, 0x6c, 0x53, 0xc1, 0xad, 0x6d,
+	0x4e, 0x88, 0x76, 0x32, 0x47, 0x19, 0x8b, 0xa9, 0xb5, 0x67, 0x29, 0x5d, 0x39, 0x95, 0x4e, 0x30,
+	0x29, 0x2a, 0x47, 0x84, 0xf6, 0x23, 0xcc, 0x25, 0x99, 0x6d, 0x73, 0xfd, 0x0c, 0x52, 0xb8, 0xba,
+	0x62, 0x37, 0xcf, 0xd2, 0x24, 0x37, 0xad, 0x81, 0x52, 0xc5, 0x67, 0x71, 0xa8, 0xcb, 0xef, 0x90,
+	0x42, 0x95, 0xc5, 0x6e, 0x40, 0x3d, 0x14, 0x23, 0xcd, 0x21, 0xcb, 0x6d, 0xbe, 0x17, 0x8a, 0x13,
+	0x94, 0x4a, 0x6f, 0xd2, 0x44, 0xa8, 0x7c, 0x14, 0x4a, 0x8c, 0x72, 0x32, 0xdf, 0xe6, 0x4d, 0xcd,
+	0x39, 0xd1, 0x8c, 0xe0, 0x6f, 0x07, 0xda, 0xd5, 0x69, 0x60, 0xef, 0x42, 0x53, 0xcd, 0x65, 0x82,
+	0xd1, 0x28, 0x4d, 0xc8, 0x50, 0x83, 0x37, 0x0c, 0xe3, 0x34, 0xd1, 0xa7, 0x21, 0x4c, 0xa7, 0x53,
+	0x0c, 0xd5, 0xe8, 0x4c, 0xe2, 0x39, 0x99, 0xf2, 0x78, 0xcb, 0xf2, 0x9e, 0x4a, 0x3c, 0xd7, 0xfa,
+	0x39, 0x26, 0x91, 0xc1, 0x3d, 0xc2, 0x1b, 0x9a, 0x41, 0xe0, 0x7b, 0x00, 0x51, 0x7a, 0x91, 0xe4,
+	0x62, 0x96, 0x4d, 0x8b, 0xad, 0x5f, 0xe1, 0xb0, 0x4f, 0x60, 0x7f, 0x49, 0x8d, 0x2e, 0xe2, 0x24,
+	0x4a, 0x2f, 0xa8, 0x69, 0x3d, 0xde, 0x5b, 0x02, 0xdf, 0x13, 0x9f, 0xdd, 0x87, 0x6b, 0x15, 0xe1,
+	0x08, 0x17, 0xb1, 0x50, 0xc5, 0x9e, 0x70, 0xf9, 0xd5, 0x25, 0x36, 0x28, 0xa0, 0x20, 0x87, 0x8e,
+	0x09, 0x96, 0xa3, 0x88, 0xe2, 0x64, 0xbc, 0x71, 0x65, 0x14, 0x33, 0xe1, 0xae, 0xce, 0xc4, 0x3c,
+	0x89, 0x8b, 0xfd, 0x46, 0x6f, 0xf6, 0x11, 0xd4, 0xcf, 0xe7, 0x62, 0x1a, 0xab, 0x4b, 0xbb, 0x01,
+	0x4c, 0x43, 0xbc, 0x34, 0x3c, 0x5e, 0x80, 0xc1, 0x44, 0x0f, 0x45, 0x39, 0xc4, 0x43, 0x94, 0x31,
+	0xe6, 0x65, 0x5b, 0x3a, 0x95, 0xb6, 0x2c, 0x8c, 0xb8, 0x15, 0x23, 0x47, 0xd0, 0x90, 0xc6, 0x57,
+	0x5d, 0x3c, 0xaf, 0xbc, 0x32, 0x2b, 0x61, 0xf0, 0x52, 0x26, 0xf8, 0x0a, 0xc0, 0x40, 0x74, 0x27,
+	0x3f, 0xd7, 0xf5, 0x2a, 0x4d, 0xe7, 0xbe, 0x43, 0x5f, 0xb8, 0x6e, 0x4f, 0xdb, 0x1b, 0x3e, 0xf1,
+	0x15, 0xd9, 0xe0, 0x17, 0x07, 0x6e, 0x0c, 0x51, 0x0d, 0x70, 0xf1, 0x2c, 0x89, 0x95, 0xdd, 0x95,
+	0x76, 0x10, 0x36, 0xa5, 0xad, 0x18, 0x0e, 0x77, 0xeb, 0x70, 0x7c, 0x01, 0x57, 0x56, 0x37, 0xa7,
+	0x09, 0x69, 0xf3, 0xea, 0xec, 0xae, 0xac, 0xce, 0x3c, 0xf8, 0xd3, 0x01, 0x7f, 0xdd, 0x1d, 0x3b,
+	0x1f, 0xd7, 0x57, 0xae, 0x64, 0xbb, 0x5c, 0xe9, 0xeb, 0xbb, 0xda, 0xfd, 0x8f, 0xbb, 0x9a, 0x7d,
+	0x06, 0x6d, 0xbd, 0x3f, 0xa2, 0x91, 0xfd, 0xb0, 0xb7, 0xed, 0x7c, 0xb4, 0x48, 0xec, 0x64, 0xdb,
+	0x0d, 0xa9, 0x6d, 0xb8, 0x21, 0xc1, 0xaf, 0x0e, 0xb0, 0xa1, 0x58, 0xe8, 0xce, 0xd4, 0x75, 0xfa,
+	0x7f, 0x69, 0x65, 0x50, 0xa3, 0x5f, 0x49, 0x66, 0xb6, 0xe9, 0xcd, 0x8e, 0x00, 0x8c, 0xf7, 0x84,
+	0xd4, 0x48, 0xf7, 0x4a, 0xc5, 0x77, 0xb2, 0xda, 0x24, 0x11, 0xfd, 0x0c, 0xee, 0xc1, 0xd5, 0x15,
+	0x7f, 0x96, 0x79, 0xd5, 0x37, 0x66, 0x9e, 0xdb, 0x3e, 0xb5, 0x54, 0x30, 0x84, 0xb6, 0x15, 0x7d,
+	0x4c, 0xbf, 0x52, 0x7a, 0xe0, 0xe5, 0x78, 0x4e, 0x42, 0x35, 0xae, 0x9f, 0xec, 0x3e, 0xd4, 0xa5,
+	0x89, 0xca, 0x7a, 0x7e, 0x83, 0xac, 0xaf, 0x07, 0xcd, 0x0b, 0xb9, 0xe0, 0x1b, 0x00, 0x0b, 0xe9,
+	0x2b, 0xb3, 0xfe, 0xc9, 0xa5, 0x33, 0x6e, 0xd5, 0x19, 0xbd, 0x5b, 0x51, 0xca, 0x54, 0x16, 0xbb,
+	0x95, 0x88, 0xbb, 0xb7, 0xa1, 0xb3, 0x52, 0x5f, 0xd6, 0x80, 0xda, 0xd7, 0xc3, 0xd3, 0x6f, 0x7b,
+	0x3b, 0xac, 0x09, 0xbb, 0x2f, 0xf8, 0xe9, 0xab, 0xd3, 0x9e, 0x73, 0xf7, 0x26, 0xd4, 0xed, 0xbc,
+	0x6a, 0xfc, 0xcb, 0xd3, 0xd3, 0x41, 0x6f, 0x87, 0xd5, 0xc1, 0x7b, 0x7c, 0x3c, 0xe8, 0x39, 0x0f,
+	0x7e, 0x73, 0xa1, 0x73, 0x82, 0x89, 0x42, 0x39, 0x44, 0xb9, 0x88, 0x43, 0x64, 0x2f, 0xa1, 0xf7,
+	0x66, 0x13, 0xb2, 0x9b, 0x26, 0xb2, 0xcd, 0xa3, 0x72, 0x70, 0x6b, 0x0b, 0x6a, 0x32, 0x1c, 0xec,
+	0xb0, 0xc7, 0xd0, 0xaa, 0x64, 0x85, 0x6d, 0xcb, 0xd3, 0x81, 0xbf, 0x0e, 0x94, 0xdf, 0x78, 0x04,
+	0x9d, 0xa1, 0x92, 0x28, 0x66, 0xc5, 0x57, 0xf6, 0x8b, 0x3e, 0x29, 0x6b, 0x74, 0x70, 0xa5, 0xca,
+	0x3a, 0x0e, 0x27, 0xc1, 0xce, 0xa1, 0xf3, 0xa9, 0xa3, 0xcd, 0x57, 0x2e, 0x8e, 0x35, 0xbf, 0x7e,
+	0xfb, 0x0e, 0xfc, 0x75, 0xa0, 0x30, 0xff, 0xe3, 0x1e, 0xfd, 0x99, 0x78, 0xf8, 0xcf, 0x00, 0x7d,
+	0xa9, 0x84, 0x33, 0x59, 0x0c, 0x00, 0x00,
 }
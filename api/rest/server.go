@@ -0,0 +1,256 @@
+// Package rest provides the local HTTP API for inspecting and controlling
+// a running device.
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/alarm"
+	"github.com/kostiamol/fridgems/entities"
+	"github.com/kostiamol/fridgems/metrics"
+	"github.com/kostiamol/fridgems/services"
+	"github.com/kostiamol/fridgems/supervisor"
+)
+
+const (
+	shutdownTimeout = time.Second * 5
+	maxPatchSize    = 1 << 12
+)
+
+// Status is used to represent the state of the device returned by /status.
+type Status struct {
+	Meta          entities.DevMeta
+	Config        services.FridgeConfig
+	ConfigVersion uint64
+	LastReadings  map[string]services.FridgeDatum
+	Queues        Queues
+	Connections   Connections
+	Workers       []supervisor.WorkerHealth
+}
+
+// Queues is used to store the depths of the data pipeline queues.
+type Queues struct {
+	Readings int
+	Requests int
+	Outbox   int
+	Send     int
+}
+
+// Connections is used to store the states of the connections to the center
+// and NATS and the payload format negotiated with the center.
+type Connections struct {
+	Center        string
+	NATS          string
+	PayloadFormat string
+}
+
+// Alarms is used to represent the alarms returned by /alarms.
+type Alarms struct {
+	Active  []alarm.Event
+	Pending []alarm.Event
+}
+
+// ConfigPatch is used to decode patches of the configuration accepted
+// via PATCH /config. Only the fields that are present are changed.
+type ConfigPatch struct {
+	TurnedOn            *bool    `json:",omitempty"`
+	CollectFreq         *int64   `json:",omitempty"`
+	SendFreq            *int64   `json:",omitempty"`
+	Downsample          *string  `json:",omitempty"`
+	DownsampleWindow    *int64   `json:",omitempty"`
+	DownsampleDeviation *float32 `json:",omitempty"`
+}
+
+// Server is used to serve the local HTTP API of the device.
+type Server struct {
+	Addr       string
+	Meta       *entities.DevMeta
+	Config     *services.ConfigService
+	Data       *services.DataService
+	Alarms     *services.AlarmService
+	Supervisor *supervisor.Supervisor
+	Log        *logrus.Logger
+}
+
+// NewServer creates and initializes new Server object.
+// It returns initialized object.
+func NewServer(addr string, m *entities.DevMeta, cs *services.ConfigService, ds *services.DataService,
+	as *services.AlarmService, sup *supervisor.Supervisor, l *logrus.Logger) *Server {
+	return &Server{
+		Addr:       addr,
+		Meta:       m,
+		Config:     cs,
+		Data:       ds,
+		Alarms:     as,
+		Supervisor: sup,
+		Log:        l,
+	}
+}
+
+// Run serves the API until ctx is done.
+func (s *Server) Run(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	s.Log.Infof("local API is listening on %s", l.Addr())
+	if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	s.Log.Info("local API has stopped")
+	return nil
+}
+
+// Handler returns the handler serving the API routes:
+//
+//	GET   /status    full state of the device
+//	GET   /meta      device metadata
+//	GET   /readings  the last reading of each compartment
+//	GET   /alarms    raised alarms and alarm events not acked by the center
+//	GET   /health    health of the workers, fails unless all of them are running or finished
+//	GET   /metrics   metrics in the Prometheus text format
+//	GET   /config    current configuration
+//	PATCH /config    patch of TurnedOn, CollectFreq, SendFreq and the downsampling settings
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/meta", s.handleMeta)
+	mux.HandleFunc("/readings", s.handleReadings)
+	mux.HandleFunc("/alarms", s.handleAlarms)
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/config", s.handleConfig)
+	mux.Handle("/metrics", metrics.Default.Handler())
+	return mux
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, Status{
+		Meta:          *s.Meta,
+		Config:        s.Config.Config.GetFridgeConfig(),
+		ConfigVersion: s.Config.Config.GetVersion(),
+		LastReadings:  s.Data.LastReadings(),
+		Queues: Queues{
+			Readings: len(s.Data.Readings),
+			Requests: len(s.Data.ReqChan),
+			Outbox:   s.Data.Outbox.Len(),
+			Send:     s.Data.SendQueue.Len(),
+		},
+		Connections: Connections{
+			Center:        s.Data.CenterState(),
+			NATS:          s.Config.NATSState(),
+			PayloadFormat: s.Config.Config.GetPayloadFormat().String(),
+		},
+		Workers: s.Supervisor.Health(),
+	})
+}
+
+func (s *Server) handleMeta(w http.ResponseWriter, r *http.Request) {
+	if allowMethods(w, r, http.MethodGet) {
+		s.writeJSON(w, http.StatusOK, s.Meta)
+	}
+}
+
+func (s *Server) handleReadings(w http.ResponseWriter, r *http.Request) {
+	if allowMethods(w, r, http.MethodGet) {
+		s.writeJSON(w, http.StatusOK, s.Data.LastReadings())
+	}
+}
+
+func (s *Server) handleAlarms(w http.ResponseWriter, r *http.Request) {
+	if allowMethods(w, r, http.MethodGet) {
+		s.writeJSON(w, http.StatusOK, Alarms{
+			Active:  s.Alarms.Active(),
+			Pending: s.Alarms.Pending(),
+		})
+	}
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	status := http.StatusOK
+	hs := s.Supervisor.Health()
+	for _, h := range hs {
+		// one-shot workers that have finished their job are healthy
+		if h.State != supervisor.StateRunning && h.State != supervisor.StateFinished {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	s.writeJSON(w, status, hs)
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPatch) {
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		var patch ConfigPatch
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPatchSize))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&patch); err != nil {
+			s.writeError(w, http.StatusBadRequest, "invalid config patch: "+err.Error())
+			return
+		}
+
+		b, err := json.Marshal(patch)
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err := s.Config.PatchConfig(b); err != nil {
+			s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		s.Log.Infof("config has been patched via local API: %s", bytes.TrimSpace(b))
+	}
+
+	s.writeJSON(w, http.StatusOK, s.Config.Config.GetFridgeConfig())
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.Log.Errorf("Server: writeJSON(): Encode() has failed: %s", err)
+	}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, msg string) {
+	s.writeJSON(w, status, struct{ Error string }{msg})
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+
+	for _, m := range methods {
+		w.Header().Add("Allow", m)
+	}
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	return false
+}
@@ -0,0 +1,273 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/entities"
+	"github.com/kostiamol/fridgems/services"
+	"github.com/kostiamol/fridgems/supervisor"
+)
+
+// newTestServer returns the server of the device with the default config
+// whose services aren't connected anywhere, and the function removing
+// the device's outbox.
+func newTestServer(t *testing.T) (*Server, func()) {
+	dir, err := ioutil.TempDir("", "rest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := logrus.New()
+	l.Out = ioutil.Discard
+
+	meta := &entities.DevMeta{Type: "fridge", Name: "fridge-test", MAC: "0A-1B-2C-3D-4E-5F"}
+	cs := services.NewConfigService(meta, entities.Server{}, services.NATSConfig{}, nil, l, time.Second, time.Minute)
+	cs.Config.SetFridgeConfig(services.DefaultFridgeConfig)
+	outbox, err := services.NewOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := services.NewDataService(cs.Config, meta, entities.Server{}, nil, outbox,
+		services.NewSendQueue(16, services.DropNewest), 1, nil, l, time.Second, time.Second)
+	sup := supervisor.New(context.Background(), l)
+	sup.Go("loop", blockingWorker)
+
+	cleanup := func() {
+		sup.Stop()
+		sup.Wait()
+		os.RemoveAll(dir)
+	}
+	return NewServer("", meta, cs, ds, nil, sup, l), cleanup
+}
+
+func TestStatus(t *testing.T) {
+	srv, cleanup := newTestServer(t)
+	defer cleanup()
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	var got Status
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Meta != *srv.Meta {
+		t.Errorf("Meta = %+v, want %+v", got.Meta, *srv.Meta)
+	}
+	if got.Config != services.DefaultFridgeConfig {
+		t.Errorf("Config = %+v, want %+v", got.Config, services.DefaultFridgeConfig)
+	}
+	if got.Connections.NATS != "DISCONNECTED" {
+		t.Errorf("Connections.NATS = %q, want DISCONNECTED", got.Connections.NATS)
+	}
+	if len(got.Workers) != 1 || got.Workers[0].Name != "loop" {
+		t.Errorf("Workers = %+v, want loop", got.Workers)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	paused := services.DefaultFridgeConfig
+	paused.TurnedOn, paused.SendFreq = false, 10000
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+		// wantAllow is the Allow header expected if the method isn't allowed.
+		wantAllow []string
+		// wantConfig is the config expected after the request.
+		wantConfig services.FridgeConfig
+	}{
+		{name: "get", method: http.MethodGet, want: http.StatusOK, wantConfig: services.DefaultFridgeConfig},
+		{
+			name:       "patch",
+			method:     http.MethodPatch,
+			body:       `{"TurnedOn":false,"SendFreq":10000}`,
+			want:       http.StatusOK,
+			wantConfig: paused,
+		},
+		{
+			name:       "invalid JSON",
+			method:     http.MethodPatch,
+			body:       `{"TurnedOn":`,
+			want:       http.StatusBadRequest,
+			wantConfig: services.DefaultFridgeConfig,
+		},
+		{
+			name:       "unknown field",
+			method:     http.MethodPatch,
+			body:       `{"Temp":4}`,
+			want:       http.StatusBadRequest,
+			wantConfig: services.DefaultFridgeConfig,
+		},
+		{
+			name:       "invalid config",
+			method:     http.MethodPatch,
+			body:       `{"SendFreq":1}`,
+			want:       http.StatusUnprocessableEntity,
+			wantConfig: services.DefaultFridgeConfig,
+		},
+		{
+			name:       "method not allowed",
+			method:     http.MethodDelete,
+			want:       http.StatusMethodNotAllowed,
+			wantAllow:  []string{http.MethodGet, http.MethodPatch},
+			wantConfig: services.DefaultFridgeConfig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, cleanup := newTestServer(t)
+			defer cleanup()
+
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(tt.method, "/config", strings.NewReader(tt.body)))
+			if rec.Code != tt.want {
+				t.Fatalf("%s /config = %d, want %d: %s", tt.method, rec.Code, tt.want, rec.Body)
+			}
+			if allow := rec.Header()["Allow"]; !reflect.DeepEqual(allow, tt.wantAllow) {
+				t.Errorf("Allow = %v, want %v", allow, tt.wantAllow)
+			}
+
+			if got := srv.Config.Config.GetFridgeConfig(); got != tt.wantConfig {
+				t.Errorf("config = %+v, want %+v", got, tt.wantConfig)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got services.FridgeConfig
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.wantConfig {
+				t.Errorf("returned config = %+v, want %+v", got, tt.wantConfig)
+			}
+		})
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	srv, cleanup := newTestServer(t)
+	defer cleanup()
+
+	for _, path := range []string{"/status", "/meta", "/readings", "/alarms", "/health"} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodGet {
+			t.Errorf("POST %s = %d with Allow %q, want %d with Allow GET",
+				path, rec.Code, rec.Header().Get("Allow"), http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers map[string]supervisor.Worker
+		want    int
+	}{
+		{
+			name: "running",
+			workers: map[string]supervisor.Worker{
+				"loop": blockingWorker,
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "one-shot worker has finished",
+			workers: map[string]supervisor.Worker{
+				"loop":       blockingWorker,
+				"initConfig": func(ctx context.Context) error { return nil },
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "worker is restarting",
+			workers: map[string]supervisor.Worker{
+				"loop":       blockingWorker,
+				"initConfig": func(ctx context.Context) error { return errors.New("center is unreachable") },
+			},
+			want: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := logrus.New()
+			l.Out = ioutil.Discard
+			sup := supervisor.New(context.Background(), l)
+			sup.MinBackoff = time.Hour
+			defer func() {
+				sup.Stop()
+				sup.Wait()
+			}()
+
+			for name, w := range tt.workers {
+				sup.Go(name, w)
+			}
+			waitSettled(t, sup)
+
+			srv := &Server{Supervisor: sup, Log: l}
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+			if rec.Code != tt.want {
+				t.Errorf("GET /health = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestHealthAfterStop(t *testing.T) {
+	l := logrus.New()
+	l.Out = ioutil.Discard
+	sup := supervisor.New(context.Background(), l)
+	sup.Go("loop", blockingWorker)
+	sup.Stop()
+	sup.Wait()
+
+	srv := &Server{Supervisor: sup, Log: l}
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /health = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func blockingWorker(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+// waitSettled waits until the workers that return right away have done so.
+func waitSettled(t *testing.T, sup *supervisor.Supervisor) {
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		settled := true
+		for _, h := range sup.Health() {
+			if h.Name == "initConfig" && h.State == supervisor.StateRunning {
+				settled = false
+			}
+		}
+		if settled {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatal("workers haven't settled in time")
+}
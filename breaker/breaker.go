@@ -0,0 +1,147 @@
+// Package breaker provides a circuit breaker that stops calling a remote
+// service that keeps failing and lets a single probe call through once
+// the service has had time to recover.
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/kostiamol/fridgems/clock"
+)
+
+// ErrOpen is returned by Allow while the breaker is open.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is used to specify whether the calls are let through.
+type State int
+
+// States of the breaker.
+const (
+	// Closed lets all the calls through.
+	Closed State = iota
+	// Open rejects all the calls till Cooldown passes.
+	Open
+	// HalfOpen lets a single probe call through, its result decides
+	// whether the breaker is closed or opened again.
+	HalfOpen
+)
+
+// States lists all the states of the breaker.
+var States = []State{Closed, Open, HalfOpen}
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker is used to guard the calls of a remote service. It's opened
+// after Threshold failures in a row and stays open for Cooldown of Clock.
+// OnStateChange, if set, is called on every transition with the lock released.
+type Breaker struct {
+	Threshold     int
+	Cooldown      time.Duration
+	OnStateChange func(from, to State)
+	Clock         clock.Clock
+	mu            sync.Mutex
+	state         State
+	failures      int
+	openedAt      time.Time
+	probeAt       time.Time
+	probing       bool
+}
+
+// New creates and initializes new closed Breaker object.
+// It returns initialized object.
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		Clock:     clock.Real,
+	}
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && b.Clock.Since(b.openedAt) >= b.Cooldown {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// Allow reports whether the call may be made. It returns ErrOpen while the
+// breaker is open or the probe call is in flight. A probe whose result hasn't
+// been reported within Cooldown is considered to be lost and another one
+// is let through.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	from := b.state
+
+	switch b.state {
+	case Open:
+		if b.Clock.Since(b.openedAt) < b.Cooldown {
+			b.mu.Unlock()
+			return ErrOpen
+		}
+		b.state = HalfOpen
+		b.probing = false
+		fallthrough
+	case HalfOpen:
+		if b.probing && b.Clock.Since(b.probeAt) < b.Cooldown {
+			b.mu.Unlock()
+			return ErrOpen
+		}
+		b.probing = true
+		b.probeAt = b.Clock.Now()
+	}
+
+	to := b.state
+	b.mu.Unlock()
+	b.changed(from, to)
+	return nil
+}
+
+// Success reports that the call has succeeded and closes the breaker.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	from := b.state
+	b.state = Closed
+	b.failures = 0
+	b.probing = false
+	b.mu.Unlock()
+	b.changed(from, Closed)
+}
+
+// Failure reports that the call has failed. It opens the breaker when
+// the probe has failed or Threshold failures have happened in a row.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	from := b.state
+	b.failures++
+	if b.state == HalfOpen || (b.state == Closed && b.failures >= b.Threshold) {
+		b.state = Open
+		b.openedAt = b.Clock.Now()
+		b.probing = false
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.changed(from, to)
+}
+
+func (b *Breaker) changed(from, to State) {
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(from, to)
+	}
+}
@@ -0,0 +1,72 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kostiamol/fridgems/clock"
+)
+
+func TestBreakerCooldown(t *testing.T) {
+	tests := []struct {
+		name string
+		// probe is the result of the probe call let through after the cooldown.
+		probe func(b *Breaker)
+		want  State
+	}{
+		{name: "probe has succeeded", probe: (*Breaker).Success, want: Closed},
+		{name: "probe has failed", probe: (*Breaker).Failure, want: Open},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := clock.NewFake(time.Unix(1543846800, 0))
+			b := New(2, time.Minute)
+			b.Clock = c
+
+			b.Failure()
+			b.Failure()
+			if err := b.Allow(); err != ErrOpen {
+				t.Fatalf("Allow() = %v after %d failures, want ErrOpen", err, b.Threshold)
+			}
+
+			c.Advance(time.Minute - time.Second)
+			if st := b.State(); st != Open {
+				t.Fatalf("State() = %s before the cooldown, want open", st)
+			}
+
+			c.Advance(time.Second)
+			if st := b.State(); st != HalfOpen {
+				t.Fatalf("State() = %s after the cooldown, want half-open", st)
+			}
+			if err := b.Allow(); err != nil {
+				t.Fatalf("probe hasn't been allowed: %s", err)
+			}
+			if err := b.Allow(); err != ErrOpen {
+				t.Fatalf("second probe has been allowed: %v", err)
+			}
+
+			tt.probe(b)
+			if st := b.State(); st != tt.want {
+				t.Errorf("State() = %s after the probe, want %s", st, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakerLostProbe(t *testing.T) {
+	c := clock.NewFake(time.Unix(1543846800, 0))
+	b := New(1, time.Minute)
+	b.Clock = c
+
+	b.Failure()
+	c.Advance(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe hasn't been allowed: %s", err)
+	}
+
+	c.Advance(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Errorf("probe hasn't been allowed after the lost one: %s", err)
+	}
+}
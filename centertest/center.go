@@ -0,0 +1,436 @@
+// Package centertest provides an in-process fake of the center and an
+// embedded NATS server, so the device can be tested end to end in go test
+// without a running centerms and gnatsd:
+//
+//	c := centertest.NewCenter()
+//	if err := c.Start(); err != nil { ... }
+//	defer c.Stop()
+//	n, err := centertest.StartNATS()
+//	if err != nil { ... }
+//	defer n.Shutdown()
+//
+// c.Server() and n.URL() are then passed to the services instead of the
+// addresses of the real center and NATS.
+package centertest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kostiamol/fridgems/api/pb"
+	"github.com/kostiamol/fridgems/entities"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Names of the CenterService methods that errors can be injected into.
+const (
+	MethodSetDevInitConfig = "SetDevInitConfig"
+	MethodSaveDevData      = "SaveDevData"
+	MethodStreamDevData    = "StreamDevData"
+	MethodRegisterDev      = "RegisterDev"
+)
+
+// ErrUnavailable is a ready-made error of the center that is down.
+var ErrUnavailable = status.Error(codes.Unavailable, "centertest: center is unavailable")
+
+// DefaultConfig is the config returned by SetDevInitConfig unless other
+// responses have been scripted.
+var DefaultConfig = api.FridgeConfig{
+	TurnedOn:    true,
+	CollectFreq: 100,
+	SendFreq:    500,
+}
+
+// Center is an in-memory CenterServiceServer that serves both the config
+// and the data calls of the device. It records the requests it receives,
+// returns the scripted SetDevInitConfig responses and can be made slow
+// or failing. RegisterDev issues the device certificates with an in-memory CA.
+type Center struct {
+	mu             sync.Mutex
+	listener       net.Listener
+	server         *grpc.Server
+	initResponses  []*api.SetDevInitConfigResponse
+	initRequests   []*api.SetDevInitConfigRequest
+	saved          []*api.SaveDevDataRequest
+	errs           map[string][]error
+	latency        time.Duration
+	streamDisabled bool
+	savedNotify    chan struct{}
+	token          string
+	issuedCN       string
+	registrations  []*api.RegisterDevRequest
+	caKey          *ecdsa.PrivateKey
+	caCert         *x509.Certificate
+	caPEM          []byte
+}
+
+// NewCenter creates and initializes new Center object.
+// It returns initialized object.
+func NewCenter() *Center {
+	return &Center{
+		errs:        make(map[string][]error),
+		savedNotify: make(chan struct{}),
+	}
+}
+
+// Start serves the center on a random local port.
+func (c *Center) Start() error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	s := grpc.NewServer()
+	api.RegisterCenterServiceServer(s, c)
+
+	c.mu.Lock()
+	c.listener, c.server = l, s
+	c.mu.Unlock()
+
+	go s.Serve(l)
+	return nil
+}
+
+// Stop stops the center and closes all the connections to it.
+func (c *Center) Stop() {
+	c.mu.Lock()
+	s := c.server
+	c.mu.Unlock()
+
+	if s != nil {
+		s.Stop()
+	}
+}
+
+// Server returns the address of the center to be passed to the services
+// both as the config and the data server.
+func (c *Center) Server() entities.Server {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	host, port, _ := net.SplitHostPort(c.listener.Addr().String())
+	return entities.Server{Host: host, Port: port}
+}
+
+// ScriptInitConfig sets the responses returned by SetDevInitConfig in turn,
+// the last one is repeated once the others have been returned.
+func (c *Center) ScriptInitConfig(responses ...*api.SetDevInitConfigResponse) {
+	c.mu.Lock()
+	c.initResponses = responses
+	c.mu.Unlock()
+}
+
+// FailNext makes the next calls of the method fail with errs in turn.
+// A nil error lets the call through.
+func (c *Center) FailNext(method string, errs ...error) {
+	c.mu.Lock()
+	c.errs[method] = append(c.errs[method], errs...)
+	c.mu.Unlock()
+}
+
+// SetLatency delays every call by d.
+func (c *Center) SetLatency(d time.Duration) {
+	c.mu.Lock()
+	c.latency = d
+	c.mu.Unlock()
+}
+
+// DisableStreaming makes StreamDevData return Unimplemented like the
+// centers that only support SaveDevData.
+func (c *Center) DisableStreaming(disabled bool) {
+	c.mu.Lock()
+	c.streamDisabled = disabled
+	c.mu.Unlock()
+}
+
+// SetProvisioningToken makes RegisterDev reject the devices that don't
+// send token. An empty token lets all the devices register.
+func (c *Center) SetProvisioningToken(token string) {
+	c.mu.Lock()
+	c.token = token
+	c.mu.Unlock()
+}
+
+// SetIssuedCommonName makes RegisterDev issue the certificates for cn rather
+// than for the subject of the certificate request, like a misbehaving center.
+// An empty cn restores the subject.
+func (c *Center) SetIssuedCommonName(cn string) {
+	c.mu.Lock()
+	c.issuedCN = cn
+	c.mu.Unlock()
+}
+
+// Registrations returns the RegisterDev requests accepted so far.
+func (c *Center) Registrations() []*api.RegisterDevRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	regs := make([]*api.RegisterDevRequest, len(c.registrations))
+	copy(regs, c.registrations)
+	return regs
+}
+
+// CACert returns the PEM encoded certificate of the CA issuing the device
+// certificates or nil if no device has registered yet.
+func (c *Center) CACert() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.caPEM
+}
+
+// InitRequests returns the SetDevInitConfig requests received so far.
+func (c *Center) InitRequests() []*api.SetDevInitConfigRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reqs := make([]*api.SetDevInitConfigRequest, len(c.initRequests))
+	copy(reqs, c.initRequests)
+	return reqs
+}
+
+// Saved returns the data batches saved so far, both by SaveDevData and
+// by StreamDevData, in the order they have been received.
+func (c *Center) Saved() []*api.SaveDevDataRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	saved := make([]*api.SaveDevDataRequest, len(c.saved))
+	copy(saved, c.saved)
+	return saved
+}
+
+// WaitSaved waits until at least n batches have been saved or ctx is done.
+// It returns the saved batches and ctx.Err() in the latter case.
+func (c *Center) WaitSaved(ctx context.Context, n int) ([]*api.SaveDevDataRequest, error) {
+	for {
+		c.mu.Lock()
+		notify := c.savedNotify
+		saved := len(c.saved)
+		c.mu.Unlock()
+
+		if saved >= n {
+			return c.Saved(), nil
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return c.Saved(), ctx.Err()
+		}
+	}
+}
+
+// SetDevInitConfig records the request and returns the next scripted
+// response or DefaultConfig in the best payload format the device supports.
+func (c *Center) SetDevInitConfig(ctx context.Context, r *api.SetDevInitConfigRequest) (*api.SetDevInitConfigResponse, error) {
+	if err := c.call(ctx, MethodSetDevInitConfig); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.initRequests = append(c.initRequests, r)
+	if n := len(c.initResponses); n > 0 {
+		resp := c.initResponses[0]
+		if n > 1 {
+			c.initResponses = c.initResponses[1:]
+		}
+		return resp, nil
+	}
+
+	config := DefaultConfig
+	for _, f := range r.PayloadFormats {
+		if f == api.PayloadFormat_PROTO {
+			return ProtoConfig(&config, 0), nil
+		}
+	}
+	return JSONConfig(&config, 0)
+}
+
+// SaveDevData records the batch.
+func (c *Center) SaveDevData(ctx context.Context, r *api.SaveDevDataRequest) (*api.SaveDevDataResponse, error) {
+	if err := c.call(ctx, MethodSaveDevData); err != nil {
+		return nil, err
+	}
+
+	c.save(r)
+	return &api.SaveDevDataResponse{Status: "OK"}, nil
+}
+
+// StreamDevData records the batches and acks each of them.
+func (c *Center) StreamDevData(st api.CenterService_StreamDevDataServer) error {
+	c.mu.Lock()
+	disabled := c.streamDisabled
+	c.mu.Unlock()
+	if disabled {
+		return status.Error(codes.Unimplemented, "centertest: streaming is disabled")
+	}
+
+	for {
+		b, err := st.Recv()
+		if err != nil {
+			return err
+		}
+		if err := c.call(st.Context(), MethodStreamDevData); err != nil {
+			return err
+		}
+
+		c.save(b.Request)
+		if err := st.Send(&api.DevDataAck{Seq: b.Seq, Status: "OK"}); err != nil {
+			return err
+		}
+	}
+}
+
+// RegisterDev checks the provisioning token and issues the certificate for
+// the certificate request of the device.
+func (c *Center) RegisterDev(ctx context.Context, r *api.RegisterDevRequest) (*api.RegisterDevResponse, error) {
+	if err := c.call(ctx, MethodRegisterDev); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && r.Token != c.token {
+		return nil, status.Error(codes.PermissionDenied, "centertest: invalid provisioning token")
+	}
+	block, _ := pem.Decode(r.Csr)
+	if block == nil {
+		return nil, status.Error(codes.InvalidArgument, "centertest: certificate request is missing")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := c.initCA(); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	subject := csr.Subject
+	if c.issuedCN != "" {
+		subject.CommonName = c.issuedCN
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(int64(len(c.registrations) + 2)),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, c.caCert, csr.PublicKey, c.caKey)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	c.registrations = append(c.registrations, r)
+	return &api.RegisterDevResponse{
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		CaCert:      c.caPEM,
+	}, nil
+}
+
+// initCA creates the CA on the first registration.
+func (c *Center) initCA() error {
+	if c.caCert != nil {
+		return nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "centertest CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+
+	c.caKey, c.caCert = key, cert
+	c.caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return nil
+}
+
+// call applies the latency and returns the error injected into the method.
+func (c *Center) call(ctx context.Context, method string) error {
+	c.mu.Lock()
+	latency := c.latency
+	var err error
+	if errs := c.errs[method]; len(errs) > 0 {
+		err, c.errs[method] = errs[0], errs[1:]
+	}
+	c.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+func (c *Center) save(r *api.SaveDevDataRequest) {
+	c.mu.Lock()
+	c.saved = append(c.saved, r)
+	close(c.savedNotify)
+	c.savedNotify = make(chan struct{})
+	c.mu.Unlock()
+}
+
+// ProtoConfig returns the SetDevInitConfig response with the typed config.
+func ProtoConfig(config *api.FridgeConfig, version uint64) *api.SetDevInitConfigResponse {
+	return &api.SetDevInitConfigResponse{
+		PayloadFormat: api.PayloadFormat_PROTO,
+		TypedConfig:   config,
+		ConfigVersion: version,
+	}
+}
+
+// JSONConfig returns the SetDevInitConfig response with the config encoded
+// the way the centers without typed payloads do it.
+func JSONConfig(config *api.FridgeConfig, version uint64) (*api.SetDevInitConfigResponse, error) {
+	if config == nil {
+		return nil, errors.New("centertest: config is missing")
+	}
+
+	b, err := json.Marshal(struct {
+		TurnedOn    bool
+		CollectFreq int64
+		SendFreq    int64
+	}{config.TurnedOn, config.CollectFreq, config.SendFreq})
+	if err != nil {
+		return nil, err
+	}
+	return &api.SetDevInitConfigResponse{
+		PayloadFormat: api.PayloadFormat_JSON,
+		Config:        b,
+		ConfigVersion: version,
+	}, nil
+}
@@ -0,0 +1,355 @@
+package centertest
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/api/pb"
+	"github.com/kostiamol/fridgems/clock"
+	"github.com/kostiamol/fridgems/entities"
+	"github.com/kostiamol/fridgems/services"
+	"github.com/kostiamol/fridgems/supervisor"
+)
+
+const (
+	// waitTimeout limits the real time the tests wait for the device and the center.
+	waitTimeout = time.Second * 10
+	// stepTimeout is the real time the device is given to save a batch before
+	// its clock is advanced by another step.
+	stepTimeout = time.Millisecond * 20
+	// deviceTimers is the number of the tickers of the running device: the ones
+	// of the data generator, collector and replayer and of the state publisher.
+	deviceTimers = 4
+)
+
+const testMAC = "0A-1B-2C-3D-4E-5F"
+
+var (
+	collectFreq = time.Duration(DefaultConfig.CollectFreq) * time.Millisecond
+	sendFreq    = time.Duration(DefaultConfig.SendFreq) * time.Millisecond
+)
+
+// device is used to run the config and the data services of the fridge
+// the way fridgems does. The services are driven by the fake clock.
+type device struct {
+	config *services.ConfigService
+	data   *services.DataService
+	outbox *services.Outbox
+	sup    *supervisor.Supervisor
+	clock  *clock.Fake
+}
+
+// startDevice starts the device keeping its config cache and outbox in dir,
+// so that a device started again in the same dir replays the outbox.
+// The clock of the device is set to now.
+func startDevice(t *testing.T, center entities.Server, natsURL, dir string, now time.Time) *device {
+	l := logrus.New()
+	l.Out = ioutil.Discard
+
+	meta := &entities.DevMeta{Type: "fridge", Name: "fridge-test", MAC: testMAC}
+	store, err := services.NewConfigStore(filepath.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox, err := services.NewOutbox(filepath.Join(dir, "outbox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	comparts := make([]services.Compartment, 0, 2)
+	for _, spec := range []string{"TopCompart:C:-10:20:random:2,6", "BotCompart:C:-30:10:random:-20,-16"} {
+		c, err := services.ParseCompartment(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		comparts = append(comparts, c)
+	}
+
+	d := &device{
+		outbox: outbox,
+		sup:    supervisor.New(context.Background(), l),
+		clock:  clock.NewFake(now),
+	}
+	d.sup.Clock = d.clock
+	d.config = services.NewConfigService(meta, center, services.NATSConfig{Servers: []string{natsURL}}, store, l,
+		time.Millisecond*100, time.Minute)
+	d.config.Clock, d.config.Breaker.Clock = d.clock, d.clock
+	d.data = services.NewDataService(d.config.Config, meta, center, comparts, outbox,
+		services.NewSendQueue(16, services.SpillToDisk), 2, nil, l, time.Millisecond*100, time.Millisecond*500)
+	d.data.Clock, d.data.Breaker.Clock = d.clock, d.clock
+	d.config.Run(d.sup.Child("config"))
+	d.data.Run(d.sup.Child("data"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	if err := d.clock.WaitForTimers(ctx, deviceTimers); err != nil {
+		t.Fatal("device hasn't started in time")
+	}
+	return d
+}
+
+// stop stops the device advancing its clock by a step at a time,
+// so that the final batches are flushed or their flush times out.
+func (d *device) stop(t *testing.T) {
+	d.sup.Stop()
+	deadline := time.Now().Add(waitTimeout)
+	for !d.sup.WaitTimeout(stepTimeout) {
+		if time.Now().After(deadline) {
+			t.Fatal("device hasn't stopped in time")
+		}
+		d.clock.Advance(collectFreq)
+	}
+}
+
+// advanceUntil advances the clock of the device by a collection period at
+// a time until cond holds for the batches saved by the center. The clock is
+// advanced only once the center hasn't saved anything within stepTimeout.
+func (d *device) advanceUntil(ctx context.Context, t *testing.T, c *Center, what string,
+	cond func(saved []*api.SaveDevDataRequest) bool) {
+	for saved := c.Saved(); !cond(saved); saved = c.Saved() {
+		stepCtx, cancel := context.WithTimeout(ctx, stepTimeout)
+		_, err := c.WaitSaved(stepCtx, len(saved)+1)
+		cancel()
+		if ctx.Err() != nil {
+			t.Fatalf("%s haven't been saved in time", what)
+		}
+		if err != nil {
+			d.clock.Advance(collectFreq)
+		}
+	}
+}
+
+// advanceUntilSaved advances the clock of the device until the center has
+// saved at least n batches.
+func (d *device) advanceUntilSaved(ctx context.Context, t *testing.T, c *Center, what string, n int) {
+	d.advanceUntil(ctx, t, c, what, func(saved []*api.SaveDevDataRequest) bool { return len(saved) >= n })
+}
+
+// patch publishes the config patch until the device acks it, since the
+// device may not have subscribed to the patches yet. Patches of version 0
+// are applied regardless of the current version, so a repeated one is harmless.
+func patch(t *testing.T, n *NATS, config *api.FridgeConfig, mask ...string) *api.ConfigAck {
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+		ack, err := n.PatchConfig(ctx, testMAC, config, mask, 0)
+		cancel()
+		switch {
+		case err == nil:
+			return ack
+		case err != context.DeadlineExceeded:
+			t.Fatalf("patch %v has failed: %s", mask, err)
+		case time.Now().After(deadline):
+			t.Fatalf("patch %v hasn't been acked in time", mask)
+		}
+	}
+}
+
+// firstReading returns the time of the first reading of the first
+// compartment, which identifies the batch, or -1 if the batch has no readings.
+// The batches sent before the payload format has been negotiated are
+// in the legacy JSON format.
+func firstReading(r *api.SaveDevDataRequest) int64 {
+	if d := r.TypedData; d != nil {
+		if len(d.Compartments) > 0 && len(d.Compartments[0].Readings) > 0 {
+			return d.Compartments[0].Readings[0].Time
+		}
+		return -1
+	}
+
+	var legacy map[string]map[int64]float32
+	if err := json.Unmarshal(r.Data, &legacy); err != nil {
+		return -1
+	}
+	first := int64(-1)
+	for t := range legacy["TopCompart"] {
+		if first < 0 || t < first {
+			first = t
+		}
+	}
+	return first
+}
+
+// checkOrder checks that the batches have been saved once each and in the
+// order they have been collected.
+func checkOrder(t *testing.T, saved []*api.SaveDevDataRequest) {
+	last := int64(-1)
+	for i, r := range saved {
+		first := firstReading(r)
+		if first < 0 {
+			continue
+		}
+		if first <= last {
+			t.Errorf("batch %d starting at %d has been saved after the one starting at %d", i, first, last)
+		}
+		last = first
+	}
+}
+
+// readingsBetween returns the times of the readings of the saved batches
+// taken after from and no later than to, in milliseconds.
+func readingsBetween(saved []*api.SaveDevDataRequest, from, to time.Time) []int64 {
+	fromMs, toMs := from.UnixNano()/int64(time.Millisecond), to.UnixNano()/int64(time.Millisecond)
+
+	var times []int64
+	for _, r := range saved {
+		for _, t := range readingTimes(r) {
+			if t > fromMs && t <= toMs {
+				times = append(times, t)
+			}
+		}
+	}
+	return times
+}
+
+// readingTimes returns the times of the readings of all the compartments
+// of the batch in either payload format.
+func readingTimes(r *api.SaveDevDataRequest) []int64 {
+	var times []int64
+	if d := r.TypedData; d != nil {
+		for _, c := range d.Compartments {
+			for _, reading := range c.Readings {
+				times = append(times, reading.Time)
+			}
+		}
+		return times
+	}
+
+	var legacy map[string]map[int64]float32
+	if err := json.Unmarshal(r.Data, &legacy); err != nil {
+		return nil
+	}
+	for _, temps := range legacy {
+		for t := range temps {
+			times = append(times, t)
+		}
+	}
+	return times
+}
+
+func (c *Center) pendingErrs(method string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.errs[method])
+}
+
+func TestDeviceLifecycle(t *testing.T) {
+	tests := []struct {
+		name      string
+		streaming bool
+		// method is the method the device is expected to save the data with.
+		method string
+	}{
+		{name: "streaming", streaming: true, method: MethodStreamDevData},
+		{name: "fallback to SaveDevData", streaming: false, method: MethodSaveDevData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "lifecycle")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			n, err := StartNATS()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer n.Shutdown()
+
+			c := NewCenter()
+			c.DisableStreaming(!tt.streaming)
+			if err := c.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer c.Stop()
+
+			ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+			defer cancel()
+
+			// init config
+			d := startDevice(t, c.Server(), n.URL(), dir, time.Unix(1543846800, 0))
+			d.advanceUntilSaved(ctx, t, c, "data", 2)
+			reqs := c.InitRequests()
+			if len(reqs) == 0 || reqs[0].Meta.Mac != testMAC {
+				t.Fatalf("init config requests = %v, want one of %s", reqs, testMAC)
+			}
+			got := d.config.Config.GetFridgeConfig()
+			if got.TurnedOn != DefaultConfig.TurnedOn || got.CollectFreq != DefaultConfig.CollectFreq ||
+				got.SendFreq != DefaultConfig.SendFreq {
+				t.Errorf("config = %+v, want the init config %+v", got, DefaultConfig)
+			}
+
+			// pause and resume; the generator and the collector are waiting for
+			// the clock, so they stop ticking before it's advanced again
+			pausedAt := d.clock.Now()
+			if ack := patch(t, n, &api.FridgeConfig{TurnedOn: false}, "turned_on"); ack.Config.TurnedOn {
+				t.Errorf("acked config is turned on, want off")
+			}
+			d.clock.Advance(sendFreq * 2)
+			resumedAt := d.clock.Now()
+
+			patch(t, n, &api.FridgeConfig{TurnedOn: true}, "turned_on")
+			if err := d.clock.WaitForTimers(ctx, deviceTimers); err != nil {
+				t.Fatal("device hasn't resumed in time")
+			}
+			d.advanceUntilSaved(ctx, t, c, "resumed data", len(c.Saved())+2)
+			// the readings collected before the pause are handed over, but
+			// nothing is read until the resume
+			if times := readingsBetween(c.Saved(), pausedAt, resumedAt); len(times) != 0 {
+				t.Errorf("readings %v have been taken while the device is off", times)
+			}
+
+			// outage of the center
+			saved := len(c.Saved())
+			c.FailNext(tt.method, ErrUnavailable, ErrUnavailable, ErrUnavailable)
+			d.advanceUntilSaved(ctx, t, c, "data after the outage", saved+2)
+			if n := c.pendingErrs(tt.method); n != 0 {
+				t.Errorf("%d injected error(s) haven't been returned", n)
+			}
+			checkOrder(t, c.Saved())
+
+			// outbox replay after the restart of the device
+			c.Stop()
+			d.clock.Advance(sendFreq * 2)
+			d.stop(t)
+
+			entries, err := d.outbox.Pending(0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pending := make(map[int64]bool, len(entries))
+			for _, e := range entries {
+				if cs := e.Req.Data.Compartments; len(cs) > 0 && len(cs[0].Samples) > 0 {
+					pending[cs[0].Samples[0].Time] = true
+				}
+			}
+			if len(pending) == 0 {
+				t.Fatal("no batch has been left in the outbox")
+			}
+
+			c = NewCenter()
+			c.DisableStreaming(!tt.streaming)
+			if err := c.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer c.Stop()
+
+			d = startDevice(t, c.Server(), n.URL(), dir, d.clock.Now())
+			defer d.stop(t)
+			d.advanceUntil(ctx, t, c, "replayed batches", func(saved []*api.SaveDevDataRequest) bool {
+				for _, r := range saved {
+					delete(pending, firstReading(r))
+				}
+				return len(pending) == 0
+			})
+			checkOrder(t, c.Saved())
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package centertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/kostiamol/fridgems/api/pb"
+	"github.com/nats-io/gnatsd/server"
+	"github.com/nats-io/go-nats"
+)
+
+// natsReadyTimeout is how long StartNATS waits for the server to accept
+// connections.
+const natsReadyTimeout = time.Second * 5
+
+// NATS is an embedded NATS server playing the NATS side of the center:
+// it publishes config patches to the device and acks its alarms.
+type NATS struct {
+	server *server.Server
+	conn   *nats.Conn
+}
+
+// StartNATS starts the embedded NATS server on a random local port.
+// It returns initialized object.
+func StartNATS() (*NATS, error) {
+	s := server.New(&server.Options{
+		Host:   "127.0.0.1",
+		Port:   server.RANDOM_PORT,
+		NoLog:  true,
+		NoSigs: true,
+	})
+	if s == nil {
+		return nil, errors.New("centertest: NATS server can't be created")
+	}
+
+	go s.Start()
+	if !s.ReadyForConnections(natsReadyTimeout) {
+		s.Shutdown()
+		return nil, errors.New("centertest: NATS server isn't ready for connections")
+	}
+
+	n := &NATS{server: s}
+	conn, err := n.Connect()
+	if err != nil {
+		s.Shutdown()
+		return nil, err
+	}
+	n.conn = conn
+	return n, nil
+}
+
+// URL returns the URL of the server to be passed to the services.
+func (n *NATS) URL() string {
+	return "nats://" + n.server.Addr().String()
+}
+
+// Connect returns a new connection to the server.
+func (n *NATS) Connect() (*nats.Conn, error) {
+	return nats.Connect(n.URL())
+}
+
+// Shutdown closes the connection of the center and stops the server.
+func (n *NATS) Shutdown() {
+	n.conn.Close()
+	n.server.Shutdown()
+}
+
+// PatchConfig publishes the config patch to Config.Patch.<MAC> and waits
+// until the device acks it. It returns the ack or an error if the device
+// has rejected the patch or hasn't acked it until ctx is done.
+// An empty mask changes all the config fields.
+func (n *NATS) PatchConfig(ctx context.Context, mac string, config *api.FridgeConfig, mask []string,
+	version uint64) (*api.ConfigAck, error) {
+	eventID := fmt.Sprintf("centertest-%d", time.Now().UnixNano())
+
+	acks := make(chan *nats.Msg, 16)
+	ackSub, err := n.conn.ChanSubscribe("Config.Ack."+mac, acks)
+	if err != nil {
+		return nil, err
+	}
+	defer ackSub.Unsubscribe()
+
+	rejects := make(chan *nats.Msg, 1)
+	reply := nats.NewInbox()
+	rejectSub, err := n.conn.ChanSubscribe(reply, rejects)
+	if err != nil {
+		return nil, err
+	}
+	defer rejectSub.Unsubscribe()
+
+	b, err := proto.Marshal(&api.EventStore{
+		AggregateId: mac,
+		EventId:     eventID,
+		EventType:   "ConfigPatch",
+		Version:     version,
+		ConfigPatch: &api.ConfigPatch{
+			Config:     config,
+			UpdateMask: mask,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := n.conn.PublishRequest("Config.Patch."+mac, reply, b); err != nil {
+		return nil, err
+	}
+
+	for {
+		select {
+		case msg := <-acks:
+			var event api.EventStore
+			if err := proto.Unmarshal(msg.Data, &event); err != nil {
+				return nil, err
+			}
+			if event.ConfigAck == nil || event.ConfigAck.PatchEventId != eventID {
+				continue
+			}
+			if event.ConfigAck.Result == "rejected" {
+				return event.ConfigAck, fmt.Errorf("centertest: patch has been rejected: %s", event.ConfigAck.Reason)
+			}
+			return event.ConfigAck, nil
+		case msg := <-rejects:
+			var reject api.ConfigPatchReject
+			if err := proto.Unmarshal(msg.Data, &reject); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("centertest: patch has been rejected: %s", reject.Reason)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// AckAlarms replies with AlarmAck to the alarm events the device publishes
+// to Alarm.<MAC> and sends the events to the returned channel if there's
+// room in it. Call the returned function to stop acking.
+func (n *NATS) AckAlarms(mac string) (<-chan *api.EventStore, func(), error) {
+	events := make(chan *api.EventStore, 64)
+	sub, err := n.conn.Subscribe("Alarm."+mac, func(msg *nats.Msg) {
+		var event api.EventStore
+		if err := proto.Unmarshal(msg.Data, &event); err != nil {
+			return
+		}
+		b, err := proto.Marshal(&api.AlarmAck{EventId: event.EventId})
+		if err != nil {
+			return
+		}
+		n.conn.Publish(msg.Reply, b)
+
+		select {
+		case events <- &event:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return events, func() { sub.Unsubscribe() }, nil
+}
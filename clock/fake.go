@@ -0,0 +1,217 @@
+package clock
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a clock whose time only moves when it's advanced. The tickers
+// and timers fire in the order of their deadlines while the clock is being
+// advanced past them. Like the real ones, they drop the ticks their
+// readers aren't ready to receive.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	changed chan struct{}
+}
+
+// fakeWaiter is used to store a ticker or a timer of the fake clock.
+type fakeWaiter struct {
+	clock    *Fake
+	deadline time.Time
+	period   time.Duration
+	c        chan time.Time
+	f        func()
+}
+
+// NewFake creates and initializes new Fake object set to now.
+// It returns initialized object.
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		now:     now,
+		changed: make(chan struct{}),
+	}
+}
+
+// Now returns the current time of the clock.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Since returns the time elapsed since t on the clock.
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// NewTicker returns a ticker firing every d of the clock time.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.add(&fakeWaiter{period: d, c: make(chan time.Time, 1)}, d)}
+}
+
+// NewTimer returns a timer firing once d of the clock time has passed.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.add(&fakeWaiter{c: make(chan time.Time, 1)}, d)
+}
+
+// After returns the channel of a new timer.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+// AfterFunc returns a timer calling fn in its own goroutine once d of the
+// clock time has passed.
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	return f.add(&fakeWaiter{f: fn}, d)
+}
+
+// Advance moves the clock forward by d firing the tickers and the timers
+// whose deadlines have been reached.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	target := f.now.Add(d)
+	f.mu.Unlock()
+	f.Set(target)
+}
+
+// Set moves the clock to t firing the tickers and the timers whose deadlines
+// have been reached. The clock never moves backwards.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.waiters) > 0 && !f.waiters[0].deadline.After(t) {
+		w := f.waiters[0]
+		// the deadlines of the timers created with non-positive durations
+		// may be in the past
+		if w.deadline.After(f.now) {
+			f.now = w.deadline
+		}
+		w.fire(f.now)
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+			f.sort()
+		} else {
+			f.remove(w)
+		}
+	}
+	if t.After(f.now) {
+		f.now = t
+	}
+}
+
+// Timers returns the number of the active tickers and timers.
+func (f *Fake) Timers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+// Deadlines returns the times the active tickers and timers fire next
+// in ascending order.
+func (f *Fake) Deadlines() []time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	deadlines := make([]time.Time, 0, len(f.waiters))
+	for _, w := range f.waiters {
+		deadlines = append(deadlines, w.deadline)
+	}
+	return deadlines
+}
+
+// WaitForTimers waits until at least n tickers and timers are active or
+// ctx is done, so that the clock isn't advanced before the code under test
+// has started waiting. It returns ctx.Err() in the latter case.
+func (f *Fake) WaitForTimers(ctx context.Context, n int) error {
+	for {
+		f.mu.Lock()
+		active, changed := len(f.waiters), f.changed
+		f.mu.Unlock()
+
+		if active >= n {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (f *Fake) add(w *fakeWaiter, d time.Duration) *fakeWaiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.clock = f
+	w.deadline = f.now.Add(d)
+	f.waiters = append(f.waiters, w)
+	f.sort()
+	f.notify()
+	return w
+}
+
+// remove deletes w and reports whether it was active.
+func (f *Fake) remove(w *fakeWaiter) bool {
+	for i, v := range f.waiters {
+		if v == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			f.notify()
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fake) sort() {
+	sort.SliceStable(f.waiters, func(i, j int) bool {
+		return f.waiters[i].deadline.Before(f.waiters[j].deadline)
+	})
+}
+
+func (f *Fake) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (w *fakeWaiter) fire(now time.Time) {
+	if w.f != nil {
+		go w.f()
+		return
+	}
+	select {
+	case w.c <- now:
+	default:
+	}
+}
+
+func (w *fakeWaiter) C() <-chan time.Time {
+	return w.c
+}
+
+func (w *fakeWaiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.clock.remove(w)
+}
+
+// fakeTicker is used to wrap the waiter of a ticker, whose Stop reports nothing.
+type fakeTicker struct {
+	w *fakeWaiter
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t fakeTicker) Stop() {
+	t.w.Stop()
+}
@@ -0,0 +1,155 @@
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var start = time.Unix(1543846800, 0)
+
+// received returns the time sent to c or the zero time if nothing has been sent.
+func received(c <-chan time.Time) time.Time {
+	select {
+	case t := <-c:
+		return t
+	default:
+		return time.Time{}
+	}
+}
+
+func TestFakeFiresInDeadlineOrder(t *testing.T) {
+	f := NewFake(start)
+	fast := f.NewTicker(time.Second * 2)
+	slow := f.NewTicker(time.Second * 3)
+	timer := f.NewTimer(time.Second * 5)
+
+	// the ticks nobody has been ready to receive are dropped, only the first
+	// one of each ticker is buffered
+	f.Advance(time.Second * 7)
+
+	tests := []struct {
+		name string
+		c    <-chan time.Time
+		want time.Time
+	}{
+		{name: "2s ticker", c: fast.C(), want: start.Add(time.Second * 2)},
+		{name: "3s ticker", c: slow.C(), want: start.Add(time.Second * 3)},
+		{name: "5s timer", c: timer.C(), want: start.Add(time.Second * 5)},
+	}
+	for _, tt := range tests {
+		if got := received(tt.c); !got.Equal(tt.want) {
+			t.Errorf("%s has fired at %s, want %s", tt.name, got, tt.want)
+		}
+		if got := received(tt.c); !got.IsZero() {
+			t.Errorf("%s has fired again at %s, want the tick dropped", tt.name, got)
+		}
+	}
+
+	want := []time.Time{start.Add(time.Second * 8), start.Add(time.Second * 9)}
+	if got := f.Deadlines(); len(got) != len(want) || !got[0].Equal(want[0]) || !got[1].Equal(want[1]) {
+		t.Errorf("Deadlines() = %v, want %v", got, want)
+	}
+	if got, want := f.Now(), start.Add(time.Second*7); !got.Equal(want) {
+		t.Errorf("Now() = %s, want %s", got, want)
+	}
+}
+
+func TestFakeTickerAfterReceive(t *testing.T) {
+	f := NewFake(start)
+	ticker := f.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for i := 1; i <= 3; i++ {
+		f.Advance(time.Second)
+		if got, want := received(ticker.C()), start.Add(time.Second*time.Duration(i)); !got.Equal(want) {
+			t.Errorf("tick %d = %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestFakeStop(t *testing.T) {
+	f := NewFake(start)
+
+	timer := f.NewTimer(time.Second)
+	if !timer.Stop() {
+		t.Error("Stop() of an active timer = false, want true")
+	}
+	if timer.Stop() {
+		t.Error("second Stop() = true, want false")
+	}
+
+	fired := f.NewTimer(time.Second)
+	f.Advance(time.Second)
+	if fired.Stop() {
+		t.Error("Stop() of a fired timer = true, want false")
+	}
+
+	called := make(chan struct{}, 1)
+	fn := f.AfterFunc(time.Second, func() { called <- struct{}{} })
+	if !fn.Stop() {
+		t.Error("Stop() of an active AfterFunc timer = false, want true")
+	}
+	f.Advance(time.Second)
+	select {
+	case <-called:
+		t.Error("function of a stopped AfterFunc timer has been called")
+	case <-time.After(time.Millisecond * 20):
+	}
+
+	ticker := f.NewTicker(time.Second)
+	ticker.Stop()
+	f.Advance(time.Second)
+	if got := received(ticker.C()); !got.IsZero() {
+		t.Errorf("stopped ticker has fired at %s", got)
+	}
+	if n := f.Timers(); n != 0 {
+		t.Errorf("Timers() = %d after all the timers have been stopped", n)
+	}
+}
+
+func TestFakeNeverMovesBackwards(t *testing.T) {
+	f := NewFake(start)
+	f.Advance(time.Second)
+
+	f.Set(start)
+	if got, want := f.Now(), start.Add(time.Second); !got.Equal(want) {
+		t.Errorf("Now() after Set() to the past = %s, want %s", got, want)
+	}
+
+	// a timer with a negative duration fires on the next move at the current time
+	timer := f.NewTimer(-time.Minute)
+	f.Advance(0)
+	if got, want := received(timer.C()), start.Add(time.Second); !got.Equal(want) {
+		t.Errorf("timer with a negative duration has fired at %s, want %s", got, want)
+	}
+	if got, want := f.Now(), start.Add(time.Second); !got.Equal(want) {
+		t.Errorf("Now() after the timer with a negative duration = %s, want %s", got, want)
+	}
+}
+
+func TestFakeWaitForTimers(t *testing.T) {
+	f := NewFake(start)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := f.WaitForTimers(ctx, 1); err != context.Canceled {
+		t.Errorf("WaitForTimers() without timers = %v, want %v", err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- f.WaitForTimers(ctx, 2) }()
+
+	f.NewTimer(time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("WaitForTimers() = %v with 1 of 2 timers", err)
+	case <-time.After(time.Millisecond * 20):
+	}
+	f.NewTicker(time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("WaitForTimers() = %v, want nil", err)
+	}
+}
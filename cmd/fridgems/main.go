@@ -1,57 +1,266 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/alarm"
+	"github.com/kostiamol/fridgems/api/rest"
 	"github.com/kostiamol/fridgems/entities"
 	"github.com/kostiamol/fridgems/services"
+	"github.com/kostiamol/fridgems/supervisor"
 )
 
 func main() {
-	ctrl := &entities.ServiceController{
-		StopChan: make(chan struct{}),
-	}
+	logs := &loggers{}
+	sup := supervisor.New(context.Background(), logs.New())
 
 	defer func() {
 		if r := recover(); r != nil {
 			logrus.Errorf("main(): panic(): %s", r)
-			ctrl.Terminate()
+			sup.Stop()
+			os.Exit(exitFailure)
 		}
 	}()
 
-	flag.StringVar(&devMeta.Name, "name", "", "device name")
-	flag.StringVar(&devMeta.MAC, "mac", "", "device MAC")
-	flag.Parse()
-	checkCLIArgs()
+	args := os.Args[1:]
+	settings, err := loadSettings(args, flag.ExitOnError)
+	if err != nil {
+		logrus.Errorf("main(): loadSettings() has failed: %s", err)
+		panic("settings are invalid")
+	}
+	logs.Apply(settings.Log)
+
+	mac := settings.Device.MAC
+	if mac == "" {
+		if mac, err = entities.InterfaceMAC(settings.Device.Interface); err != nil {
+			logrus.Errorf("main(): InterfaceMAC() has failed: %s", err)
+			panic("device MAC can't be detected")
+		}
+		logrus.Infof("device MAC %s has been taken from interface %s", mac, settings.Device.Interface)
+	}
+
+	identityStore, err := services.NewIdentityStore(filepath.Join(settings.DataDir, "identity"))
+	if err != nil {
+		logrus.Errorf("main(): NewIdentityStore() has failed: %s", err)
+		panic("identity store can't be opened")
+	}
+	identity, err := identityStore.LoadOrCreate(mac)
+	if err != nil {
+		logrus.Errorf("main(): LoadOrCreate() has failed: %s", err)
+		panic("device identity can't be loaded")
+	}
+
+	devMeta := entities.DevMeta{
+		Type: settings.Device.Type,
+		Name: settings.Device.Name,
+		MAC:  mac,
+		ID:   identity.ID,
+	}
+	if devMeta.Name == "" {
+		devMeta.Name = devMeta.Type + "-" + identity.Serial
+	}
+	logrus.Infof("device type: [%s] name:[%s] MAC:[%s] ID:[%s] serial:[%s]",
+		devMeta.Type, devMeta.Name, devMeta.MAC, devMeta.ID, identity.Serial)
+
+	policy, err := services.ParseOverflowPolicy(settings.Pipeline.QueuePolicy)
+	if err != nil {
+		logrus.Errorf("main(): ParseOverflowPolicy() has failed: %s", err)
+		panic("send queue can't be initialized")
+	}
+	queue := services.NewSendQueue(settings.Pipeline.QueueCapacity, policy)
+
+	current := settings
+	go handleSignals(sup, func() {
+		current = reloadSettings(current, args, logs, queue)
+	})
+
+	if settings.Provisioning.Token != "" && identity.Credentials == nil {
+		if identity, err = provision(sup, settings, &devMeta, identityStore, identity, logs); err != nil {
+			if sup.Context().Err() != nil {
+				logrus.Info("fridge is down: provisioning has been interrupted")
+				os.Exit(exitOK)
+			}
+			logrus.Errorf("main(): provision() has failed: %s", err)
+			panic("device can't be provisioned")
+		}
+	}
+	settings.applyCredentials(identity.Credentials)
 
-	logrus.Infof("device type: [%s] name:[%s] MAC:[%s]", devMeta.Type, devMeta.Name, devMeta.MAC)
+	centerTLSConfig, err := services.NewCenterTLSConfig(settings.Center.TLS, devMeta.MAC)
+	if err != nil {
+		logrus.Errorf("main(): NewCenterTLSConfig() has failed: %s", err)
+		panic("center TLS can't be configured")
+	}
+
+	configStore, err := services.NewConfigStore(filepath.Join(settings.DataDir, "config.json"))
+	if err != nil {
+		logrus.Errorf("main(): NewConfigStore() has failed: %s", err)
+		panic("config store can't be opened")
+	}
 
 	cs := services.NewConfigService(
 		&devMeta,
 		entities.Server{
-			Host: centerHost,
-			Port: centerConfigPort,
+			Host: settings.Center.Host,
+			Port: settings.Center.ConfigPort,
+			TLS:  centerTLSConfig,
 		},
-		ctrl,
-		logrus.New(),
-		retryInterval,
+		settings.NATS,
+		configStore,
+		logs.New(),
+		settings.Retry.Interval,
+		settings.Retry.StateInterval,
 	)
-	cs.Run()
+	cs.Run(sup.Child("config"))
+
+	outbox, err := services.NewOutbox(filepath.Join(settings.DataDir, "outbox"))
+	if err != nil {
+		logrus.Errorf("main(): NewOutbox() has failed: %s", err)
+		panic("outbox can't be opened")
+	}
+
+	comparts := make([]services.Compartment, 0, len(settings.Compartments))
+	names := make(map[string]bool, len(settings.Compartments))
+	for _, spec := range settings.Compartments {
+		c, err := services.ParseCompartment(spec)
+		if err != nil {
+			logrus.Errorf("main(): ParseCompartment() has failed: %s", err)
+			panic("compartment can't be initialized")
+		}
+		if names[c.Name] {
+			panic("compartment " + c.Name + " is specified twice")
+		}
+		names[c.Name] = true
+		comparts = append(comparts, c)
+	}
+
+	rules := make([]alarm.Rule, 0, len(settings.Alarms))
+	for _, spec := range settings.Alarms {
+		r, err := alarm.ParseRule(spec)
+		if err != nil {
+			logrus.Errorf("main(): ParseRule() has failed: %s", err)
+			panic("alarm rule can't be initialized")
+		}
+		if !names[r.Compart] {
+			panic("alarm rule " + r.Name + " refers to unknown compartment " + r.Compart)
+		}
+		rules = append(rules, r)
+	}
+
+	as, err := services.NewAlarmService(rules, &devMeta, filepath.Join(settings.DataDir, "alarms.json"), cs.NATSConn,
+		logs.New(), settings.Retry.Interval)
+	if err != nil {
+		logrus.Errorf("main(): NewAlarmService() has failed: %s", err)
+		panic("alarms can't be loaded")
+	}
+	as.Run(sup.Child("alarm"))
 
 	ds := services.NewDataService(
 		cs.Config,
 		&devMeta,
 		entities.Server{
-			Host: centerHost,
-			Port: centerDataPort,
+			Host: settings.Center.Host,
+			Port: settings.Center.DataPort,
+			TLS:  centerTLSConfig,
 		},
-		ctrl,
-		logrus.New(),
-		retryInterval,
+		comparts,
+		outbox,
+		queue,
+		settings.Pipeline.SendWorkers,
+		as,
+		logs.New(),
+		settings.Retry.Interval,
+		settings.Retry.FlushTimeout,
 	)
-	ds.Run()
+	ds.Readings = make(chan services.FridgeDatum, settings.Pipeline.Readings*len(comparts))
+	ds.Run(sup.Child("data"))
+	services.RegisterStateMetrics(cs, ds, as)
 
-	ctrl.Wait()
-	logrus.Info("fridge is down")
+	if settings.HTTPAddr != "" {
+		srv := rest.NewServer(settings.HTTPAddr, &devMeta, cs, ds, as, sup, logs.New())
+		sup.Go("httpAPI", srv.Run)
+	}
+
+	os.Exit(waitForShutdown(sup, outbox, settings.Retry.FlushTimeout+settings.Retry.ShutdownGrace))
+}
+
+// provision registers the device with the center at the config endpoint
+// without a client certificate, the device has none yet.
+func provision(sup *supervisor.Supervisor, s Settings, m *entities.DevMeta, st *services.IdentityStore,
+	id services.Identity, logs *loggers) (services.Identity, error) {
+	tlsSettings := s.Center.TLS
+	tlsSettings.ClientCert, tlsSettings.ClientKey = "", ""
+	tlsConfig, err := services.NewCenterTLSConfig(tlsSettings, m.MAC)
+	if err != nil {
+		return id, err
+	}
+
+	p := services.NewProvisioner(
+		entities.Server{
+			Host: s.Center.Host,
+			Port: s.Center.ConfigPort,
+			TLS:  tlsConfig,
+		},
+		m,
+		st,
+		s.Provisioning.Token,
+		logs.New(),
+		s.Retry.Interval,
+	)
+	return p.Provision(sup.Context(), id)
+}
+
+// handleSignals stops the services gracefully on SIGINT or SIGTERM and
+// calls reload on SIGHUP.
+func handleSignals(sup *supervisor.Supervisor, reload func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				logrus.Info("signal SIGHUP has been received, settings are reloading")
+				reload()
+				continue
+			}
+			logrus.Infof("signal %s has been received, fridge is shutting down", sig)
+			sup.Stop()
+			return
+		case <-sup.Done():
+			return
+		}
+	}
+}
+
+// waitForShutdown waits for the services to finish no longer than timeout
+// and returns the exit status.
+func waitForShutdown(sup *supervisor.Supervisor, outbox *services.Outbox, timeout time.Duration) int {
+	<-sup.Done()
+	finished := sup.WaitTimeout(timeout)
+
+	switch {
+	case !finished:
+		for _, h := range sup.Health() {
+			if h.State != supervisor.StateStopped && h.State != supervisor.StateFinished {
+				logrus.Errorf("worker %s hasn't stopped: %s", h.Name, h.State)
+			}
+		}
+		logrus.Error("fridge is down: services haven't finished in time")
+		return exitTimeout
+	case outbox.Len() > 0:
+		logrus.Warnf("fridge is down: %d batch(es) are left in the outbox", outbox.Len())
+		return exitPendingData
+	default:
+		logrus.Info("fridge is down")
+		return exitOK
+	}
 }
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/entities"
+	"github.com/kostiamol/fridgems/services"
+	"github.com/nats-io/go-nats"
+	"gopkg.in/yaml.v2"
+)
+
+// Settings is used to store the settings of the fridge. They are taken from,
+// in the order of increasing precedence, the defaults, the settings file,
+// the environment variables and the command line flags. The logging and
+// the send queue limits can be reloaded at runtime with SIGHUP.
+type Settings struct {
+	Device       DeviceSettings       `yaml:"device"`
+	Center       CenterSettings       `yaml:"center"`
+	NATS         services.NATSConfig  `yaml:"nats"`
+	DataDir      string               `yaml:"data_dir"`
+	HTTPAddr     string               `yaml:"http_addr"`
+	Compartments []string             `yaml:"compartments"`
+	Alarms       []string             `yaml:"alarms"`
+	Pipeline     PipelineSettings     `yaml:"pipeline"`
+	Retry        RetrySettings        `yaml:"retry"`
+	Log          LogSettings          `yaml:"log"`
+	Provisioning ProvisioningSettings `yaml:"provisioning"`
+}
+
+// DeviceSettings is used to store the identity of the device. If MAC isn't
+// set, it's taken from Interface, "auto" picks the first suitable one.
+// Name defaults to the type and the serial of the device.
+type DeviceSettings struct {
+	Type      string `yaml:"type"`
+	Name      string `yaml:"name"`
+	MAC       string `yaml:"mac"`
+	Interface string `yaml:"interface"`
+}
+
+// CenterSettings is used to store the endpoints of the center.
+type CenterSettings struct {
+	Host       string             `yaml:"host"`
+	ConfigPort string             `yaml:"config_port"`
+	DataPort   string             `yaml:"data_port"`
+	TLS        services.CenterTLS `yaml:"tls"`
+}
+
+// PipelineSettings is used to store the sizes of the data pipeline buffers.
+// Readings is the capacity of the readings channel per compartment.
+type PipelineSettings struct {
+	Readings      int    `yaml:"readings"`
+	SendWorkers   int    `yaml:"send_workers"`
+	QueueCapacity int    `yaml:"queue_capacity"`
+	QueuePolicy   string `yaml:"queue_policy"`
+}
+
+// RetrySettings is used to store the retry policy and the timeouts.
+type RetrySettings struct {
+	Interval      time.Duration `yaml:"interval"`
+	StateInterval time.Duration `yaml:"state_interval"`
+	FlushTimeout  time.Duration `yaml:"flush_timeout"`
+	ShutdownGrace time.Duration `yaml:"shutdown_grace"`
+}
+
+// ProvisioningSettings is used to store the token the device registers with
+// at the center on its first boot. The device isn't provisioned if it's empty.
+type ProvisioningSettings struct {
+	Token string `yaml:"token"`
+}
+
+// LogSettings is used to store the level and the format (text or json) of the logs.
+type LogSettings struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+func defaultSettings() Settings {
+	return Settings{
+		Device: DeviceSettings{
+			Type: defaultDevType,
+		},
+		Center: CenterSettings{
+			Host:       localhost,
+			ConfigPort: defaultCenterConfigPort,
+			DataPort:   defaultCenterDataPort,
+		},
+		NATS: services.NATSConfig{
+			Servers:       []string{nats.DefaultURL},
+			ReconnectWait: nats.DefaultReconnectWait,
+		},
+		DataDir:      defaultDataDir,
+		HTTPAddr:     defaultHTTPAddr,
+		Compartments: splitList(defaultCompartments, ";"),
+		Pipeline: PipelineSettings{
+			Readings:      defaultReadingsBuffer,
+			SendWorkers:   defaultSendWorkers,
+			QueueCapacity: defaultQueueCapacity,
+			QueuePolicy:   defaultQueuePolicy,
+		},
+		Retry: RetrySettings{
+			Interval:      defaultRetryInterval,
+			StateInterval: defaultStateInterval,
+			FlushTimeout:  defaultFlushTimeout,
+			ShutdownGrace: defaultShutdownGrace,
+		},
+		Log: LogSettings{
+			Level:  defaultLogLevel,
+			Format: defaultLogFormat,
+		},
+	}
+}
+
+// loadSettings builds the settings from the defaults, the settings file
+// given by -config or FRIDGE_CONFIG, the environment and the flags in args,
+// and validates them.
+func loadSettings(args []string, h flag.ErrorHandling) (Settings, error) {
+	s := defaultSettings()
+
+	if path := settingsPath(args); path != "" {
+		if err := s.loadFile(path); err != nil {
+			return s, err
+		}
+	}
+	if err := s.loadEnv(); err != nil {
+		return s, err
+	}
+	if err := s.flagSet(h).Parse(args); err != nil {
+		return s, err
+	}
+	if s.Device.MAC != "" {
+		mac, err := entities.NormalizeMAC(s.Device.MAC)
+		if err != nil {
+			return s, err
+		}
+		s.Device.MAC = mac
+	}
+	return s, s.validate()
+}
+
+// settingsPath returns the value of the -config flag in args or
+// FRIDGE_CONFIG if the flag isn't passed.
+func settingsPath(args []string) string {
+	path := os.Getenv(envSettingsFile)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		switch name := strings.TrimLeft(arg, "-"); {
+		case name == "config" && i+1 < len(args):
+			path = args[i+1]
+			i++
+		case strings.HasPrefix(name, "config="):
+			path = strings.TrimPrefix(name, "config=")
+		}
+	}
+	return path
+}
+
+// loadFile overrides the settings with the ones present in the YAML file.
+// Unknown keys are rejected to catch typos.
+func (s *Settings) loadFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(b, s); err != nil {
+		return fmt.Errorf("settings file %s is invalid: %s", path, err)
+	}
+	return nil
+}
+
+func (s *Settings) loadEnv() error {
+	var e envLoader
+	e.str("FRIDGE_TYPE", &s.Device.Type)
+	e.str("FRIDGE_NAME", &s.Device.Name)
+	e.str("FRIDGE_MAC", &s.Device.MAC)
+	e.str("FRIDGE_IFACE", &s.Device.Interface)
+	e.str("CENTER_TCP_ADDR", &s.Center.Host)
+	e.str("CENTER_CONFIG_TCP_PORT", &s.Center.ConfigPort)
+	e.str("CENTER_DATA_TCP_PORT", &s.Center.DataPort)
+	e.boolean("CENTER_TLS", &s.Center.TLS.Enabled)
+	e.str("CENTER_CA_CERT", &s.Center.TLS.CACert)
+	e.str("CENTER_CLIENT_CERT", &s.Center.TLS.ClientCert)
+	e.str("CENTER_CLIENT_KEY", &s.Center.TLS.ClientKey)
+	e.str("CENTER_SERVER_NAME", &s.Center.TLS.ServerName)
+	e.str("CENTER_PIN_SHA256", &s.Center.TLS.PinnedSHA256)
+	e.list("NATS_URL", ",", &s.NATS.Servers)
+	e.str("NATS_USER", &s.NATS.User)
+	e.str("NATS_PASSWORD", &s.NATS.Password)
+	e.str("NATS_TOKEN", &s.NATS.Token)
+	e.str("NATS_NKEY_SEED", &s.NATS.NKeySeedFile)
+	e.str("NATS_CREDS", &s.NATS.CredsFile)
+	e.str("NATS_CA_CERT", &s.NATS.CACert)
+	e.str("NATS_CLIENT_CERT", &s.NATS.ClientCert)
+	e.str("NATS_CLIENT_KEY", &s.NATS.ClientKey)
+	e.duration("NATS_RECONNECT_WAIT", &s.NATS.ReconnectWait)
+	e.str("FRIDGE_DATA_DIR", &s.DataDir)
+	e.str("FRIDGE_HTTP_ADDR", &s.HTTPAddr)
+	e.list("FRIDGE_COMPARTMENTS", ";", &s.Compartments)
+	e.list("FRIDGE_ALARMS", ";", &s.Alarms)
+	e.integer("FRIDGE_READINGS_BUFFER", &s.Pipeline.Readings)
+	e.integer("FRIDGE_SEND_WORKERS", &s.Pipeline.SendWorkers)
+	e.integer("FRIDGE_QUEUE_CAPACITY", &s.Pipeline.QueueCapacity)
+	e.str("FRIDGE_QUEUE_POLICY", &s.Pipeline.QueuePolicy)
+	e.duration("FRIDGE_RETRY_INTERVAL", &s.Retry.Interval)
+	e.duration("FRIDGE_STATE_INTERVAL", &s.Retry.StateInterval)
+	e.duration("FRIDGE_FLUSH_TIMEOUT", &s.Retry.FlushTimeout)
+	e.duration("FRIDGE_SHUTDOWN_GRACE", &s.Retry.ShutdownGrace)
+	e.str("FRIDGE_LOG_LEVEL", &s.Log.Level)
+	e.str("FRIDGE_LOG_FORMAT", &s.Log.Format)
+	e.str("FRIDGE_PROVISION_TOKEN", &s.Provisioning.Token)
+	return e.err
+}
+
+// flagSet returns the flags overriding the settings, their defaults are
+// the settings taken from the file and the environment.
+func (s *Settings) flagSet(h flag.ErrorHandling) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], h)
+	fs.String("config", os.Getenv(envSettingsFile), "YAML settings file, overridden by the environment and the flags")
+	fs.StringVar(&s.Device.Type, "type", s.Device.Type, "device type")
+	fs.StringVar(&s.Device.Name, "name", s.Device.Name, "device name, the type and the serial by default")
+	fs.StringVar(&s.Device.MAC, "mac", s.Device.MAC, "device MAC, e.g. 0A-1B-2C-3D-4E-5F")
+	fs.StringVar(&s.Device.Interface, "iface", s.Device.Interface,
+		"network interface to take the MAC from if -mac isn't set, auto for the first suitable one")
+	fs.StringVar(&s.DataDir, "data", s.DataDir, "directory for the outbox of unsent data, the cached config and undelivered alarms")
+	fs.StringVar(&s.HTTPAddr, "http", s.HTTPAddr, "address of the local HTTP API, empty to disable it")
+	fs.StringVar(&s.Center.Host, "center-host", s.Center.Host, "host of the center")
+	fs.StringVar(&s.Center.ConfigPort, "center-config-port", s.Center.ConfigPort, "config port of the center")
+	fs.StringVar(&s.Center.DataPort, "center-data-port", s.Center.DataPort, "data port of the center")
+	fs.BoolVar(&s.Center.TLS.Enabled, "center-tls", s.Center.TLS.Enabled, "use TLS for the connection to the center")
+	fs.StringVar(&s.Center.TLS.CACert, "center-ca", s.Center.TLS.CACert, "CA certificates file to verify the center")
+	fs.StringVar(&s.Center.TLS.ClientCert, "center-cert", s.Center.TLS.ClientCert,
+		"device certificate file for mutual TLS, its common name must be the device MAC")
+	fs.StringVar(&s.Center.TLS.ClientKey, "center-key", s.Center.TLS.ClientKey, "device key file for mutual TLS")
+	fs.StringVar(&s.Center.TLS.ServerName, "center-server-name", s.Center.TLS.ServerName,
+		"name to verify the center certificate against")
+	fs.StringVar(&s.Center.TLS.PinnedSHA256, "center-pin", s.Center.TLS.PinnedSHA256,
+		"SHA-256 fingerprint of the center certificate to pin")
+	fs.Var(commaList{&s.NATS.Servers}, "nats", "comma-separated list of NATS server URLs")
+	fs.StringVar(&s.NATS.User, "nats-user", s.NATS.User, "NATS user")
+	fs.StringVar(&s.NATS.Password, "nats-password", s.NATS.Password, "NATS password")
+	fs.StringVar(&s.NATS.Token, "nats-token", s.NATS.Token, "NATS authentication token")
+	fs.StringVar(&s.NATS.NKeySeedFile, "nats-nkey", s.NATS.NKeySeedFile, "NATS NKey seed file")
+	fs.StringVar(&s.NATS.CredsFile, "nats-creds", s.NATS.CredsFile, "NATS user credentials file")
+	fs.StringVar(&s.NATS.CACert, "nats-ca", s.NATS.CACert, "CA certificates file to verify NATS servers")
+	fs.StringVar(&s.NATS.ClientCert, "nats-cert", s.NATS.ClientCert, "client certificate file for NATS")
+	fs.StringVar(&s.NATS.ClientKey, "nats-key", s.NATS.ClientKey, "client key file for NATS")
+	fs.DurationVar(&s.NATS.ReconnectWait, "nats-reconnect-wait", s.NATS.ReconnectWait, "pause between reconnections to NATS")
+	fs.Var(&specList{specs: &s.Compartments}, "compart", "compartment as name:unit:min:max:sensor, where sensor is "+
+		"random[:min,max], hwmon:<path>, w1:<path>, csv:<path>[,column] or sim[:key=value,...]; may be repeated")
+	fs.Var(&specList{specs: &s.Alarms}, "alarm", "alarm rule as name:compartment:above|below|rate:threshold[:duration[:hysteresis]], "+
+		"e.g. TopWarm:TopCompart:above:8:10m:0.5; may be repeated")
+	fs.IntVar(&s.Pipeline.Readings, "readings-buffer", s.Pipeline.Readings, "number of readings buffered per compartment")
+	fs.IntVar(&s.Pipeline.SendWorkers, "send-workers", s.Pipeline.SendWorkers, "number of workers sending data to the center")
+	fs.IntVar(&s.Pipeline.QueueCapacity, "queue-capacity", s.Pipeline.QueueCapacity, "number of data batches queued or being sent")
+	fs.StringVar(&s.Pipeline.QueuePolicy, "queue-policy", s.Pipeline.QueuePolicy,
+		"what to do with a batch when the send queue is full: spill, drop-oldest or drop-newest")
+	fs.DurationVar(&s.Retry.Interval, "retry-interval", s.Retry.Interval, "max interval between retries of the center calls")
+	fs.DurationVar(&s.Retry.StateInterval, "state-interval", s.Retry.StateInterval, "interval of publishing the reported state")
+	fs.DurationVar(&s.Retry.FlushTimeout, "flush-timeout", s.Retry.FlushTimeout, "time to deliver the pending data on shutdown")
+	fs.DurationVar(&s.Retry.ShutdownGrace, "shutdown-grace", s.Retry.ShutdownGrace, "extra time for the workers to stop on shutdown")
+	fs.StringVar(&s.Log.Level, "log-level", s.Log.Level, "log level: debug, info, warning or error")
+	fs.StringVar(&s.Log.Format, "log-format", s.Log.Format, "log format: text or json")
+	fs.StringVar(&s.Provisioning.Token, "provision-token", s.Provisioning.Token,
+		"token to register the device with at the center on the first boot")
+	return fs
+}
+
+// validate checks whether vital settings are present and valid.
+func (s *Settings) validate() error {
+	switch {
+	case len(s.Device.Type) == 0:
+		return errors.New("device type is missing")
+	case len(s.Device.MAC) == 0 && len(s.Device.Interface) == 0:
+		return errors.New("device MAC is missing, set it or the interface to take it from")
+	case len(s.Center.Host) == 0 || len(s.Center.ConfigPort) == 0 || len(s.Center.DataPort) == 0:
+		return errors.New("center endpoints are missing")
+	case len(s.NATS.Servers) == 0:
+		return errors.New("NATS servers are missing")
+	case len(s.Compartments) == 0:
+		return errors.New("device compartments are missing")
+	case s.Pipeline.Readings < 1:
+		return errors.New("readings buffer must be positive")
+	case s.Pipeline.SendWorkers < 1:
+		return errors.New("number of sender workers must be positive")
+	case s.Pipeline.QueueCapacity < 1:
+		return errors.New("send queue capacity must be positive")
+	case s.Retry.Interval <= 0 || s.Retry.StateInterval <= 0 || s.Retry.FlushTimeout <= 0 || s.Retry.ShutdownGrace < 0:
+		return errors.New("retry intervals and timeouts must be positive")
+	}
+
+	if _, err := services.ParseOverflowPolicy(s.Pipeline.QueuePolicy); err != nil {
+		return err
+	}
+	if _, err := logrus.ParseLevel(s.Log.Level); err != nil {
+		return err
+	}
+	if s.Log.Format != "text" && s.Log.Format != "json" {
+		return fmt.Errorf("unknown log format: %q", s.Log.Format)
+	}
+	return nil
+}
+
+// applyCredentials makes the connections to the center and NATS use the
+// credentials issued to the device unless others are set. The certificate
+// is used only if TLS is enabled, the NATS credentials only if no other NATS
+// authentication is configured.
+func (s *Settings) applyCredentials(c *services.Credentials) {
+	if c == nil {
+		return
+	}
+	if s.Center.TLS.Enabled && s.Center.TLS.ClientCert == "" && s.Center.TLS.ClientKey == "" {
+		s.Center.TLS.ClientCert, s.Center.TLS.ClientKey = c.Cert, c.Key
+		if s.Center.TLS.CACert == "" {
+			s.Center.TLS.CACert = c.CACert
+		}
+	}
+	if !s.NATS.HasAuth() {
+		s.NATS.CredsFile = c.NATSCreds
+	}
+}
+
+// reloadable returns the settings with the parts that can be changed at
+// runtime taken from next.
+func (s Settings) reloadable(next Settings) Settings {
+	s.Log = next.Log
+	s.Pipeline.QueueCapacity = next.Pipeline.QueueCapacity
+	s.Pipeline.QueuePolicy = next.Pipeline.QueuePolicy
+	return s
+}
+
+// loggers is used to keep the loggers of the services, so that the log
+// settings can be changed at runtime.
+type loggers struct {
+	mu       sync.Mutex
+	settings LogSettings
+	all      []*logrus.Logger
+}
+
+// New returns a new logger with the current settings.
+func (l *loggers) New() *logrus.Logger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	log := logrus.New()
+	applyLogSettings(log, l.settings)
+	l.all = append(l.all, log)
+	return log
+}
+
+// Apply changes the settings of all the loggers and the standard one.
+// The settings must have been validated.
+func (l *loggers) Apply(s LogSettings) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.settings = s
+	applyLogSettings(logrus.StandardLogger(), s)
+	for _, log := range l.all {
+		applyLogSettings(log, s)
+	}
+}
+
+func applyLogSettings(log *logrus.Logger, s LogSettings) {
+	if level, err := logrus.ParseLevel(s.Level); err == nil {
+		log.SetLevel(level)
+	}
+	if s.Format == "json" {
+		log.Formatter = &logrus.JSONFormatter{}
+	} else {
+		log.Formatter = &logrus.TextFormatter{}
+	}
+}
+
+// reloadSettings loads the settings again with the same args and applies
+// the parts that can be changed at runtime. The invalid settings are ignored.
+func reloadSettings(current Settings, args []string, logs *loggers, q *services.SendQueue) Settings {
+	next, err := loadSettings(args, flag.ContinueOnError)
+	if err != nil {
+		logrus.Errorf("reloadSettings(): loadSettings() has failed, settings are kept: %s", err)
+		return current
+	}
+
+	if !reflect.DeepEqual(current.reloadable(next), next) {
+		logrus.Warn("only the logging and the send queue limits can be changed at runtime, " +
+			"restart the fridge to apply the other settings")
+	}
+
+	logs.Apply(next.Log)
+	policy, _ := services.ParseOverflowPolicy(next.Pipeline.QueuePolicy)
+	q.SetLimits(next.Pipeline.QueueCapacity, policy)
+	logrus.Infof("settings have been reloaded: log level %s, send queue capacity %d, overflow policy %s",
+		next.Log.Level, next.Pipeline.QueueCapacity, policy)
+	return current.reloadable(next)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kostiamol/fridgems/services"
+)
+
+func TestExampleSettingsAreDefaults(t *testing.T) {
+	var s Settings
+	if err := s.loadFile("fridgems.example.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Alarms) == 0 {
+		s.Alarms = nil
+	}
+
+	if want := defaultSettings(); !reflect.DeepEqual(s, want) {
+		t.Errorf("example settings = %+v, want the defaults %+v", s, want)
+	}
+}
+
+func TestEnvBoolean(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    bool
+		wantErr bool
+	}{
+		{val: "true", want: true},
+		{val: "1", want: true},
+		{val: "TRUE", want: true},
+		{val: "false", want: false},
+		{val: "0", want: false},
+		{val: "yes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.val, func(t *testing.T) {
+			t.Setenv("CENTER_TLS", tt.val)
+
+			s := defaultSettings()
+			s.Center.TLS.Enabled = !tt.want
+			err := s.loadEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("CENTER_TLS=%s has been accepted", tt.val)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Center.TLS.Enabled != tt.want {
+				t.Errorf("CENTER_TLS=%s has set TLS to %t, want %t", tt.val, s.Center.TLS.Enabled, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyCredentials(t *testing.T) {
+	creds := &services.Credentials{
+		Cert:      "identity/device.crt",
+		Key:       "identity/device.key",
+		NATSCreds: "identity/nats.creds",
+	}
+
+	tests := []struct {
+		name string
+		nats services.NATSConfig
+		want services.NATSConfig
+	}{
+		{
+			name: "no auth",
+			want: services.NATSConfig{CredsFile: creds.NATSCreds},
+		},
+		{
+			name: "user",
+			nats: services.NATSConfig{User: "fridge", Password: "secret"},
+			want: services.NATSConfig{User: "fridge", Password: "secret"},
+		},
+		{
+			name: "token",
+			nats: services.NATSConfig{Token: "secret"},
+			want: services.NATSConfig{Token: "secret"},
+		},
+		{
+			name: "nkey",
+			nats: services.NATSConfig{NKeySeedFile: "fridge.nk"},
+			want: services.NATSConfig{NKeySeedFile: "fridge.nk"},
+		},
+		{
+			name: "credentials file",
+			nats: services.NATSConfig{CredsFile: "fridge.creds"},
+			want: services.NATSConfig{CredsFile: "fridge.creds"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Settings{NATS: tt.nats}
+			s.applyCredentials(creds)
+			if !reflect.DeepEqual(s.NATS, tt.want) {
+				t.Errorf("NATS settings = %+v, want %+v", s.NATS, tt.want)
+			}
+		})
+	}
+}
@@ -1,50 +1,159 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"time"
 
-	"github.com/kostiamol/fridgems/entities"
+	"github.com/kostiamol/fridgems/services"
 )
 
 const (
-	devType                 = "fridge"
+	defaultDevType          = "fridge"
 
 	localhost               = "127.0.0.1"
 
 	defaultCenterConfigPort = "3092"
 	defaultCenterDataPort   = "3126"
 
-	retryInterval           = time.Second * 10
+	defaultDataDir          = "data"
+	defaultHTTPAddr         = "127.0.0.1:8080"
+
+	defaultCompartments     = "TopCompart:C:-10:20:random:0,10;BotCompart:C:-30:10:random:-8,2"
+
+	defaultReadingsBuffer   = 100
+	defaultSendWorkers      = 2
+	defaultQueueCapacity    = 64
+	defaultQueuePolicy      = string(services.SpillToDisk)
+
+	defaultRetryInterval    = time.Second * 10
+	defaultStateInterval    = time.Second * 30
+	defaultFlushTimeout     = time.Second * 10
+	defaultShutdownGrace    = time.Second * 3
+
+	defaultLogLevel         = "info"
+	defaultLogFormat        = "text"
 )
 
-var (
-	devMeta = entities.DevMeta{
-		Type: devType,
-	}
-	centerHost       = getEnvVar("CENTER_TCP_ADDR", localhost)
-	centerDataPort   = getEnvVar("CENTER_DATA_TCP_PORT", defaultCenterDataPort)
-	centerConfigPort = getEnvVar("CENTER_CONFIG_TCP_PORT", defaultCenterConfigPort)
+// Exit statuses of the process.
+const (
+	exitOK          = 0
+	exitFailure     = 1
+	exitPendingData = 3
+	exitTimeout     = 4
 )
 
-// GetEnvVar checks whether environmental variable with name 'key' was specified.
-// It returns that variable if it was set and defaultVal otherwise.
-func getEnvVar(key string, defaultVal string) string {
+// envSettingsFile is the environment variable with the path of the settings file.
+const envSettingsFile = "FRIDGE_CONFIG"
+
+// envLoader is used to apply the environment variables that are set to the
+// settings. The first invalid variable is kept in err.
+type envLoader struct {
+	err error
+}
+
+func (e *envLoader) str(key string, dst *string) {
+	if val := os.Getenv(key); len(val) != 0 {
+		*dst = val
+	}
+}
+
+func (e *envLoader) list(key, sep string, dst *[]string) {
+	if val := os.Getenv(key); len(val) != 0 {
+		*dst = splitList(val, sep)
+	}
+}
+
+func (e *envLoader) boolean(key string, dst *bool) {
 	val := os.Getenv(key)
-	if len(val) == 0 {
-		return defaultVal
+	if len(val) == 0 || e.err != nil {
+		return
 	}
-	return val
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		e.err = fmt.Errorf("%s must be a boolean: %q", key, val)
+		return
+	}
+	*dst = b
 }
 
-// CheckCLIArgs checks whether vital args were passed. If not - panic occurs.
-func checkCLIArgs() {
-	if len(devMeta.Name) == 0 {
-		panic("device name is missing")
+func (e *envLoader) integer(key string, dst *int) {
+	val := os.Getenv(key)
+	if len(val) == 0 || e.err != nil {
+		return
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		e.err = fmt.Errorf("%s must be an integer: %q", key, val)
+		return
 	}
+	*dst = n
+}
 
-	if len(devMeta.MAC) == 0 {
-		panic("device MAC is missing")
+func (e *envLoader) duration(key string, dst *time.Duration) {
+	val := os.Getenv(key)
+	if len(val) == 0 || e.err != nil {
+		return
 	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		e.err = fmt.Errorf("%s must be a duration: %q", key, val)
+		return
+	}
+	*dst = d
+}
+
+// splitList splits the list separated with sep dropping empty items.
+func splitList(list, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(list, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// specList is a flag.Value that collects specifications passed with
+// a repeated flag, e.g. -compart. The first flag replaces the specifications
+// taken from the settings file or the environment.
+type specList struct {
+	specs *[]string
+	isSet bool
+}
+
+func (l *specList) String() string {
+	if l.specs == nil {
+		return ""
+	}
+	return strings.Join(*l.specs, ";")
+}
+
+func (l *specList) Set(spec string) error {
+	if !l.isSet {
+		*l.specs = nil
+		l.isSet = true
+	}
+	*l.specs = append(*l.specs, spec)
+	return nil
+}
+
+// commaList is a flag.Value that splits a comma-separated list, e.g. -nats.
+type commaList struct {
+	items *[]string
+}
+
+func (l commaList) String() string {
+	if l.items == nil {
+		return ""
+	}
+	return strings.Join(*l.items, ",")
+}
+
+func (l commaList) Set(list string) error {
+	*l.items = splitList(list, ",")
+	return nil
 }
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/alarm"
+	"github.com/kostiamol/fridgems/clock"
+	"github.com/kostiamol/fridgems/entities"
+	"github.com/kostiamol/fridgems/services"
+	"github.com/kostiamol/fridgems/supervisor"
+	"github.com/nats-io/go-nats"
+	"google.golang.org/grpc"
+)
+
+// device is used to store the services of a virtual fridge.
+type device struct {
+	Meta   entities.DevMeta
+	Config *services.ConfigService
+	Data   *services.DataService
+	Alarms *services.AlarmService
+	Outbox *services.Outbox
+}
+
+// fleet is used to run many virtual fridges in one process. When the
+// connections are shared, all the devices call the center over ConfigConn
+// and DataConn and talk to NATS over NATS, otherwise every device opens
+// its own connections like fridgems does. The ramp-up is driven by Clock.
+type fleet struct {
+	Supervisor *supervisor.Supervisor
+	Log        *logrus.Logger
+	Profiles   []string
+	ConfigConn *grpc.ClientConn
+	DataConn   *grpc.ClientConn
+	NATS       *nats.Conn
+	Clock      clock.Clock
+	mu         sync.RWMutex
+	devices    []*device
+	// start starts the device with the given number, it's startDevice
+	// unless it has been replaced in tests.
+	start func(i int) error
+}
+
+// rampUp starts n devices, rate devices per second or all at once if rate
+// is 0, until ctx is done.
+func (f *fleet) rampUp(ctx context.Context, n int, rate float64) error {
+	c := clock.OrReal(f.Clock)
+	startDevice := f.start
+	if startDevice == nil {
+		startDevice = f.startDevice
+	}
+
+	var tick <-chan time.Time
+	if rate > 0 {
+		t := c.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer t.Stop()
+		tick = t.C()
+	}
+
+	start := c.Now()
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		if tick != nil && i > 0 {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		if err := startDevice(i); err != nil {
+			f.Log.Errorf("fleet: rampUp(): startDevice() has failed: %s", err)
+			continue
+		}
+		if started := i + 1; started%100 == 0 || started == n {
+			f.Log.Infof("%d of %d virtual fridges are running", started, n)
+		}
+	}
+	f.Log.Infof("fleet has ramped up in %s", c.Since(start))
+	return nil
+}
+
+func (f *fleet) startDevice(i int) error {
+	mac, err := deviceMAC(macPrefix, i+1)
+	if err != nil {
+		return err
+	}
+	p := profiles[f.Profiles[i%len(f.Profiles)]]
+
+	d := &device{
+		Meta: entities.DevMeta{
+			Type: devType,
+			Name: fmt.Sprintf("%s-%04d", namePrefix, i+1),
+			MAC:  mac,
+		},
+	}
+	dir := filepath.Join(dataDir, mac)
+
+	comparts := make([]services.Compartment, 0, len(p.compartments))
+	for _, spec := range p.compartmentSpecs(i + 1) {
+		c, err := services.ParseCompartment(spec)
+		if err != nil {
+			return err
+		}
+		comparts = append(comparts, c)
+	}
+	rules := make([]alarm.Rule, 0, len(p.alarms))
+	for _, spec := range p.alarms {
+		r, err := alarm.ParseRule(spec)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, r)
+	}
+
+	store, err := services.NewConfigStore(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return err
+	}
+	if d.Outbox, err = services.NewOutbox(filepath.Join(dir, "outbox")); err != nil {
+		return err
+	}
+	policy, err := services.ParseOverflowPolicy(queuePolicy)
+	if err != nil {
+		return err
+	}
+
+	sup := f.Supervisor.Child(mac)
+
+	d.Config = services.NewConfigService(
+		&d.Meta,
+		entities.Server{
+			Host: centerHost,
+			Port: centerConfigPort,
+		},
+		natsConfig,
+		store,
+		f.Log,
+		retryInterval,
+		stateInterval,
+	)
+	d.Config.SharedCenter, d.Config.SharedNATS = f.ConfigConn, f.NATS
+	d.Config.Run(sup.Child("config"))
+
+	if d.Alarms, err = services.NewAlarmService(rules, &d.Meta, filepath.Join(dir, "alarms.json"),
+		d.Config.NATSConn, f.Log, retryInterval); err != nil {
+		sup.Stop()
+		return err
+	}
+	d.Alarms.Run(sup.Child("alarm"))
+
+	d.Data = services.NewDataService(
+		d.Config.Config,
+		&d.Meta,
+		entities.Server{
+			Host: centerHost,
+			Port: centerDataPort,
+		},
+		comparts,
+		d.Outbox,
+		services.NewSendQueue(queueCapacity, policy),
+		sendWorkers,
+		d.Alarms,
+		f.Log,
+		retryInterval,
+		flushTimeout,
+	)
+	d.Data.SharedCenter = f.DataConn
+	d.Data.Run(sup.Child("data"))
+
+	f.mu.Lock()
+	f.devices = append(f.devices, d)
+	f.mu.Unlock()
+	return nil
+}
+
+// reconnected lets every device restore its subscription and publish its
+// state once the shared NATS connection has been restored.
+func (f *fleet) reconnected(nc *nats.Conn) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, d := range f.devices {
+		d.Config.Reconnected(nc)
+	}
+}
+
+// pending returns the number of the batches left in the outboxes.
+func (f *fleet) pending() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	n := 0
+	for _, d := range f.devices {
+		n += d.Outbox.Len()
+	}
+	return n
+}
+
+// close closes the shared connections.
+func (f *fleet) close() {
+	for _, conn := range []*grpc.ClientConn{f.ConfigConn, f.DataConn} {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+	if f.NATS != nil {
+		f.NATS.Close()
+	}
+}
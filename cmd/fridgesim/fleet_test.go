@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/clock"
+)
+
+// waitTimeout limits the real time the tests wait for the ramp-up.
+const waitTimeout = time.Second * 5
+
+// rampUp is used to run fleet.rampUp on the fake clock recording the
+// devices it starts.
+type rampUp struct {
+	t       *testing.T
+	clock   *clock.Fake
+	started chan int
+	done    chan struct{}
+}
+
+func startRampUp(ctx context.Context, t *testing.T, n int, rate float64, fail func(i int) bool) *rampUp {
+	l := logrus.New()
+	l.Out = ioutil.Discard
+
+	r := &rampUp{
+		t:       t,
+		clock:   clock.NewFake(time.Unix(1543846800, 0)),
+		started: make(chan int, n),
+		done:    make(chan struct{}),
+	}
+	f := &fleet{
+		Log:   l,
+		Clock: r.clock,
+		start: func(i int) error {
+			r.started <- i
+			if fail != nil && fail(i) {
+				return errors.New("device can't be started")
+			}
+			return nil
+		},
+	}
+	go func() {
+		f.rampUp(ctx, n, rate)
+		close(r.done)
+	}()
+	return r
+}
+
+func (r *rampUp) expectStart(i int) {
+	select {
+	case got := <-r.started:
+		if got != i {
+			r.t.Fatalf("device %d has been started, want %d", got, i)
+		}
+	case <-time.After(waitTimeout):
+		r.t.Fatalf("device %d hasn't been started", i)
+	}
+}
+
+func (r *rampUp) expectNoStart() {
+	select {
+	case i := <-r.started:
+		r.t.Fatalf("device %d has been started too early", i)
+	case <-time.After(time.Millisecond * 20):
+	}
+}
+
+func (r *rampUp) wait() {
+	select {
+	case <-r.done:
+	case <-time.After(waitTimeout):
+		r.t.Fatal("ramp-up hasn't finished")
+	}
+}
+
+func TestRampUp(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		rate float64
+		// period is the expected interval between the starts, 0 if all the
+		// devices are expected to start at once.
+		period time.Duration
+		fail   func(i int) bool
+	}{
+		{name: "all at once", n: 5, rate: 0},
+		{name: "ten per second", n: 5, rate: 10, period: time.Millisecond * 100},
+		{name: "one every two seconds", n: 3, rate: 0.5, period: time.Second * 2},
+		{
+			name:   "failed device",
+			n:      3,
+			rate:   1,
+			period: time.Second,
+			fail:   func(i int) bool { return i == 1 },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+			defer cancel()
+
+			r := startRampUp(ctx, t, tt.n, tt.rate, tt.fail)
+			r.expectStart(0)
+			for i := 1; i < tt.n; i++ {
+				if tt.period > 0 {
+					if err := r.clock.WaitForTimers(ctx, 1); err != nil {
+						t.Fatal(err)
+					}
+					r.clock.Advance(tt.period - time.Nanosecond)
+					r.expectNoStart()
+					r.clock.Advance(time.Nanosecond)
+				}
+				r.expectStart(i)
+			}
+			r.wait()
+
+			if n := r.clock.Timers(); n != 0 {
+				t.Errorf("%d ticker(s) are left after the ramp-up", n)
+			}
+		})
+	}
+}
+
+func TestRampUpStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := startRampUp(ctx, t, 10, 1, nil)
+	r.expectStart(0)
+	if err := r.clock.WaitForTimers(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	r.wait()
+
+	r.clock.Advance(time.Minute)
+	r.expectNoStart()
+}
@@ -0,0 +1,201 @@
+// Command fridgesim runs a fleet of virtual fridges in one process to
+// load-test the center. Every device has its own metadata, configuration,
+// data and alarm services and outbox, while the connections to the center
+// and NATS are shared unless -share-conns=false is passed.
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/metrics"
+	"github.com/kostiamol/fridgems/retry"
+	"github.com/kostiamol/fridgems/supervisor"
+	"github.com/nats-io/go-nats"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	sup := supervisor.New(context.Background(), logrus.New())
+
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("main(): panic(): %s", r)
+			sup.Stop()
+			os.Exit(exitFailure)
+		}
+	}()
+
+	if err := loadEnv(); err != nil {
+		logrus.Errorf("main(): loadEnv() has failed: %s", err)
+		panic("environment variables can't be parsed")
+	}
+	flag.IntVar(&devices, "devices", devices, "number of virtual fridges")
+	flag.Float64Var(&rampRate, "ramp", rampRate, "number of fridges started per second, 0 to start all at once")
+	flag.StringVar(&macPrefix, "mac-prefix", macPrefix, "octets the generated MACs start with, e.g. 02-00-00")
+	flag.StringVar(&namePrefix, "name-prefix", namePrefix, "prefix of the generated device names")
+	flag.StringVar(&profileMix, "profiles", profileMix,
+		"mix of the device profiles as name=weight pairs, profiles: "+profileNames())
+	flag.BoolVar(&shareConns, "share-conns", shareConns, "share the connections to the center and NATS")
+	flag.StringVar(&dataDir, "data", dataDir, "directory for the data of the fridges, one subdirectory per MAC")
+	flag.StringVar(&httpAddr, "http", httpAddr, "address serving /metrics and /health, empty to disable it")
+	flag.StringVar(&logLevel, "log-level", logLevel, "level of the logs of the fridges")
+	flag.StringVar(&natsServers, "nats", natsServers, "comma-separated list of NATS server URLs")
+	flag.IntVar(&sendWorkers, "send-workers", sendWorkers, "number of workers sending data of each fridge")
+	flag.IntVar(&queueCapacity, "queue-capacity", queueCapacity, "number of data batches queued by each fridge")
+	flag.StringVar(&queuePolicy, "queue-policy", queuePolicy,
+		"what to do with a batch when the send queue is full: spill, drop-oldest or drop-newest")
+	flag.Parse()
+	if err := checkCLIArgs(); err != nil {
+		logrus.Errorf("main(): checkCLIArgs() has failed: %s", err)
+		panic("invalid arguments")
+	}
+
+	mix, err := parseProfileMix(profileMix)
+	if err != nil {
+		logrus.Errorf("main(): parseProfileMix() has failed: %s", err)
+		panic("profiles can't be parsed")
+	}
+	level, err := logrus.ParseLevel(logLevel)
+	if err != nil {
+		logrus.Errorf("main(): ParseLevel() has failed: %s", err)
+		panic("log level can't be parsed")
+	}
+	log := logrus.New()
+	log.Level = level
+
+	go handleSignals(sup)
+
+	f := &fleet{
+		Supervisor: sup,
+		Log:        log,
+		Profiles:   mix,
+	}
+	if shareConns {
+		if err := connect(sup.Context(), f); err != nil {
+			logrus.Errorf("main(): connect() has failed: %s", err)
+			if sup.Context().Err() == nil {
+				panic("shared connections can't be opened")
+			}
+			f.close()
+			os.Exit(exitOK)
+		}
+	}
+
+	if httpAddr != "" {
+		sup.Go("httpAPI", func(ctx context.Context) error {
+			return serveHTTP(ctx, sup)
+		})
+	}
+
+	logrus.Infof("fleet of %d fridges is starting: profiles: %s, shared connections: %t",
+		devices, profileMix, shareConns)
+	sup.Go("rampUp", func(ctx context.Context) error {
+		return f.rampUp(ctx, devices, rampRate)
+	})
+
+	code := waitForShutdown(sup, f)
+	f.close()
+	os.Exit(code)
+}
+
+// connect opens the connections shared by the fridges. It returns an error
+// if a connection can't be opened or ctx is done before NATS becomes reachable.
+func connect(ctx context.Context, f *fleet) error {
+	var err error
+	if f.ConfigConn, err = grpc.Dial(centerHost+":"+centerConfigPort, grpc.WithInsecure()); err != nil {
+		return err
+	}
+	if f.DataConn, err = grpc.Dial(centerHost+":"+centerDataPort, grpc.WithInsecure()); err != nil {
+		return err
+	}
+
+	opts, err := natsConfig.Options()
+	if err != nil {
+		return err
+	}
+	opts = append(opts, nats.ReconnectHandler(func(nc *nats.Conn) {
+		logrus.Infof("reconnected to %s", nc.ConnectedUrl())
+		f.reconnected(nc)
+	}))
+
+	return retry.Do(ctx, retry.NewBackoff(time.Millisecond*500, retryInterval), func() error {
+		if f.NATS, err = nats.Connect(natsConfig.URL(), opts...); err != nil {
+			logrus.Errorf("connect(): nats connectivity status: DISCONNECTED: %s", err)
+		}
+		return err
+	})
+}
+
+// serveHTTP serves the metrics of the fleet and the health of the workers
+// of all the fridges until ctx is done. /health fails while any of the
+// workers is being restarted.
+func serveHTTP(ctx context.Context, sup *supervisor.Supervisor) error {
+	l, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		for _, h := range sup.Health() {
+			if h.State == supervisor.StateRestarting {
+				status = http.StatusServiceUnavailable
+			}
+		}
+		w.WriteHeader(status)
+	})
+
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	logrus.Infof("fleet API is listening on %s", l.Addr())
+	if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// handleSignals stops the fleet gracefully on SIGINT or SIGTERM.
+func handleSignals(sup *supervisor.Supervisor) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case sig := <-sigs:
+		logrus.Infof("signal %s has been received, fleet is shutting down", sig)
+		sup.Stop()
+	case <-sup.Done():
+	}
+	signal.Stop(sigs)
+}
+
+// waitForShutdown waits for the fridges to finish and returns the exit status.
+func waitForShutdown(sup *supervisor.Supervisor, f *fleet) int {
+	<-sup.Done()
+	finished := sup.WaitTimeout(flushTimeout + shutdownGrace)
+
+	switch {
+	case !finished:
+		logrus.Error("fleet is down: fridges haven't finished in time")
+		return exitTimeout
+	case f.pending() > 0:
+		logrus.Warnf("fleet is down: %d batch(es) are left in the outboxes", f.pending())
+		return exitPendingData
+	default:
+		logrus.Info("fleet is down")
+		return exitOK
+	}
+}
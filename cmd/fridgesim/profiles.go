@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// seedPlaceholder is replaced in the compartment specifications with
+// the number of the device, so every device behaves differently, but
+// the same way on every run.
+const seedPlaceholder = "{seed}"
+
+// profile is used to describe the behavior of a virtual fridge: the
+// specifications of its compartments and its alarm rules in the format
+// accepted by -compart and -alarm of fridgems.
+type profile struct {
+	compartments []string
+	alarms       []string
+}
+
+// profiles lists the behaviors that can be mixed with -profiles.
+var profiles = map[string]profile{
+	// normal fridges keep their setpoints and open the door now and then
+	"normal": {
+		compartments: []string{
+			"TopCompart:C:-10:20:sim:setpoint=4,seed={seed}",
+			"BotCompart:C:-30:10:sim:setpoint=-18,seed={seed}",
+		},
+		alarms: []string{
+			"TopWarm:TopCompart:above:8:5m:1",
+			"BotWarm:BotCompart:above:-12:5m:1",
+		},
+	},
+	// busy fridges are opened often and for long, so they raise alarms
+	"busy": {
+		compartments: []string{
+			"TopCompart:C:-10:20:sim:setpoint=4,doorrate=30,doorduration=1m,doorleak=1m,seed={seed}",
+			"BotCompart:C:-30:10:sim:setpoint=-18,doorrate=30,doorduration=1m,doorleak=1m,seed={seed}",
+		},
+		alarms: []string{
+			"TopWarm:TopCompart:above:8:1m:1",
+			"BotWarm:BotCompart:above:-12:1m:1",
+		},
+	},
+	// warm fridges have a weak compressor that can't keep the setpoint
+	"warm": {
+		compartments: []string{
+			"TopCompart:C:-10:20:sim:setpoint=4,coolrate=0.01,leak=30m,seed={seed}",
+			"BotCompart:C:-30:10:sim:setpoint=-18,coolrate=0.01,leak=30m,seed={seed}",
+		},
+		alarms: []string{
+			"TopWarm:TopCompart:above:8:1m:1",
+			"BotWarm:BotCompart:above:-12:1m:1",
+		},
+	},
+	// faulty fridges have a sensor that often reads out of bounds
+	"faulty": {
+		compartments: []string{
+			"TopCompart:C:-10:20:random:-20,30",
+			"BotCompart:C:-30:10:sim:setpoint=-18,seed={seed}",
+		},
+	},
+}
+
+// compartmentSpecs returns the compartment specifications of the device with
+// the given number.
+func (p profile) compartmentSpecs(device int) []string {
+	specs := make([]string, 0, len(p.compartments))
+	for _, spec := range p.compartments {
+		specs = append(specs, strings.Replace(spec, seedPlaceholder, strconv.Itoa(device), -1))
+	}
+	return specs
+}
+
+// parseProfileMix parses the comma-separated list of name=weight pairs,
+// e.g. "normal=8,busy=1,faulty=1", where weight is the number of devices
+// with the profile out of every sum of the weights. It returns the profile
+// names repeated by their weights.
+func parseProfileMix(mix string) ([]string, error) {
+	var names []string
+	for _, pair := range strings.Split(mix, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		name, weight := pair, 1
+		if i := strings.Index(pair, "="); i >= 0 {
+			w, err := strconv.Atoi(strings.TrimSpace(pair[i+1:]))
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("invalid weight of profile %q", pair[:i])
+			}
+			name, weight = strings.TrimSpace(pair[:i]), w
+		}
+		if _, ok := profiles[name]; !ok {
+			return nil, fmt.Errorf("unknown profile %q, known profiles: %s", name, profileNames())
+		}
+		for i := 0; i < weight; i++ {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no profiles have been specified")
+	}
+	return names, nil
+}
+
+func profileNames() string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// deviceMAC returns the MAC of the device with the given number made of
+// the prefix and the number in the remaining octets.
+func deviceMAC(prefix string, device int) (string, error) {
+	octets := strings.Split(prefix, "-")
+	for _, o := range octets {
+		if n, err := strconv.ParseUint(o, 16, 8); err != nil || len(o) != 2 || n > 0xff {
+			return "", fmt.Errorf("invalid MAC prefix %q", prefix)
+		}
+	}
+
+	free := 6 - len(octets)
+	if free < 1 || device >= 1<<(8*uint(free)) {
+		return "", fmt.Errorf("MAC prefix %q leaves no room for device %d", prefix, device)
+	}
+
+	mac := strings.ToUpper(prefix)
+	for i := free - 1; i >= 0; i-- {
+		mac += fmt.Sprintf("-%02X", (device>>(8*uint(i)))&0xff)
+	}
+	return mac, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProfileMix(t *testing.T) {
+	tests := []struct {
+		mix string
+		// want is the number of the devices with each profile out of
+		// every len(mix) devices.
+		want    map[string]int
+		wantErr bool
+	}{
+		{mix: "normal=8,busy=1,faulty=1", want: map[string]int{"normal": 8, "busy": 1, "faulty": 1}},
+		{mix: " normal = 2 , warm=1 ", want: map[string]int{"normal": 2, "warm": 1}},
+		{mix: "normal,busy", want: map[string]int{"normal": 1, "busy": 1}},
+		{mix: "normal=0,busy=3", want: map[string]int{"busy": 3}},
+		{mix: "normal=1,,faulty=1", want: map[string]int{"normal": 1, "faulty": 1}},
+		{mix: "normal=0", wantErr: true},
+		{mix: "", wantErr: true},
+		{mix: "normal=-1", wantErr: true},
+		{mix: "normal=x", wantErr: true},
+		{mix: "frozen=1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mix, func(t *testing.T) {
+			names, err := parseProfileMix(tt.mix)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseProfileMix(%q) = %v, want an error", tt.mix, names)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]int)
+			for _, name := range names {
+				got[name]++
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProfileMix(%q) = %v, want %v", tt.mix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceMAC(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		device  int
+		want    string
+		wantErr bool
+	}{
+		{prefix: "02-00-00", device: 1, want: "02-00-00-00-00-01"},
+		{prefix: "02-00-00", device: 0x10203, want: "02-00-00-01-02-03"},
+		{prefix: "0a-1b-2c-3d-4e", device: 255, want: "0A-1B-2C-3D-4E-FF"},
+		{prefix: "0a-1b-2c-3d-4e", device: 256, wantErr: true},
+		{prefix: "02-00-00-00-00-00", device: 1, wantErr: true},
+		{prefix: "02-0-00", device: 1, wantErr: true},
+		{prefix: "02-zz-00", device: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		mac, err := deviceMAC(tt.prefix, tt.device)
+		switch {
+		case tt.wantErr && err == nil:
+			t.Errorf("deviceMAC(%q, %d) = %s, want an error", tt.prefix, tt.device, mac)
+		case !tt.wantErr && err != nil:
+			t.Errorf("deviceMAC(%q, %d) has failed: %s", tt.prefix, tt.device, err)
+		case mac != tt.want:
+			t.Errorf("deviceMAC(%q, %d) = %s, want %s", tt.prefix, tt.device, mac, tt.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kostiamol/fridgems/services"
+	"github.com/nats-io/go-nats"
+)
+
+const (
+	devType = "fridge"
+
+	localhost = "127.0.0.1"
+
+	defaultCenterConfigPort = "3092"
+	defaultCenterDataPort   = "3126"
+
+	defaultDataDir    = "simdata"
+	defaultHTTPAddr   = "127.0.0.1:8090"
+	defaultDevices    = 10
+	defaultRampRate   = 10
+	defaultMACPrefix  = "02-00-00"
+	defaultNamePrefix = "sim"
+	defaultProfiles   = "normal=8,busy=1,faulty=1"
+	defaultLogLevel   = "warning"
+
+	defaultSendWorkers   = 1
+	defaultQueueCapacity = 16
+	defaultQueuePolicy   = string(services.SpillToDisk)
+
+	retryInterval = time.Second * 10
+	stateInterval = time.Second * 30
+	flushTimeout  = time.Second * 10
+	shutdownGrace = time.Second * 3
+)
+
+// Exit statuses of the process.
+const (
+	exitOK          = 0
+	exitFailure     = 1
+	exitPendingData = 3
+	exitTimeout     = 4
+)
+
+var (
+	centerHost       = getEnvVar("CENTER_TCP_ADDR", localhost)
+	centerDataPort   = getEnvVar("CENTER_DATA_TCP_PORT", defaultCenterDataPort)
+	centerConfigPort = getEnvVar("CENTER_CONFIG_TCP_PORT", defaultCenterConfigPort)
+	natsServers      = getEnvVar("NATS_URL", nats.DefaultURL)
+	natsConfig       = services.NATSConfig{
+		User:         getEnvVar("NATS_USER", ""),
+		Password:     getEnvVar("NATS_PASSWORD", ""),
+		Token:        getEnvVar("NATS_TOKEN", ""),
+		NKeySeedFile: getEnvVar("NATS_NKEY_SEED", ""),
+		CredsFile:    getEnvVar("NATS_CREDS", ""),
+		CACert:       getEnvVar("NATS_CA_CERT", ""),
+		ClientCert:   getEnvVar("NATS_CLIENT_CERT", ""),
+		ClientKey:    getEnvVar("NATS_CLIENT_KEY", ""),
+	}
+	dataDir       = getEnvVar("FLEET_DATA_DIR", defaultDataDir)
+	httpAddr      = getEnvVar("FLEET_HTTP_ADDR", defaultHTTPAddr)
+	devices       = defaultDevices
+	rampRate      = float64(defaultRampRate)
+	macPrefix     = getEnvVar("FLEET_MAC_PREFIX", defaultMACPrefix)
+	namePrefix    = getEnvVar("FLEET_NAME_PREFIX", defaultNamePrefix)
+	profileMix    = getEnvVar("FLEET_PROFILES", defaultProfiles)
+	shareConns    = true
+	logLevel      = getEnvVar("FLEET_LOG_LEVEL", defaultLogLevel)
+	sendWorkers   = defaultSendWorkers
+	queueCapacity = defaultQueueCapacity
+	queuePolicy   = getEnvVar("FLEET_QUEUE_POLICY", defaultQueuePolicy)
+)
+
+// GetEnvVar checks whether environmental variable with name 'key' was specified.
+// It returns that variable if it was set and defaultVal otherwise.
+func getEnvVar(key string, defaultVal string) string {
+	val := os.Getenv(key)
+	if len(val) == 0 {
+		return defaultVal
+	}
+	return val
+}
+
+// loadEnv applies the numeric and boolean environment variables, which,
+// unlike the string ones, can be invalid. It returns an error for the first
+// invalid variable.
+func loadEnv() error {
+	var err error
+	if devices, err = getEnvInt("FLEET_DEVICES", devices); err != nil {
+		return err
+	}
+	if rampRate, err = getEnvFloat("FLEET_RAMP_RATE", rampRate); err != nil {
+		return err
+	}
+	if shareConns, err = getEnvBool("FLEET_SHARE_CONNS", shareConns); err != nil {
+		return err
+	}
+	if sendWorkers, err = getEnvInt("FLEET_SEND_WORKERS", sendWorkers); err != nil {
+		return err
+	}
+	queueCapacity, err = getEnvInt("FLEET_QUEUE_CAPACITY", queueCapacity)
+	return err
+}
+
+// getEnvInt works like getEnvVar for integer variables. It returns an error
+// if the variable isn't an integer.
+func getEnvInt(key string, defaultVal int) (int, error) {
+	val := os.Getenv(key)
+	if len(val) == 0 {
+		return defaultVal, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return defaultVal, fmt.Errorf("%s must be an integer: %q", key, val)
+	}
+	return n, nil
+}
+
+// getEnvFloat works like getEnvVar for numeric variables. It returns an error
+// if the variable isn't a number.
+func getEnvFloat(key string, defaultVal float64) (float64, error) {
+	val := os.Getenv(key)
+	if len(val) == 0 {
+		return defaultVal, nil
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return defaultVal, fmt.Errorf("%s must be a number: %q", key, val)
+	}
+	return f, nil
+}
+
+// getEnvBool works like getEnvVar for boolean variables. It returns an error
+// if the variable isn't a boolean.
+func getEnvBool(key string, defaultVal bool) (bool, error) {
+	val := os.Getenv(key)
+	if len(val) == 0 {
+		return defaultVal, nil
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return defaultVal, fmt.Errorf("%s must be a boolean: %q", key, val)
+	}
+	return b, nil
+}
+
+// checkCLIArgs checks whether vital args are valid. It returns an error
+// for the first invalid one.
+func checkCLIArgs() error {
+	if devices < 1 {
+		return errors.New("number of devices must be positive")
+	}
+
+	if rampRate < 0 {
+		return errors.New("ramp-up rate must not be negative")
+	}
+
+	natsConfig.Servers = nil
+	for _, url := range strings.Split(natsServers, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			natsConfig.Servers = append(natsConfig.Servers, url)
+		}
+	}
+	if len(natsConfig.Servers) == 0 {
+		return errors.New("NATS servers are missing")
+	}
+
+	if sendWorkers < 1 {
+		return errors.New("number of sender workers must be positive")
+	}
+
+	if queueCapacity < 1 {
+		return errors.New("send queue capacity must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestLoadEnv(t *testing.T) {
+	tests := []struct {
+		key     string
+		val     string
+		wantErr bool
+		// applied reports whether the variable has been applied.
+		applied func() bool
+	}{
+		{key: "FLEET_SHARE_CONNS", val: "1", applied: func() bool { return shareConns }},
+		{key: "FLEET_SHARE_CONNS", val: "TRUE", applied: func() bool { return shareConns }},
+		{key: "FLEET_SHARE_CONNS", val: "false", applied: func() bool { return !shareConns }},
+		{key: "FLEET_SHARE_CONNS", val: "yes", wantErr: true},
+		{key: "FLEET_DEVICES", val: "250", applied: func() bool { return devices == 250 }},
+		{key: "FLEET_DEVICES", val: "many", wantErr: true},
+		{key: "FLEET_RAMP_RATE", val: "2.5", applied: func() bool { return rampRate == 2.5 }},
+		{key: "FLEET_RAMP_RATE", val: "fast", wantErr: true},
+		{key: "FLEET_SEND_WORKERS", val: "4", applied: func() bool { return sendWorkers == 4 }},
+		{key: "FLEET_QUEUE_CAPACITY", val: "1k", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.val, func(t *testing.T) {
+			t.Setenv(tt.key, tt.val)
+			devices, rampRate, sendWorkers, queueCapacity = defaultDevices, defaultRampRate, defaultSendWorkers, defaultQueueCapacity
+			// the opposite of the value set, so that applying it is noticed
+			shareConns = tt.val == "false"
+
+			err := loadEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("%s=%s has been accepted", tt.key, tt.val)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.applied() {
+				t.Errorf("%s=%s hasn't been applied", tt.key, tt.val)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+// Package downsample provides the modes of reducing the readings of the
+// compartments before they are sent to the center.
+package downsample
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Mode is used to specify how the readings are reduced.
+type Mode string
+
+// Modes of downsampling.
+const (
+	// None keeps all the readings.
+	None Mode = ""
+	// Min keeps the lowest reading of each window.
+	Min Mode = "min"
+	// Max keeps the highest reading of each window.
+	Max Mode = "max"
+	// Mean replaces the readings of each window with their mean
+	// stamped with the start of the window.
+	Mean Mode = "mean"
+	// Last keeps the last reading of each window.
+	Last Mode = "last"
+	// Deadband keeps the readings that differ from the last kept one
+	// by more than the deviation.
+	Deadband Mode = "deadband"
+	// SwingingDoor keeps the readings needed to restore the others by linear
+	// interpolation within the deviation.
+	SwingingDoor Mode = "swingingdoor"
+)
+
+// Point is used to store a reading. Time is a unix timestamp in milliseconds.
+type Point struct {
+	Time int64
+	Temp float32
+}
+
+// Settings is used to store the mode of downsampling with its parameters.
+// Window is the width of the windows in milliseconds used by Min, Max, Mean and Last.
+// Deviation is used by Deadband and SwingingDoor.
+type Settings struct {
+	Mode      Mode
+	Window    int64
+	Deviation float32
+}
+
+// Validate checks that the settings can be applied.
+func (s Settings) Validate() error {
+	switch s.Mode {
+	case None:
+	case Min, Max, Mean, Last:
+		if s.Window <= 0 {
+			return fmt.Errorf("downsample: window of mode %q must be positive, got %d", s.Mode, s.Window)
+		}
+	case Deadband, SwingingDoor:
+		if s.Deviation <= 0 {
+			return fmt.Errorf("downsample: deviation of mode %q must be positive, got %v", s.Mode, s.Deviation)
+		}
+	default:
+		return fmt.Errorf("downsample: unknown mode %q", s.Mode)
+	}
+	return nil
+}
+
+// Windowed reports whether the mode reduces the readings per window.
+func (m Mode) Windowed() bool {
+	return m == Min || m == Max || m == Mean || m == Last
+}
+
+// Downsampler is used to reduce the batches of readings of the compartments.
+// It keeps the last reading sent for each compartment, so Deadband works across
+// the batches. It isn't safe for concurrent use.
+type Downsampler struct {
+	last map[string]Point
+}
+
+// NewDownsampler creates and initializes new Downsampler object.
+// It returns initialized object.
+func NewDownsampler() *Downsampler {
+	return &Downsampler{last: make(map[string]Point)}
+}
+
+// Reduce returns the readings of the compartment that are left after downsampling
+// with the given settings. The returned points are ordered by time, points
+// itself isn't modified.
+func (d *Downsampler) Reduce(compart string, points []Point, s Settings) []Point {
+	sorted := make([]Point, len(points))
+	copy(sorted, points)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time < sorted[j].Time })
+	points = sorted
+
+	var reduced []Point
+	switch {
+	case s.Mode.Windowed():
+		reduced = Window(s.Mode, points, s.Window)
+	case s.Mode == Deadband:
+		last, ok := d.last[compart]
+		if !ok {
+			last = Point{Temp: float32(math.NaN())}
+		}
+		reduced = DeadbandFrom(points, s.Deviation, last)
+	case s.Mode == SwingingDoor:
+		reduced = SwingingDoorCompress(points, s.Deviation)
+	default:
+		reduced = points
+	}
+
+	if len(reduced) > 0 {
+		d.last[compart] = reduced[len(reduced)-1]
+	}
+	return reduced
+}
+
+// Window reduces the points ordered by time within each window of the given
+// width in milliseconds according to mode.
+func Window(mode Mode, points []Point, width int64) []Point {
+	var reduced []Point
+	for i := 0; i < len(points); {
+		start := points[i].Time - points[i].Time%width
+		j := i
+		for j < len(points) && points[j].Time < start+width {
+			j++
+		}
+		reduced = append(reduced, reduceWindow(mode, points[i:j], start))
+		i = j
+	}
+	return reduced
+}
+
+func reduceWindow(mode Mode, points []Point, start int64) Point {
+	switch mode {
+	case Min:
+		p := points[0]
+		for _, q := range points[1:] {
+			if q.Temp < p.Temp {
+				p = q
+			}
+		}
+		return p
+	case Max:
+		p := points[0]
+		for _, q := range points[1:] {
+			if q.Temp > p.Temp {
+				p = q
+			}
+		}
+		return p
+	case Mean:
+		var sum float64
+		for _, q := range points {
+			sum += float64(q.Temp)
+		}
+		return Point{Time: start, Temp: float32(sum / float64(len(points)))}
+	default:
+		return points[len(points)-1]
+	}
+}
+
+// DeadbandFrom keeps the points ordered by time that differ by more than
+// deviation from the last kept point, starting with last. A NaN temperature
+// of last keeps the first point.
+func DeadbandFrom(points []Point, deviation float32, last Point) []Point {
+	var reduced []Point
+	for _, p := range points {
+		if math.IsNaN(float64(last.Temp)) || math.Abs(float64(p.Temp-last.Temp)) > float64(deviation) {
+			reduced = append(reduced, p)
+			last = p
+		}
+	}
+	return reduced
+}
+
+// SwingingDoorCompress applies swinging door compression to the points ordered by time.
+// The first and the last points are always kept, every dropped point lies within
+// deviation of the line between the kept points around it. Of the points taken
+// at the same time only the first one is kept, since no slope leads between them.
+func SwingingDoorCompress(points []Point, deviation float32) []Point {
+	points = firstOfEachTime(points)
+	if len(points) <= 2 {
+		return points
+	}
+
+	dev := float64(deviation)
+	pivot := points[0]
+	reduced := []Point{pivot}
+	upper, lower := math.Inf(1), math.Inf(-1)
+
+	for i := 1; i < len(points); i++ {
+		p := points[i]
+		dt := float64(p.Time - pivot.Time)
+		if dt <= 0 {
+			continue
+		}
+		// the line from the pivot to p has to pass between the doors, i.e. within
+		// deviation of every point between them, otherwise the previous point
+		// is kept and becomes the pivot
+		if slope := float64(p.Temp-pivot.Temp) / dt; slope > upper || slope < lower {
+			pivot = points[i-1]
+			reduced = append(reduced, pivot)
+			upper, lower = math.Inf(1), math.Inf(-1)
+			dt = float64(p.Time - pivot.Time)
+		}
+		upper = math.Min(upper, (float64(p.Temp-pivot.Temp)+dev)/dt)
+		lower = math.Max(lower, (float64(p.Temp-pivot.Temp)-dev)/dt)
+	}
+
+	if last := points[len(points)-1]; reduced[len(reduced)-1] != last {
+		reduced = append(reduced, last)
+	}
+	return reduced
+}
+
+// firstOfEachTime returns the points ordered by time without the ones taken
+// at the same time as the point before them.
+func firstOfEachTime(points []Point) []Point {
+	unique := make([]Point, 0, len(points))
+	for i, p := range points {
+		if i == 0 || p.Time != points[i-1].Time {
+			unique = append(unique, p)
+		}
+	}
+	return unique
+}
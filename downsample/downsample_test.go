@@ -0,0 +1,159 @@
+package downsample
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestWindow(t *testing.T) {
+	points := []Point{
+		{Time: 0, Temp: 5}, {Time: 300, Temp: 3}, {Time: 900, Temp: 4},
+		{Time: 1000, Temp: 7}, {Time: 1500, Temp: 6},
+		{Time: 3200, Temp: 1},
+	}
+
+	tests := []struct {
+		mode Mode
+		want []Point
+	}{
+		{mode: Min, want: []Point{{Time: 300, Temp: 3}, {Time: 1500, Temp: 6}, {Time: 3200, Temp: 1}}},
+		{mode: Max, want: []Point{{Time: 0, Temp: 5}, {Time: 1000, Temp: 7}, {Time: 3200, Temp: 1}}},
+		{mode: Mean, want: []Point{{Time: 0, Temp: 4}, {Time: 1000, Temp: 6.5}, {Time: 3000, Temp: 1}}},
+		{mode: Last, want: []Point{{Time: 900, Temp: 4}, {Time: 1500, Temp: 6}, {Time: 3200, Temp: 1}}},
+	}
+
+	for _, tt := range tests {
+		if got := Window(tt.mode, points, 1000); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Window(%s) = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestDeadband(t *testing.T) {
+	s := Settings{Mode: Deadband, Deviation: 0.5}
+
+	tests := []struct {
+		compart string
+		points  []Point
+		want    []Point
+	}{
+		{
+			compart: "TopCompart",
+			points:  []Point{{Time: 0, Temp: 4}, {Time: 1, Temp: 4.3}, {Time: 2, Temp: 4.6}, {Time: 3, Temp: 4.7}},
+			want:    []Point{{Time: 0, Temp: 4}, {Time: 2, Temp: 4.6}},
+		},
+		// the last kept reading of the previous batch is carried over
+		{
+			compart: "TopCompart",
+			points:  []Point{{Time: 4, Temp: 4.8}, {Time: 5, Temp: 4.2}, {Time: 6, Temp: 4}},
+			want:    []Point{{Time: 6, Temp: 4}},
+		},
+		// the readings of each compartment are compared separately
+		{
+			compart: "BotCompart",
+			points:  []Point{{Time: 4, Temp: 4.2}, {Time: 5, Temp: 4.4}},
+			want:    []Point{{Time: 4, Temp: 4.2}},
+		},
+		{
+			compart: "TopCompart",
+			points:  []Point{{Time: 7, Temp: 4.4}},
+		},
+	}
+
+	d := NewDownsampler()
+	for i, tt := range tests {
+		if got := d.Reduce(tt.compart, tt.points, s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("batch %d of %s = %v, want %v", i, tt.compart, got, tt.want)
+		}
+	}
+}
+
+func TestSwingingDoor(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []Point
+		want   []Point
+	}{
+		{
+			name:   "two points",
+			points: []Point{{Time: 0, Temp: 0}, {Time: 1, Temp: 5}},
+			want:   []Point{{Time: 0, Temp: 0}, {Time: 1, Temp: 5}},
+		},
+		{
+			name:   "line",
+			points: []Point{{Time: 0, Temp: 0}, {Time: 1, Temp: 1}, {Time: 2, Temp: 2}, {Time: 3, Temp: 3}},
+			want:   []Point{{Time: 0, Temp: 0}, {Time: 3, Temp: 3}},
+		},
+		{
+			name:   "turn",
+			points: []Point{{Time: 0, Temp: 0}, {Time: 1, Temp: 1}, {Time: 2, Temp: 2}, {Time: 3, Temp: 1}, {Time: 4, Temp: 0}},
+			want:   []Point{{Time: 0, Temp: 0}, {Time: 2, Temp: 2}, {Time: 4, Temp: 0}},
+		},
+		{
+			name:   "duplicate time",
+			points: []Point{{Time: 0, Temp: 0}, {Time: 1, Temp: 1}, {Time: 1, Temp: 5}, {Time: 2, Temp: 2}},
+			want:   []Point{{Time: 0, Temp: 0}, {Time: 2, Temp: 2}},
+		},
+		{
+			name: "duplicate time at the turn",
+			points: []Point{{Time: 0, Temp: 0}, {Time: 1, Temp: 1}, {Time: 2, Temp: 2}, {Time: 2, Temp: 1.5},
+				{Time: 3, Temp: 1}, {Time: 4, Temp: 0}},
+			want: []Point{{Time: 0, Temp: 0}, {Time: 2, Temp: 2}, {Time: 4, Temp: 0}},
+		},
+		{
+			name:   "duplicate last time",
+			points: []Point{{Time: 0, Temp: 0}, {Time: 1, Temp: 1}, {Time: 1, Temp: 3}},
+			want:   []Point{{Time: 0, Temp: 0}, {Time: 1, Temp: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := SwingingDoorCompress(tt.points, 0.1); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: SwingingDoorCompress() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSwingingDoorDeviation(t *testing.T) {
+	const deviation = 0.2
+
+	points := make([]Point, 0, 1000)
+	for i := int64(0); i < 1000; i++ {
+		temp := 4 + math.Sin(float64(i)/50)*2 + math.Sin(float64(i)*7)*0.05
+		points = append(points, Point{Time: i * 1000, Temp: float32(temp)})
+	}
+
+	reduced := SwingingDoorCompress(points, deviation)
+	if len(reduced) >= len(points)/2 {
+		t.Errorf("%d of %d points have been kept", len(reduced), len(points))
+	}
+
+	// every dropped point lies within deviation of the line between the kept
+	// points around it
+	k := 0
+	for _, p := range points {
+		for reduced[k+1].Time < p.Time {
+			k++
+		}
+		a, b := reduced[k], reduced[k+1]
+		line := float64(a.Temp) + float64(b.Temp-a.Temp)*float64(p.Time-a.Time)/float64(b.Time-a.Time)
+		if diff := math.Abs(float64(p.Temp) - line); diff > deviation+1e-5 {
+			t.Fatalf("point %v is %v away from the line between %v and %v", p, diff, a, b)
+		}
+	}
+}
+
+func TestReduceKeepsPoints(t *testing.T) {
+	points := []Point{{Time: 2000, Temp: 5}, {Time: 0, Temp: 4}, {Time: 1000, Temp: 6}}
+	given := append([]Point(nil), points...)
+
+	got := NewDownsampler().Reduce("TopCompart", points, Settings{Mode: Last, Window: 1000})
+	want := []Point{{Time: 0, Temp: 4}, {Time: 1000, Temp: 6}, {Time: 2000, Temp: 5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Reduce() = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(points, given) {
+		t.Errorf("Reduce() has modified the points: %v, want %v", points, given)
+	}
+}
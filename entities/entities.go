@@ -2,39 +2,21 @@
 // and some of their functions.
 package entities
 
-import "time"
+import "crypto/tls"
 
-// Server is used to store IP and open port of a remote server.
+// Server is used to store IP and open port of a remote server and
+// TLS configuration of the connection to it (nil for plaintext).
 type Server struct {
 	Host string
 	Port string
+	TLS  *tls.Config
 }
 
-// DevMeta is used to store device metadata: it's type, name (model) and MAC.
+// DevMeta is used to store device metadata: it's type, name (model), MAC
+// and ID generated on the first boot.
 type DevMeta struct {
 	Type string
 	Name string
 	MAC  string
-}
-
-// ServiceController is used to store StopChan that allows to terminate
-// all the services that listen the channel.
-type ServiceController struct {
-	StopChan chan struct{}
-}
-
-// Terminate closes StopChan to signal all the services to shutdown.
-func (c *ServiceController) Terminate() {
-	select {
-	case <-c.StopChan:
-	default:
-		close(c.StopChan)
-	}
-}
-
-// Wait waits until StopChan will be closed and then makes a pause for 3 seconds
-// in order to give time for all the services shutdown gracefully.
-func (c *ServiceController) Wait() {
-	<-c.StopChan
-	<-time.NewTimer(time.Second * 3).C
+	ID   string
 }
@@ -0,0 +1,58 @@
+package entities
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// AutoInterface is the name of the interface passed to InterfaceMAC to take
+// the MAC of the first interface that is up and isn't a loopback.
+const AutoInterface = "auto"
+
+// NormalizeMAC returns the canonical form of the 48-bit MAC: upper-case
+// hexadecimal octets separated with dashes, e.g. 0A-1B-2C-3D-4E-5F.
+// It accepts the octets separated with colons or dashes and the dotted
+// Cisco form.
+func NormalizeMAC(mac string) (string, error) {
+	hw, err := net.ParseMAC(strings.TrimSpace(mac))
+	if err != nil {
+		return "", fmt.Errorf("invalid MAC %q", mac)
+	}
+	if len(hw) != 6 {
+		return "", fmt.Errorf("MAC %q isn't a 48-bit one", mac)
+	}
+	return formatMAC(hw), nil
+}
+
+// InterfaceMAC returns the canonical MAC of the network interface with
+// the given name or of the first suitable interface if name is AutoInterface.
+func InterfaceMAC(name string) (string, error) {
+	if name != AutoInterface {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return "", err
+		}
+		if len(iface.HardwareAddr) != 6 {
+			return "", fmt.Errorf("interface %s has no 48-bit MAC", name)
+		}
+		return formatMAC(iface.HardwareAddr), nil
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) != 6 {
+			continue
+		}
+		return formatMAC(iface.HardwareAddr), nil
+	}
+	return "", errors.New("no network interface with a MAC has been found")
+}
+
+func formatMAC(hw net.HardwareAddr) string {
+	return strings.ToUpper(strings.Replace(hw.String(), ":", "-", -1))
+}
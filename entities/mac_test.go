@@ -0,0 +1,75 @@
+package entities
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNormalizeMAC(t *testing.T) {
+	tests := []struct {
+		name    string
+		mac     string
+		want    string
+		wantErr bool
+	}{
+		{name: "colons", mac: "0a:1b:2c:3d:4e:5f", want: "0A-1B-2C-3D-4E-5F"},
+		{name: "dashes", mac: "0a-1b-2c-3d-4e-5f", want: "0A-1B-2C-3D-4E-5F"},
+		{name: "canonical", mac: "0A-1B-2C-3D-4E-5F", want: "0A-1B-2C-3D-4E-5F"},
+		{name: "dotted", mac: "0a1b.2c3d.4e5f", want: "0A-1B-2C-3D-4E-5F"},
+		{name: "surrounding spaces", mac: " 0a:1b:2c:3d:4e:5f\n", want: "0A-1B-2C-3D-4E-5F"},
+		{name: "EUI-64", mac: "0a:1b:2c:3d:4e:5f:60:71", wantErr: true},
+		{name: "dotted EUI-64", mac: "0a1b.2c3d.4e5f.6071", wantErr: true},
+		{name: "too short", mac: "0a:1b:2c:3d:4e", wantErr: true},
+		{name: "mixed separators", mac: "0a:1b-2c:3d-4e:5f", wantErr: true},
+		{name: "not hexadecimal", mac: "0g:1b:2c:3d:4e:5f", wantErr: true},
+		{name: "empty", mac: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeMAC(tt.mac)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NormalizeMAC(%q) error = %v, want error %t", tt.mac, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeMAC(%q) = %q, want %q", tt.mac, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterfaceMAC(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, iface := range ifaces {
+		t.Run(iface.Name, func(t *testing.T) {
+			got, err := InterfaceMAC(iface.Name)
+			if len(iface.HardwareAddr) != 6 {
+				if err == nil {
+					t.Errorf("InterfaceMAC(%q) = %q, want error for hardware address %q",
+						iface.Name, got, iface.HardwareAddr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("InterfaceMAC(%q) has failed: %s", iface.Name, err)
+			}
+			if want, _ := NormalizeMAC(iface.HardwareAddr.String()); got != want {
+				t.Errorf("InterfaceMAC(%q) = %q, want %q", iface.Name, got, want)
+			}
+		})
+	}
+
+	if got, err := InterfaceMAC("no-such-interface"); err == nil {
+		t.Errorf("InterfaceMAC() of unknown interface = %q, want error", got)
+	}
+	// the suitable interface may be missing, but a found MAC is canonical
+	if got, err := InterfaceMAC(AutoInterface); err == nil {
+		if want, _ := NormalizeMAC(got); got != want {
+			t.Errorf("InterfaceMAC(%q) = %q, want canonical %q", AutoInterface, got, want)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// Package metrics provides counters, gauges and histograms exposed
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric types of the text exposition format.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// DefBuckets are the default histogram buckets in seconds, suitable for
+// measuring the latency of remote calls.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Sample is used to store a single value of a metric together with
+// the values of its labels.
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// Collector is implemented by every metric that can be registered.
+type Collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry is used to store registered metrics and to write them out.
+type Registry struct {
+	sync.Mutex
+	collectors []Collector
+}
+
+// Default is the registry used by the metrics constructors.
+var Default = &Registry{}
+
+// Register adds collectors to the registry.
+func (r *Registry) Register(cs ...Collector) {
+	r.Lock()
+	r.collectors = append(r.collectors, cs...)
+	r.Unlock()
+}
+
+// WriteText writes all the registered metrics in the text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.Lock()
+	cs := make([]Collector, len(r.collectors))
+	copy(cs, r.collectors)
+	r.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range cs {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler returns the handler serving the registered metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+type desc struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.typ)
+}
+
+func (d *desc) labels(values []string, extra ...string) string {
+	if len(values) != len(d.labelNames) {
+		panic(fmt.Sprintf("metrics: %s: %d label values are passed for %d labels",
+			d.name, len(values), len(d.labelNames)))
+	}
+
+	pairs := make([]string, 0, len(values)+len(extra)/2)
+	for i, v := range values {
+		pairs = append(pairs, d.labelNames[i]+`="`+escapeLabel(v)+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// vec is used to store the values of a metric per combination of labels.
+type vec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newVec(name, help, typ string, labelNames []string) *vec {
+	return &vec{
+		desc:   desc{name: name, help: help, typ: typ, labelNames: labelNames},
+		values: make(map[string]float64),
+	}
+}
+
+func (v *vec) add(delta float64, labelValues []string) {
+	l := v.labels(labelValues)
+	v.mu.Lock()
+	v.values[l] += delta
+	v.mu.Unlock()
+}
+
+func (v *vec) set(val float64, labelValues []string) {
+	l := v.labels(labelValues)
+	v.mu.Lock()
+	v.values[l] = val
+	v.mu.Unlock()
+}
+
+// Value returns the value of the metric with the given label values.
+func (v *vec) Value(labelValues ...string) float64 {
+	l := v.labels(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.values[l]
+}
+
+func (v *vec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.writeHeader(w)
+	if len(v.labelNames) == 0 && len(v.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", v.name)
+		return
+	}
+
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, k, formatFloat(v.values[k]))
+	}
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	*vec
+}
+
+// NewCounterVec creates a counter, registers it in Default and returns it.
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, typeCounter, labelNames)}
+	Default.Register(c)
+	return c
+}
+
+// Inc increments the counter with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+// Add adds non-negative delta to the counter with the given label values.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counter can't be decreased")
+	}
+	c.add(delta, labelValues)
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	*vec
+}
+
+// NewGaugeVec creates a gauge, registers it in Default and returns it.
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, typeGauge, labelNames)}
+	Default.Register(g)
+	return g
+}
+
+// Set sets the gauge with the given label values.
+func (g *GaugeVec) Set(val float64, labelValues ...string) {
+	g.set(val, labelValues)
+}
+
+// Add adds delta to the gauge with the given label values.
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.add(delta, labelValues)
+}
+
+// GaugeFunc is a gauge whose samples are produced on each scrape.
+type GaugeFunc struct {
+	desc
+	fn func() []Sample
+}
+
+// NewGaugeFunc creates a gauge backed by fn, registers it in Default
+// and returns it.
+func NewGaugeFunc(name, help string, fn func() []Sample, labelNames ...string) *GaugeFunc {
+	g := &GaugeFunc{
+		desc: desc{name: name, help: help, typ: typeGauge, labelNames: labelNames},
+		fn:   fn,
+	}
+	Default.Register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	for _, s := range g.fn() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labels(s.LabelValues), formatFloat(s.Value))
+	}
+}
+
+// Histogram counts observations in configurable buckets.
+type Histogram struct {
+	desc
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// NewHistogram creates a histogram with the given upper bounds of the
+// buckets, registers it in Default and returns it.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	b := make([]float64, len(buckets))
+	copy(b, buckets)
+	sort.Float64s(b)
+
+	h := &Histogram{
+		desc:    desc{name: name, help: help, typ: typeHistogram},
+		buckets: b,
+		counts:  make([]uint64, len(b)),
+	}
+	Default.Register(h)
+	return h
+}
+
+// Observe adds a single observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, ub := range h.buckets {
+		if v <= ub {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+	for i, ub := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(nil, "le", formatFloat(ub)), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(nil, "le", "+Inf"), h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
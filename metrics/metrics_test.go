@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestWriteText(t *testing.T) {
+	tests := []struct {
+		name string
+		// collector returns the metric to write, it's created with the
+		// constructor and then given the values.
+		collector func() Collector
+	}{
+		{
+			name: "counter",
+			collector: func() Collector {
+				c := NewCounterVec("test_requests_total", "Number of requests by method.", "method")
+				c.Inc("GET")
+				c.Inc("GET")
+				c.Add(2.5, "PATCH")
+				return c
+			},
+		},
+		{
+			name: "counter_without_labels",
+			collector: func() Collector {
+				return NewCounterVec("test_errors_total", "Number of errors.")
+			},
+		},
+		{
+			name: "gauge",
+			collector: func() Collector {
+				g := NewGaugeVec("test_temperature", "The last temperature.", "compartment", "unit")
+				g.Set(4.25, "TopCompart", "C")
+				g.Set(-18, "BotCompart", "C")
+				g.Add(0.5, "TopCompart", "C")
+				g.Set(math.Inf(1), "Door", "C")
+				return g
+			},
+		},
+		{
+			name: "gauge_func",
+			collector: func() Collector {
+				return NewGaugeFunc("test_workers", "Number of workers by state.", func() []Sample {
+					return []Sample{{LabelValues: []string{"running"}, Value: 3}, {LabelValues: []string{"stopped"}, Value: 1}}
+				}, "state")
+			},
+		},
+		{
+			name: "histogram",
+			collector: func() Collector {
+				h := NewHistogram("test_duration_seconds", "Latency of the calls.", []float64{1, 0.1, 0.5})
+				for _, v := range []float64{0.05, 0.1, 0.3, 0.7, 2} {
+					h.Observe(v)
+				}
+				return h
+			},
+		},
+		{
+			name: "escaping",
+			collector: func() Collector {
+				c := NewCounterVec("test_escaped_total", "Help with a backslash \\ and\na new line.", "path")
+				c.Inc(`C:\data`)
+				c.Inc(`say "hi"`)
+				c.Inc("two\nlines")
+				return c
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Registry{}
+			r.Register(tt.collector())
+			var b bytes.Buffer
+			if err := r.WriteText(&b); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := ioutil.WriteFile(golden, b.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b.Bytes(), want) {
+				t.Errorf("WriteText() =\n%s\nwant\n%s", b.Bytes(), want)
+			}
+		})
+	}
+}
+
+func TestValue(t *testing.T) {
+	c := NewCounterVec("test_values_total", "Number of values.", "policy")
+	c.Inc("drop")
+	c.Add(2, "drop")
+
+	if got := c.Value("drop"); got != 3 {
+		t.Errorf("Value(drop) = %v, want 3", got)
+	}
+	if got := c.Value("spill"); got != 0 {
+		t.Errorf("Value(spill) = %v, want 0", got)
+	}
+}
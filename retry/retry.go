@@ -0,0 +1,148 @@
+// Package retry provides exponential backoff with full jitter for retrying
+// operations until they succeed, run out of time or are cancelled.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/kostiamol/fridgems/clock"
+)
+
+const defaultMultiplier = 2
+
+// ErrMaxElapsed is returned by Do when the operation has kept failing
+// for longer than MaxElapsed.
+var ErrMaxElapsed = errors.New("retry: max elapsed time has been exceeded")
+
+var (
+	randMu sync.Mutex
+	rnd    = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Backoff is used to compute the delays between the attempts of an operation.
+// The n-th delay is chosen at random from [0, min(Max, Initial*Multiplier^n)],
+// i.e. with full jitter, so that the clients that failed together don't retry
+// together. Once MaxElapsed has passed since the first delay, no more delays
+// are given; zero MaxElapsed means retrying forever. MaxElapsed and the
+// delays of Do are measured by Clock, nil Clock means the real one.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	MaxElapsed time.Duration
+	Clock      clock.Clock
+	attempt    int
+	start      time.Time
+}
+
+// NewBackoff creates and initializes new Backoff object that doubles the
+// delays from initial up to max and retries forever.
+// It returns initialized object.
+func NewBackoff(initial, max time.Duration) *Backoff {
+	return &Backoff{
+		Initial:    initial,
+		Max:        max,
+		Multiplier: defaultMultiplier,
+	}
+}
+
+// Next returns the delay before the next attempt. It reports false once
+// MaxElapsed has been exceeded.
+func (b *Backoff) Next() (time.Duration, bool) {
+	c := clock.OrReal(b.Clock)
+	if b.attempt == 0 {
+		b.start = c.Now()
+	}
+	if b.MaxElapsed > 0 && c.Since(b.start) >= b.MaxElapsed {
+		return 0, false
+	}
+
+	ceiling := b.ceiling()
+	b.attempt++
+
+	randMu.Lock()
+	d := time.Duration(rnd.Int63n(int64(ceiling) + 1))
+	randMu.Unlock()
+	return d, true
+}
+
+// Reset starts the backoff over, it's called once the operation has succeeded.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+func (b *Backoff) ceiling() time.Duration {
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = defaultMultiplier
+	}
+
+	ceiling := float64(b.Initial)
+	for i := 0; i < b.attempt && ceiling < float64(b.Max); i++ {
+		ceiling *= multiplier
+	}
+	if ceiling > float64(b.Max) {
+		ceiling = float64(b.Max)
+	}
+	return time.Duration(ceiling)
+}
+
+// sleep waits for d of c or until ctx is done. It returns ctx.Err() in the latter case.
+func sleep(ctx context.Context, c clock.Clock, d time.Duration) error {
+	t := c.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// permanentError is used to stop retrying with the wrapped error.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Permanent wraps err returned by an operation, so that Do stops retrying
+// and returns err as is.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// Do calls op until it succeeds, returns a Permanent error, b runs out of
+// MaxElapsed or ctx is done, waiting for the delays given by b in between.
+// It returns nil on success, the permanent error, ErrMaxElapsed or ctx.Err().
+func Do(ctx context.Context, b *Backoff, op func() error) error {
+	b.Reset()
+	for {
+		err := op()
+		if err == nil {
+			b.Reset()
+			return nil
+		}
+		if p, ok := err.(permanentError); ok {
+			return p.err
+		}
+
+		d, ok := b.Next()
+		if !ok {
+			return ErrMaxElapsed
+		}
+		if err := sleep(ctx, clock.OrReal(b.Clock), d); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kostiamol/fridgems/clock"
+)
+
+// waitTimeout limits the real time the tests wait for Do.
+const waitTimeout = time.Second * 5
+
+var errFailed = errors.New("operation has failed")
+
+func TestBackoffCeiling(t *testing.T) {
+	tests := []struct {
+		name       string
+		multiplier float64
+		// want are the ceilings of the consecutive delays.
+		want []time.Duration
+	}{
+		{name: "doubling", multiplier: 2, want: []time.Duration{1, 2, 4, 8, 10, 10}},
+		{name: "tripling", multiplier: 3, want: []time.Duration{1, 3, 9, 10, 10}},
+		{name: "default multiplier", multiplier: 0, want: []time.Duration{1, 2, 4, 8, 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBackoff(time.Second, time.Second*10)
+			b.Multiplier = tt.multiplier
+			b.Clock = clock.NewFake(time.Unix(1543846800, 0))
+
+			for i, want := range tt.want {
+				want *= time.Second
+				if got := b.ceiling(); got != want {
+					t.Fatalf("ceiling of delay %d = %s, want %s", i, got, want)
+				}
+				if _, ok := b.Next(); !ok {
+					t.Fatalf("delay %d hasn't been given", i)
+				}
+			}
+
+			b.Reset()
+			if got := b.ceiling(); got != time.Second {
+				t.Errorf("ceiling after Reset() = %s, want %s", got, time.Second)
+			}
+		})
+	}
+}
+
+func TestBackoffFullJitter(t *testing.T) {
+	const (
+		ceiling = time.Second
+		samples = 1000
+	)
+	b := NewBackoff(ceiling, ceiling)
+	b.Clock = clock.NewFake(time.Unix(1543846800, 0))
+
+	var min, max time.Duration = ceiling, 0
+	for i := 0; i < samples; i++ {
+		d, _ := b.Next()
+		if d < 0 || d > ceiling {
+			t.Fatalf("delay = %s, want within [0, %s]", d, ceiling)
+		}
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+	}
+	// the delays are spread over the whole range rather than around the ceiling
+	if min > ceiling/10 || max < ceiling*9/10 {
+		t.Errorf("delays are within [%s, %s], want them spread over [0, %s]", min, max, ceiling)
+	}
+}
+
+// runDo calls Do in a new goroutine and advances the clock past each of
+// its delays until it returns.
+func runDo(ctx context.Context, t *testing.T, b *Backoff, c *clock.Fake, op func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- Do(ctx, b, op) }()
+
+	timeout := time.After(waitTimeout)
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-timeout:
+			t.Fatal("Do() hasn't returned")
+		default:
+		}
+
+		if deadlines := c.Deadlines(); len(deadlines) > 0 {
+			c.Set(deadlines[0])
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDo(t *testing.T) {
+	permanent := errors.New("operation has failed for good")
+
+	tests := []struct {
+		name string
+		// results are the errors returned by the consecutive calls of the op.
+		results []error
+		// cancel cancels the context once the op has been called.
+		cancel    bool
+		wantErr   error
+		wantCalls int
+	}{
+		{name: "success", results: []error{nil}, wantCalls: 1},
+		{name: "success after failures", results: []error{errFailed, errFailed, nil}, wantCalls: 3},
+		{name: "permanent", results: []error{errFailed, Permanent(permanent)}, wantErr: permanent, wantCalls: 2},
+		{name: "max elapsed", results: []error{errFailed}, wantErr: ErrMaxElapsed},
+		{name: "cancelled", results: []error{errFailed}, cancel: true, wantErr: context.Canceled, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := clock.NewFake(time.Unix(1543846800, 0))
+			b := NewBackoff(time.Second, time.Second*4)
+			b.Clock = c
+			b.MaxElapsed = time.Minute
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			calls := 0
+			err := runDo(ctx, t, b, c, func() error {
+				calls++
+				if tt.cancel {
+					cancel()
+				}
+				if calls > len(tt.results) {
+					return tt.results[len(tt.results)-1]
+				}
+				return tt.results[calls-1]
+			})
+
+			if err != tt.wantErr {
+				t.Errorf("Do() = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantCalls > 0 && calls != tt.wantCalls {
+				t.Errorf("op has been called %d time(s), want %d", calls, tt.wantCalls)
+			}
+			if tt.wantErr == ErrMaxElapsed {
+				if elapsed := c.Since(time.Unix(1543846800, 0)); elapsed < b.MaxElapsed {
+					t.Errorf("Do() has given up after %s, want at least %s", elapsed, b.MaxElapsed)
+				}
+			}
+		})
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	if err := Permanent(nil); err != nil {
+		t.Errorf("Permanent(nil) = %v, want nil", err)
+	}
+	if err := Permanent(errFailed); err.Error() != errFailed.Error() {
+		t.Errorf("Permanent(err).Error() = %q, want %q", err.Error(), errFailed.Error())
+	}
+}
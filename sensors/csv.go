@@ -0,0 +1,76 @@
+package sensors
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CSV replays temperatures previously recorded in a CSV file. Each call
+// of ReadTemp returns the value from the next row of the chosen column
+// and starts over once the end of the file is reached. Rows whose value
+// can't be parsed (e.g. the header) are skipped.
+type CSV struct {
+	sync.Mutex
+	Path   string
+	Column int
+	temps  []float32
+	next   int
+}
+
+// NewCSV reads the whole file and initializes new CSV object.
+// It returns initialized object.
+func NewCSV(path string, column int) (*CSV, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+
+	var temps []float32
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sensors: csv: %s", err)
+		}
+		if column >= len(rec) {
+			continue
+		}
+		t, err := strconv.ParseFloat(strings.TrimSpace(rec[column]), 32)
+		if err != nil {
+			continue
+		}
+		temps = append(temps, float32(t))
+	}
+
+	if len(temps) == 0 {
+		return nil, fmt.Errorf("sensors: csv: no readings in column %d of %s", column, path)
+	}
+
+	return &CSV{
+		Path:   path,
+		Column: column,
+		temps:  temps,
+	}, nil
+}
+
+// ReadTemp returns the next recorded reading.
+func (c *CSV) ReadTemp() (float32, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	t := c.temps[c.next]
+	c.next = (c.next + 1) % len(c.temps)
+	return t, nil
+}
@@ -0,0 +1,51 @@
+package sensors
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCSV(t *testing.T) {
+	tests := []struct {
+		path   string
+		column int
+		// want are the readings expected from the consecutive calls,
+		// including the ones after the replay has started over.
+		want    []float32
+		wantErr bool
+	}{
+		{path: "testdata/csv/readings.csv", column: 1, want: []float32{4.5, 4.75, 5, 4.5}},
+		{path: "testdata/csv/readings.csv", column: 2, want: []float32{-18.25, -18, -17.5, -18.25}},
+		{path: "testdata/csv/top,bottom.csv", column: 0, want: []float32{3.5, 4, 3.5}},
+		{path: "testdata/csv/readings.csv", column: 0, wantErr: true},
+		{path: "testdata/csv/readings.csv", column: 3, wantErr: true},
+		{path: "testdata/csv/header.csv", column: 1, wantErr: true},
+		{path: "testdata/csv/missing.csv", column: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		c, err := NewCSV(tt.path, tt.column)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("NewCSV(%s, %d) has succeeded, want an error", tt.path, tt.column)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewCSV(%s, %d) has failed: %s", tt.path, tt.column, err)
+			continue
+		}
+
+		got := make([]float32, 0, len(tt.want))
+		for range tt.want {
+			temp, err := c.ReadTemp()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, temp)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("readings of column %d of %s = %v, want %v", tt.column, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package sensors
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Hwmon reads temperature from a Linux sysfs/hwmon file (e.g. temp1_input)
+// that contains the value in millidegrees Celsius.
+type Hwmon struct {
+	Path string
+}
+
+// NewHwmon creates and initializes new Hwmon object.
+// It returns initialized object.
+func NewHwmon(path string) *Hwmon {
+	return &Hwmon{Path: path}
+}
+
+// ReadTemp reads the file and converts its value to degrees Celsius.
+func (h *Hwmon) ReadTemp() (float32, error) {
+	b, err := ioutil.ReadFile(h.Path)
+	if err != nil {
+		return 0, err
+	}
+
+	milli, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("sensors: hwmon: invalid reading in %s: %s", h.Path, err)
+	}
+	return float32(milli) / 1000, nil
+}
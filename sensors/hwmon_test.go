@@ -0,0 +1,28 @@
+package sensors
+
+import "testing"
+
+func TestHwmon(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    float32
+		wantErr bool
+	}{
+		{path: "testdata/hwmon/temp1_input", want: 23.125},
+		{path: "testdata/hwmon/temp2_input", want: -18.5},
+		{path: "testdata/hwmon/temp3_input", wantErr: true},
+		{path: "testdata/hwmon/temp4_input", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := NewHwmon(tt.path).ReadTemp()
+		switch {
+		case tt.wantErr && err == nil:
+			t.Errorf("ReadTemp() of %s = %v, want an error", tt.path, got)
+		case !tt.wantErr && err != nil:
+			t.Errorf("ReadTemp() of %s has failed: %s", tt.path, err)
+		case got != tt.want:
+			t.Errorf("ReadTemp() of %s = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
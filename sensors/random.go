@@ -0,0 +1,33 @@
+package sensors
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Random is a fake sensor that returns uniformly distributed readings
+// within [Min, Max).
+type Random struct {
+	sync.Mutex
+	Min float32
+	Max float32
+	rnd *rand.Rand
+}
+
+// NewRandom creates and initializes new Random object.
+// It returns initialized object.
+func NewRandom(min, max float32) *Random {
+	return &Random{
+		Min: min,
+		Max: max,
+		rnd: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// ReadTemp returns the next random reading.
+func (r *Random) ReadTemp() (float32, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.Min + r.rnd.Float32()*(r.Max-r.Min), nil
+}
@@ -0,0 +1,27 @@
+package sensors
+
+import "testing"
+
+func TestRandom(t *testing.T) {
+	tests := []struct {
+		min float32
+		max float32
+	}{
+		{min: 0, max: 10},
+		{min: -30, max: -16},
+		{min: 4, max: 4},
+	}
+
+	for _, tt := range tests {
+		r := NewRandom(tt.min, tt.max)
+		for i := 0; i < 1000; i++ {
+			got, err := r.ReadTemp()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got < tt.min || got > tt.max || (got == tt.max && tt.min != tt.max) {
+				t.Fatalf("NewRandom(%v, %v).ReadTemp() = %v, want within [%v, %v)", tt.min, tt.max, got, tt.min, tt.max)
+			}
+		}
+	}
+}
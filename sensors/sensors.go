@@ -0,0 +1,97 @@
+// Package sensors provides temperature sources that feed the data service
+// with readings of the fridge compartments.
+package sensors
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Sensor is used to read the current temperature of a compartment.
+type Sensor interface {
+	// ReadTemp returns the current temperature in degrees Celsius.
+	ReadTemp() (float32, error)
+}
+
+// Spec kinds that can be passed to Parse.
+const (
+	KindRandom = "random"
+	KindHwmon  = "hwmon"
+	KindW1     = "w1"
+	KindCSV    = "csv"
+	KindSim    = "sim"
+)
+
+// Parse creates a sensor from its textual specification of the form "kind[:args]":
+//
+//	random[:min,max]    uniformly distributed readings within [min, max)
+//	hwmon:<path>        Linux hwmon temperature file, e.g. /sys/class/hwmon/hwmon0/temp1_input
+//	w1:<path>           1-Wire DS18B20 w1_slave file, e.g. /sys/bus/w1/devices/28-0316a2795cff/w1_slave
+//	csv:<path>[,column] replay of the given (zero-based) column of a CSV file, column 0 by default
+//	sim[:key=value,...] thermal model of a compartment, see SimParams for the keys
+//
+// It returns initialized sensor.
+func Parse(spec string) (Sensor, error) {
+	kind, args := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, args = spec[:i], spec[i+1:]
+	}
+
+	switch kind {
+	case KindRandom:
+		if args == "" {
+			return NewRandom(0, 10), nil
+		}
+		bounds := strings.Split(args, ",")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("sensors: random bounds must be passed as min,max: %q", args)
+		}
+		min, err := strconv.ParseFloat(strings.TrimSpace(bounds[0]), 32)
+		if err != nil {
+			return nil, fmt.Errorf("sensors: invalid random min: %s", err)
+		}
+		max, err := strconv.ParseFloat(strings.TrimSpace(bounds[1]), 32)
+		if err != nil {
+			return nil, fmt.Errorf("sensors: invalid random max: %s", err)
+		}
+		if max < min {
+			return nil, fmt.Errorf("sensors: random max %v is less than min %v", max, min)
+		}
+		return NewRandom(float32(min), float32(max)), nil
+	case KindHwmon:
+		if args == "" {
+			return nil, fmt.Errorf("sensors: hwmon file path is missing")
+		}
+		return NewHwmon(args), nil
+	case KindW1:
+		if args == "" {
+			return nil, fmt.Errorf("sensors: w1_slave file path is missing")
+		}
+		return NewW1(args), nil
+	case KindCSV:
+		if args == "" {
+			return nil, fmt.Errorf("sensors: csv file path is missing")
+		}
+		// The suffix after the last comma is the column only if it's a number,
+		// otherwise the comma is a part of the path.
+		path, column := args, 0
+		if i := strings.LastIndex(args, ","); i >= 0 {
+			if c, err := strconv.Atoi(args[i+1:]); err == nil {
+				if c < 0 {
+					return nil, fmt.Errorf("sensors: invalid csv column: %q", args[i+1:])
+				}
+				path, column = args[:i], c
+			}
+		}
+		return NewCSV(path, column)
+	case KindSim:
+		p, err := parseSimParams(args)
+		if err != nil {
+			return nil, err
+		}
+		return NewSimulator(p), nil
+	default:
+		return nil, fmt.Errorf("sensors: unknown sensor kind: %q", kind)
+	}
+}
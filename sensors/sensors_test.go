@@ -0,0 +1,78 @@
+package sensors
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	sim := DefaultSimParams()
+	sim.Setpoint, sim.Step, sim.Seed = -3, time.Second, 42
+
+	tests := []struct {
+		spec string
+		// want is the expected sensor with the fields that are compared,
+		// nil if an error is expected.
+		want Sensor
+	}{
+		{spec: "random", want: &Random{Min: 0, Max: 10}},
+		{spec: "random:2,6", want: &Random{Min: 2, Max: 6}},
+		{spec: "random: -20 , -16 ", want: &Random{Min: -20, Max: -16}},
+		{spec: "random:6,2"},
+		{spec: "random:2"},
+		{spec: "random:a,6"},
+		{spec: "random:2,b"},
+		{spec: "hwmon:testdata/hwmon/temp1_input", want: &Hwmon{Path: "testdata/hwmon/temp1_input"}},
+		{spec: "hwmon"},
+		{spec: "w1:testdata/w1/w1_slave", want: &W1{Path: "testdata/w1/w1_slave"}},
+		{spec: "w1:"},
+		{spec: "csv:testdata/csv/readings.csv,2", want: &CSV{Path: "testdata/csv/readings.csv", Column: 2}},
+		// the suffix isn't a column, so the comma is a part of the path
+		{spec: "csv:testdata/csv/top,bottom.csv", want: &CSV{Path: "testdata/csv/top,bottom.csv", Column: 0}},
+		{spec: "csv:testdata/csv/top,bottom.csv,0", want: &CSV{Path: "testdata/csv/top,bottom.csv", Column: 0}},
+		{spec: "csv:testdata/csv/readings.csv,-1"},
+		{spec: "csv:testdata/csv/missing.csv"},
+		{spec: "csv"},
+		{spec: "sim:setpoint=-3,step=1s,seed=42", want: &Simulator{Params: sim}},
+		{spec: "sim:setpoint"},
+		{spec: "sim:setpoint=cold"},
+		{spec: "thermistor:/dev/adc0"},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.spec)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("Parse(%q) = %#v, want an error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) has failed: %s", tt.spec, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(fields(got), fields(tt.want)) {
+			t.Errorf("Parse(%q) = %#v, want %#v", tt.spec, fields(got), fields(tt.want))
+		}
+	}
+}
+
+// fields returns the configuration of the sensor without its state.
+func fields(s Sensor) interface{} {
+	switch s := s.(type) {
+	case *Random:
+		return [2]float32{s.Min, s.Max}
+	case *Hwmon:
+		return *s
+	case *W1:
+		return *s
+	case *CSV:
+		return [2]interface{}{s.Path, s.Column}
+	case *Simulator:
+		return s.Params
+	default:
+		return s
+	}
+}
@@ -0,0 +1,264 @@
+package sensors
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kostiamol/fridgems/clock"
+)
+
+// maxSimStep limits the integration step so that the model stays stable
+// even when readings are requested rarely.
+const maxSimStep = time.Second
+
+// SimParams is used to store parameters of the compartment thermal model.
+type SimParams struct {
+	// Setpoint is the target temperature the compressor thermostat keeps.
+	Setpoint float64
+	// Hysteresis is the width of the band around Setpoint: the compressor starts
+	// above Setpoint+Hysteresis/2 and stops below Setpoint-Hysteresis/2.
+	Hysteresis float64
+	// Ambient is the temperature of the room around the fridge.
+	Ambient float64
+	// Leak is the thermal time constant of the insulation: the bigger it is,
+	// the slower the compartment warms up to Ambient.
+	Leak time.Duration
+	// CoolRate is the cooling speed in degrees per minute while the compressor runs.
+	CoolRate float64
+	// DoorRate is the expected number of door openings per hour.
+	DoorRate float64
+	// DoorDuration specifies how long the door stays open.
+	DoorDuration time.Duration
+	// DoorLeak is the thermal time constant while the door is open.
+	DoorLeak time.Duration
+	// DefrostEvery is the interval between defrost cycles, 0 disables defrosting.
+	DefrostEvery time.Duration
+	// DefrostFor is the duration of a defrost cycle.
+	DefrostFor time.Duration
+	// HeatRate is the heating speed in degrees per minute during defrost.
+	HeatRate float64
+	// Noise is the standard deviation of the sensor noise.
+	Noise float64
+	// Step is the simulated time between two readings; if 0, the time of Clock
+	// elapsed since the previous reading is used.
+	Step time.Duration
+	// Clock is the time source of the readings, the real clock if nil.
+	Clock clock.Clock
+	// Seed seeds the random source, which makes runs with a fixed Step reproducible;
+	// if 0, the source is seeded from the time of Clock.
+	Seed int64
+}
+
+// DefaultSimParams returns parameters of a typical fridge compartment.
+func DefaultSimParams() SimParams {
+	return SimParams{
+		Setpoint:     4,
+		Hysteresis:   2,
+		Ambient:      22,
+		Leak:         4 * time.Hour,
+		CoolRate:     0.5,
+		DoorRate:     2,
+		DoorDuration: 20 * time.Second,
+		DoorLeak:     5 * time.Minute,
+		DefrostEvery: 8 * time.Hour,
+		DefrostFor:   20 * time.Minute,
+		HeatRate:     0.3,
+		Noise:        0.05,
+	}
+}
+
+// Simulator is a sensor that returns readings of a physically plausible
+// compartment model: temperature drifts to the ambient one with thermal
+// inertia, the compressor is switched by a thermostat with hysteresis,
+// the door is opened at random moments and the compartment is periodically
+// defrosted.
+type Simulator struct {
+	sync.Mutex
+	Params SimParams
+
+	rnd          *rand.Rand
+	temp         float64
+	compressorOn bool
+	doorLeft     time.Duration
+	sinceDefrost time.Duration
+	defrostLeft  time.Duration
+	last         time.Time
+}
+
+// NewSimulator creates and initializes new Simulator object that starts
+// at the setpoint temperature.
+// It returns initialized object.
+func NewSimulator(p SimParams) *Simulator {
+	seed := p.Seed
+	if seed == 0 {
+		seed = clock.OrReal(p.Clock).Now().UnixNano()
+	}
+
+	return &Simulator{
+		Params: p,
+		rnd:    rand.New(rand.NewSource(seed)),
+		temp:   p.Setpoint,
+	}
+}
+
+// ReadTemp advances the model and returns the current temperature.
+func (s *Simulator) ReadTemp() (float32, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	elapsed := s.Params.Step
+	if elapsed == 0 {
+		now := clock.OrReal(s.Params.Clock).Now()
+		if !s.last.IsZero() {
+			elapsed = now.Sub(s.last)
+		}
+		s.last = now
+	}
+
+	for elapsed > 0 {
+		dt := elapsed
+		if dt > maxSimStep {
+			dt = maxSimStep
+		}
+		s.step(dt)
+		elapsed -= dt
+	}
+
+	return float32(s.temp + s.rnd.NormFloat64()*s.Params.Noise), nil
+}
+
+// CompressorOn reports whether the compressor is currently running.
+func (s *Simulator) CompressorOn() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.compressorOn
+}
+
+func (s *Simulator) step(dt time.Duration) {
+	p := s.Params
+	minutes := dt.Minutes()
+
+	s.updateDefrost(dt)
+	s.updateDoor(dt)
+
+	switch {
+	case s.defrostLeft > 0:
+		s.compressorOn = false
+	case s.temp > p.Setpoint+p.Hysteresis/2:
+		s.compressorOn = true
+	case s.temp < p.Setpoint-p.Hysteresis/2:
+		s.compressorOn = false
+	}
+
+	delta := relax(s.temp, p.Ambient, dt, p.Leak)
+	if s.doorLeft > 0 {
+		delta += relax(s.temp, p.Ambient, dt, p.DoorLeak)
+	}
+	if s.compressorOn {
+		delta -= p.CoolRate * minutes
+	}
+	if s.defrostLeft > 0 {
+		delta += p.HeatRate * minutes
+	}
+	s.temp += delta
+}
+
+func (s *Simulator) updateDefrost(dt time.Duration) {
+	p := s.Params
+	if p.DefrostEvery <= 0 {
+		return
+	}
+
+	if s.defrostLeft > 0 {
+		s.defrostLeft -= dt
+		return
+	}
+
+	s.sinceDefrost += dt
+	if s.sinceDefrost >= p.DefrostEvery {
+		s.sinceDefrost = 0
+		s.defrostLeft = p.DefrostFor
+	}
+}
+
+func (s *Simulator) updateDoor(dt time.Duration) {
+	p := s.Params
+	if s.doorLeft > 0 {
+		s.doorLeft -= dt
+		return
+	}
+
+	// door openings form a Poisson process with DoorRate events per hour
+	if p.DoorRate > 0 && s.rnd.Float64() < 1-math.Exp(-p.DoorRate*dt.Hours()) {
+		s.doorLeft = p.DoorDuration
+	}
+}
+
+// relax returns the temperature change of a body at temp during dt
+// with time constant tau in the environment at ambient.
+func relax(temp, ambient float64, dt, tau time.Duration) float64 {
+	if tau <= 0 {
+		return 0
+	}
+	return (ambient - temp) * (1 - math.Exp(-dt.Seconds()/tau.Seconds()))
+}
+
+// parseSimParams applies comma-separated key=value overrides to the
+// default parameters, e.g. "setpoint=-3,ambient=25,seed=42,step=1s".
+func parseSimParams(args string) (SimParams, error) {
+	p := DefaultSimParams()
+	if args == "" {
+		return p, nil
+	}
+
+	for _, kv := range strings.Split(args, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return p, fmt.Errorf("sensors: sim: parameter must be passed as key=value: %q", kv)
+		}
+		key, val := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		var err error
+		switch key {
+		case "setpoint":
+			p.Setpoint, err = strconv.ParseFloat(val, 64)
+		case "hysteresis":
+			p.Hysteresis, err = strconv.ParseFloat(val, 64)
+		case "ambient":
+			p.Ambient, err = strconv.ParseFloat(val, 64)
+		case "leak":
+			p.Leak, err = time.ParseDuration(val)
+		case "coolrate":
+			p.CoolRate, err = strconv.ParseFloat(val, 64)
+		case "doorrate":
+			p.DoorRate, err = strconv.ParseFloat(val, 64)
+		case "doorduration":
+			p.DoorDuration, err = time.ParseDuration(val)
+		case "doorleak":
+			p.DoorLeak, err = time.ParseDuration(val)
+		case "defrostevery":
+			p.DefrostEvery, err = time.ParseDuration(val)
+		case "defrostfor":
+			p.DefrostFor, err = time.ParseDuration(val)
+		case "heatrate":
+			p.HeatRate, err = strconv.ParseFloat(val, 64)
+		case "noise":
+			p.Noise, err = strconv.ParseFloat(val, 64)
+		case "step":
+			p.Step, err = time.ParseDuration(val)
+		case "seed":
+			p.Seed, err = strconv.ParseInt(val, 10, 64)
+		default:
+			return p, fmt.Errorf("sensors: sim: unknown parameter: %q", key)
+		}
+		if err != nil {
+			return p, fmt.Errorf("sensors: sim: invalid %s: %s", key, err)
+		}
+	}
+	return p, nil
+}
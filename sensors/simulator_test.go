@@ -0,0 +1,91 @@
+package sensors
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kostiamol/fridgems/clock"
+)
+
+// simReadings is the length of the simulated series, long enough to cover
+// a few door openings and a defrost cycle.
+const simReadings = 3000
+
+// series returns the readings of a new simulator, advancing c by interval
+// before each of them if c isn't nil.
+func series(t *testing.T, p SimParams, c *clock.Fake, interval time.Duration) []float32 {
+	s := NewSimulator(p)
+	temps := make([]float32, 0, simReadings)
+	for i := 0; i < simReadings; i++ {
+		if c != nil {
+			c.Advance(interval)
+		}
+		temp, err := s.ReadTemp()
+		if err != nil {
+			t.Fatal(err)
+		}
+		temps = append(temps, temp)
+	}
+	return temps
+}
+
+func TestSimulatorReproducible(t *testing.T) {
+	start := time.Unix(1543846800, 0)
+
+	tests := []struct {
+		name string
+		seed int64
+		step time.Duration
+		// interval is how much the clock is advanced before each reading,
+		// the clock is used only if Step is 0.
+		interval time.Duration
+	}{
+		{name: "fixed step", seed: 42, step: time.Second * 10},
+		{name: "another seed", seed: -7, step: time.Minute},
+		{name: "clock step", seed: 42, interval: time.Second * 10},
+		{name: "seed from clock", seed: 0, step: time.Second * 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := DefaultSimParams()
+			p.Seed, p.Step = tt.seed, tt.step
+
+			var runs [2][]float32
+			for i := range runs {
+				c := clock.NewFake(start)
+				p.Clock = c
+				runs[i] = series(t, p, c, tt.interval)
+			}
+			if !reflect.DeepEqual(runs[0], runs[1]) {
+				t.Fatal("runs with the same seed have produced different series")
+			}
+
+			// a different seed must produce a different series; a zero seed
+			// is changed by starting the clock later
+			c := clock.NewFake(start.Add(time.Nanosecond))
+			p.Clock = c
+			if p.Seed != 0 {
+				p.Seed++
+			}
+			if reflect.DeepEqual(runs[0], series(t, p, c, tt.interval)) {
+				t.Error("runs with different seeds have produced the same series")
+			}
+		})
+	}
+}
+
+func TestSimulatorSeedFromClock(t *testing.T) {
+	start := time.Unix(1543846800, 0)
+
+	p := DefaultSimParams()
+	p.Step = time.Second * 10
+	p.Clock = clock.NewFake(start)
+	got := series(t, p, nil, 0)
+
+	p.Seed = start.UnixNano()
+	if want := series(t, p, nil, 0); !reflect.DeepEqual(got, want) {
+		t.Error("Seed 0 hasn't seeded the simulator from the time of its clock")
+	}
+}
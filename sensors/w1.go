@@ -0,0 +1,54 @@
+package sensors
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// W1 reads temperature from a 1-Wire DS18B20 w1_slave file which has
+// the following format:
+//
+//	72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+//	72 01 4b 46 7f ff 0e 10 57 t=23125
+type W1 struct {
+	Path string
+}
+
+// NewW1 creates and initializes new W1 object.
+// It returns initialized object.
+func NewW1(path string) *W1 {
+	return &W1{Path: path}
+}
+
+// ReadTemp reads the file, checks CRC status and converts the value
+// to degrees Celsius.
+func (w *W1) ReadTemp() (float32, error) {
+	b, err := ioutil.ReadFile(w.Path)
+	if err != nil {
+		return 0, err
+	}
+	return parseW1Slave(string(b))
+}
+
+func parseW1Slave(s string) (float32, error) {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) < 2 {
+		return 0, fmt.Errorf("sensors: w1: unexpected w1_slave format")
+	}
+
+	if !strings.HasSuffix(strings.TrimSpace(lines[0]), "YES") {
+		return 0, fmt.Errorf("sensors: w1: CRC check has failed")
+	}
+
+	i := strings.Index(lines[1], "t=")
+	if i < 0 {
+		return 0, fmt.Errorf("sensors: w1: temperature is missing")
+	}
+	milli, err := strconv.ParseInt(strings.TrimSpace(lines[1][i+2:]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("sensors: w1: invalid temperature: %s", err)
+	}
+	return float32(milli) / 1000, nil
+}
@@ -0,0 +1,62 @@
+package sensors
+
+import "testing"
+
+func TestW1(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    float32
+		wantErr bool
+	}{
+		{path: "testdata/w1/w1_slave", want: 23.125},
+		{path: "testdata/w1/w1_slave_negative", want: -17.062},
+		{path: "testdata/w1/w1_slave_crc_failed", wantErr: true},
+		{path: "testdata/w1/w1_slave_no_temp", wantErr: true},
+		{path: "testdata/w1/w1_slave_truncated", wantErr: true},
+		{path: "testdata/w1/w1_slave_missing", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := NewW1(tt.path).ReadTemp()
+		switch {
+		case tt.wantErr && err == nil:
+			t.Errorf("ReadTemp() of %s = %v, want an error", tt.path, got)
+		case !tt.wantErr && err != nil:
+			t.Errorf("ReadTemp() of %s has failed: %s", tt.path, err)
+		case got != tt.want:
+			t.Errorf("ReadTemp() of %s = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseW1Slave(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    float32
+		wantErr bool
+	}{
+		{name: "valid", s: "72 01 : crc=57 YES\n72 01 t=23125\n", want: 23.125},
+		{name: "CRLF", s: "72 01 : crc=57 YES\r\n72 01 t=23125\r\n", want: 23.125},
+		{name: "zero", s: ": crc=00 YES\nt=0", want: 0},
+		{name: "CRC failed", s: "72 01 : crc=57 NO\n72 01 t=23125\n", wantErr: true},
+		{name: "CRC status missing", s: "72 01 : crc=57\n72 01 t=23125\n", wantErr: true},
+		{name: "t= missing", s: "72 01 : crc=57 YES\n72 01 23125\n", wantErr: true},
+		{name: "invalid t=", s: "72 01 : crc=57 YES\n72 01 t=23.1\n", wantErr: true},
+		{name: "empty t=", s: "72 01 : crc=57 YES\n72 01 t=\n", wantErr: true},
+		{name: "single line", s: "72 01 : crc=57 YES\n", wantErr: true},
+		{name: "empty", s: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseW1Slave(tt.s)
+		switch {
+		case tt.wantErr && err == nil:
+			t.Errorf("%s: parseW1Slave() = %v, want an error", tt.name, got)
+		case !tt.wantErr && err != nil:
+			t.Errorf("%s: parseW1Slave() has failed: %s", tt.name, err)
+		case got != tt.want:
+			t.Errorf("%s: parseW1Slave() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
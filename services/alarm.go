@@ -0,0 +1,313 @@
+package services
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/golang/protobuf/proto"
+	"github.com/kostiamol/fridgems/alarm"
+	"github.com/kostiamol/fridgems/api/pb"
+	"github.com/kostiamol/fridgems/clock"
+	"github.com/kostiamol/fridgems/entities"
+	"github.com/kostiamol/fridgems/supervisor"
+	"github.com/nats-io/go-nats"
+	"golang.org/x/net/context"
+)
+
+const (
+	// alarmAckTimeout limits the time the center has to ack an alarm event.
+	alarmAckTimeout = time.Second * 5
+	// maxPendingAlarms limits the number of the alarm events retained until
+	// the center acks them, the oldest ones are dropped beyond it.
+	maxPendingAlarms = 1000
+)
+
+// Types of the alarm events published by the device.
+const (
+	alarmRaisedEvent  = "AlarmRaised"
+	alarmClearedEvent = "AlarmCleared"
+)
+
+// alarmState is used to persist the alarm events pending delivery together
+// with the events that have raised the active alarms.
+type alarmState struct {
+	Pending []alarm.Event
+	Active  []alarm.Event
+}
+
+// AlarmService is used to evaluate the alarm rules against the readings of
+// the compartments and to deliver the alarm events to the center. The events
+// are retained in the file at Path until the center has acked them, but no
+// more than maxPendingAlarms. The active alarms are kept in the same file,
+// so they survive a restart. The file is written by a separate worker, so
+// that the collector evaluating the readings isn't blocked by the writes.
+// The delivery is retried every RetryInterval of Clock.
+type AlarmService struct {
+	Engine        *alarm.Engine
+	Meta          *entities.DevMeta
+	Path          string
+	Conn          func() *nats.Conn
+	Log           *logrus.Logger
+	RetryInterval time.Duration
+	Clock         clock.Clock
+	mu            sync.Mutex
+	pending       []alarm.Event
+	notify        chan struct{}
+	changed       chan struct{}
+	saverStopped  bool
+	saveMu        sync.Mutex
+}
+
+// NewAlarmService creates and initializes new AlarmService object and loads
+// the events that haven't been delivered and the alarms that have been
+// active before the restart from path.
+// conn returns the current connection to NATS or nil if there is none.
+// It returns initialized object.
+func NewAlarmService(rules []alarm.Rule, m *entities.DevMeta, path string, conn func() *nats.Conn,
+	l *logrus.Logger, r time.Duration) (*AlarmService, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	s := &AlarmService{
+		Engine:        alarm.NewEngine(rules),
+		Meta:          m,
+		Path:          path,
+		Conn:          conn,
+		Log:           l,
+		RetryInterval: r,
+		Clock:         clock.Real,
+		notify:        make(chan struct{}, 1),
+		changed:       make(chan struct{}, 1),
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		var state alarmState
+		if err := json.Unmarshal(b, &state); err != nil {
+			return nil, err
+		}
+		s.pending = state.Pending
+		s.trim()
+		s.Engine.Restore(state.Active)
+	}
+	return s, nil
+}
+
+// Run starts the workers that deliver and persist the alarm events under sup.
+func (s *AlarmService) Run(sup *supervisor.Supervisor) {
+	sup.Go("alarmSender", s.sendAlarms)
+	sup.Go("alarmSaver", s.saveAlarms)
+}
+
+// Observe evaluates the rules against the reading and retains the alarm
+// events it has produced until they are delivered.
+func (s *AlarmService) Observe(d FridgeDatum) {
+	s.mu.Lock()
+	// evaluated under mu, so the persisted active alarms match the pending events
+	events := s.Engine.Evaluate(d.Compart, time.Unix(0, d.Time*int64(time.Millisecond)), d.Temp)
+	if len(events) == 0 {
+		s.mu.Unlock()
+		return
+	}
+
+	for _, e := range events {
+		e.ID = newEventID(s.Clock)
+		s.Log.Warnf("alarm %s of %s has been %s: %.2f %s %.2f",
+			e.Rule, e.Compart, e.State, e.Temp, e.Cond, e.Threshold)
+		alarmsTotal.Inc(string(e.State))
+		s.pending = append(s.pending, e)
+	}
+	s.trim()
+	s.mu.Unlock()
+
+	s.markChanged()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Active returns the alarms that are currently raised.
+func (s *AlarmService) Active() []alarm.Event {
+	return s.Engine.Active()
+}
+
+// Pending returns the alarm events that haven't been acked by the center yet.
+func (s *AlarmService) Pending() []alarm.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending := make([]alarm.Event, len(s.pending))
+	copy(pending, s.pending)
+	return pending
+}
+
+func (s *AlarmService) sendAlarms(ctx context.Context) error {
+	ticker := s.Clock.NewTicker(s.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.notify:
+		case <-ticker.C():
+		case <-ctx.Done():
+			s.Log.Info("alarm sending has stopped")
+			return nil
+		}
+		s.deliver(ctx)
+	}
+}
+
+// deliver publishes the pending events in order to Alarm.<MAC> and removes
+// each of them once the center has acked it. It stops at the first failure.
+func (s *AlarmService) deliver(ctx context.Context) {
+	conn := s.Conn()
+	if conn == nil || !conn.IsConnected() {
+		return
+	}
+
+	for _, e := range s.Pending() {
+		b, err := proto.Marshal(s.newAlarmEvent(e))
+		if err != nil {
+			s.Log.Errorf("AlarmService: deliver(): Marshal() has failed: %s", err)
+			return
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, alarmAckTimeout)
+		msg, err := conn.RequestWithContext(reqCtx, "Alarm."+s.Meta.MAC, b)
+		cancel()
+		if err != nil {
+			s.Log.Errorf("AlarmService: deliver(): alarm %s hasn't been acked: %s", e.ID, err)
+			return
+		}
+
+		var ack api.AlarmAck
+		if err := proto.Unmarshal(msg.Data, &ack); err != nil || ack.EventId != e.ID {
+			s.Log.Errorf("AlarmService: deliver(): invalid ack of alarm %s", e.ID)
+			return
+		}
+		s.remove(e.ID)
+	}
+}
+
+func (s *AlarmService) newAlarmEvent(e alarm.Event) *api.EventStore {
+	eventType := alarmRaisedEvent
+	if e.State == alarm.Cleared {
+		eventType = alarmClearedEvent
+	}
+
+	return &api.EventStore{
+		AggregateId:   s.Meta.MAC,
+		AggregateType: s.Meta.Type,
+		EventId:       e.ID,
+		EventType:     eventType,
+		Alarm: &api.Alarm{
+			Rule:        e.Rule,
+			Compartment: e.Compart,
+			State:       string(e.State),
+			Condition:   string(e.Cond),
+			Threshold:   e.Threshold,
+			Temp:        e.Temp,
+			Time:        e.Time,
+		},
+	}
+}
+
+func (s *AlarmService) remove(id string) {
+	s.mu.Lock()
+	for i, e := range s.pending {
+		if e.ID == id {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	s.markChanged()
+}
+
+// trim drops the oldest pending events beyond maxPendingAlarms, mu must be held.
+func (s *AlarmService) trim() {
+	n := len(s.pending) - maxPendingAlarms
+	if n <= 0 {
+		return
+	}
+	for _, e := range s.pending[:n] {
+		alarmsDroppedTotal.Inc()
+		s.Log.Warnf("AlarmService: trim(): too many alarm events are pending, alarm %s has been dropped", e.ID)
+	}
+	s.pending = append(s.pending[:0], s.pending[n:]...)
+}
+
+// saveAlarms persists the alarms whenever they have changed. On stop, it
+// persists the last changes and leaves the following ones, e.g. of the final
+// readings, to be persisted by the callers of markChanged.
+func (s *AlarmService) saveAlarms(ctx context.Context) error {
+	for {
+		select {
+		case <-s.changed:
+			s.save()
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.saverStopped = true
+			s.mu.Unlock()
+
+			select {
+			case <-s.changed:
+				s.save()
+			default:
+			}
+			s.Log.Info("alarm saving has stopped")
+			return nil
+		}
+	}
+}
+
+// markChanged hands the persisting of the changed alarms over to the saver,
+// or persists them right away once the saver has stopped.
+func (s *AlarmService) markChanged() {
+	s.mu.Lock()
+	stopped := s.saverStopped
+	s.mu.Unlock()
+
+	if stopped {
+		s.save()
+		return
+	}
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// save persists the pending events and the active alarms. The writes are
+// serialized by saveMu, so the state taken last is the one written last.
+func (s *AlarmService) save() {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	state := alarmState{
+		Pending: append([]alarm.Event(nil), s.pending...),
+		Active:  s.Engine.Active(),
+	}
+	s.mu.Unlock()
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		s.Log.Errorf("AlarmService: save(): Marshal() has failed: %s", err)
+		return
+	}
+	if err := writeFileAtomic(s.Path, b); err != nil {
+		s.Log.Errorf("AlarmService: save(): writeFileAtomic() has failed: %s", err)
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/alarm"
+	"github.com/kostiamol/fridgems/entities"
+	"github.com/nats-io/go-nats"
+)
+
+// alarmTest is used to observe the readings by AlarmService whose state is
+// persisted in a temporary directory.
+type alarmTest struct {
+	t    *testing.T
+	path string
+	time int64
+}
+
+func newAlarmTest(t *testing.T) *alarmTest {
+	dir, err := ioutil.TempDir("", "alarm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &alarmTest{t: t, path: filepath.Join(dir, "alarms.json"), time: 1543846800000}
+}
+
+func (at *alarmTest) cleanup() {
+	os.RemoveAll(filepath.Dir(at.path))
+}
+
+// newService creates the service the way it's created on the start of the device.
+// Its saver isn't started.
+func (at *alarmTest) newService() *AlarmService {
+	l := logrus.New()
+	l.Out = ioutil.Discard
+
+	rules := []alarm.Rule{{Name: "TopWarm", Compart: "TopCompart", Cond: alarm.Above, Threshold: 8}}
+	meta := &entities.DevMeta{Type: "fridge", Name: "fridge-test", MAC: "0A-1B-2C-3D-4E-5F"}
+	s, err := NewAlarmService(rules, meta, at.path, func() *nats.Conn { return nil }, l, time.Second)
+	if err != nil {
+		at.t.Fatal(err)
+	}
+	return s
+}
+
+// startSaver starts the saver of the service. It returns the function that
+// stops the saver and waits for it to return.
+func (at *alarmTest) startSaver(s *AlarmService) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.saveAlarms(ctx)
+		close(done)
+	}()
+
+	return func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(waitTimeout):
+			at.t.Fatal("saver hasn't stopped")
+		}
+	}
+}
+
+// observe observes the reading of TopCompart a minute after the previous one
+// and returns the states of the events that are pending after it.
+func (at *alarmTest) observe(s *AlarmService, temp float32) []alarm.State {
+	at.time += int64(time.Minute / time.Millisecond)
+	s.Observe(FridgeDatum{Compart: "TopCompart", Sample: Sample{Time: at.time, Temp: temp, Quality: QualityGood}})
+
+	var states []alarm.State
+	for _, e := range s.Pending() {
+		states = append(states, e.State)
+	}
+	return states
+}
+
+func TestActiveAlarmsSurviveRestart(t *testing.T) {
+	at := newAlarmTest(t)
+	defer at.cleanup()
+
+	s := at.newService()
+	stop := at.startSaver(s)
+	if got := at.observe(s, 9); len(got) != 1 || got[0] != alarm.Raised {
+		t.Fatalf("pending events = %v, want the alarm raised", got)
+	}
+	stop()
+
+	s = at.newService()
+	stop = at.startSaver(s)
+	if n := len(s.Active()); n != 1 {
+		t.Fatalf("%d alarm(s) are active after the restart, want 1", n)
+	}
+	// the alarm that is still active isn't raised again
+	if got := at.observe(s, 9); len(got) != 1 {
+		t.Fatalf("pending events after the restart = %v, want only the raised one", got)
+	}
+	stop()
+	// the changes made after the saver has stopped are persisted right away
+	if got := at.observe(s, 7); len(got) != 2 || got[1] != alarm.Cleared {
+		t.Fatalf("pending events = %v, want the alarm cleared", got)
+	}
+
+	s = at.newService()
+	if n := len(s.Active()); n != 0 {
+		t.Errorf("%d alarm(s) are active after the alarm has been cleared and the restart, want none", n)
+	}
+	if n := len(s.Pending()); n != 2 {
+		t.Errorf("%d event(s) are pending after the restart, want 2", n)
+	}
+}
+
+func TestObserveDoesntWrite(t *testing.T) {
+	at := newAlarmTest(t)
+	defer at.cleanup()
+
+	s := at.newService()
+	if got := at.observe(s, 9); len(got) != 1 {
+		t.Fatalf("pending events = %v, want the alarm raised", got)
+	}
+	if _, err := os.Stat(at.path); !os.IsNotExist(err) {
+		t.Fatalf("alarms have been written by Observe(): %v", err)
+	}
+
+	// the saver persists the changes made before its start
+	at.startSaver(s)()
+	if n := len(at.newService().Pending()); n != 1 {
+		t.Errorf("%d event(s) have been persisted by the saver, want 1", n)
+	}
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/api/pb"
+	"github.com/kostiamol/fridgems/breaker"
+	"github.com/kostiamol/fridgems/clock"
+	"github.com/kostiamol/fridgems/entities"
+	"github.com/kostiamol/fridgems/retry"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// minRetryInterval is the first delay between retries, the following
+	// ones grow exponentially up to RetryInterval.
+	minRetryInterval = time.Millisecond * 500
+	// breakerThreshold is the number of failed calls in a row that open
+	// the circuit breaker of the center.
+	breakerThreshold = 5
+)
+
+var errNotReady = errors.New("center connection isn't ready")
+
+// centerClient is used to call the center through a circuit breaker, so
+// that a center that keeps failing isn't called until it has cooled down.
+type centerClient struct {
+	api.CenterServiceClient
+	Breaker *breaker.Breaker
+}
+
+func newCenterClient(conn *grpc.ClientConn, b *breaker.Breaker) *centerClient {
+	return &centerClient{
+		CenterServiceClient: api.NewCenterServiceClient(conn),
+		Breaker:             b,
+	}
+}
+
+func (c *centerClient) SetDevInitConfig(ctx context.Context, in *api.SetDevInitConfigRequest,
+	opts ...grpc.CallOption) (*api.SetDevInitConfigResponse, error) {
+	if err := c.Breaker.Allow(); err != nil {
+		return nil, err
+	}
+	resp, err := c.CenterServiceClient.SetDevInitConfig(ctx, in, opts...)
+	reportCenterCall(c.Breaker, err)
+	return resp, err
+}
+
+func (c *centerClient) SaveDevData(ctx context.Context, in *api.SaveDevDataRequest,
+	opts ...grpc.CallOption) (*api.SaveDevDataResponse, error) {
+	if err := c.Breaker.Allow(); err != nil {
+		return nil, err
+	}
+	resp, err := c.CenterServiceClient.SaveDevData(ctx, in, opts...)
+	reportCenterCall(c.Breaker, err)
+	return resp, err
+}
+
+// StreamDevData guards opening of the stream only, the failures of the
+// opened stream are reported by its user.
+func (c *centerClient) StreamDevData(ctx context.Context,
+	opts ...grpc.CallOption) (api.CenterService_StreamDevDataClient, error) {
+	if err := c.Breaker.Allow(); err != nil {
+		return nil, err
+	}
+	st, err := c.CenterServiceClient.StreamDevData(ctx, opts...)
+	reportCenterCall(c.Breaker, err)
+	return st, err
+}
+
+// reportCenterCall reports the result of the call to the breaker. Only the
+// errors meaning that the center is unavailable or overloaded are failures,
+// cancelled calls aren't reported at all.
+func reportCenterCall(b *breaker.Breaker, err error) {
+	switch status.Code(err) {
+	case codes.OK:
+		b.Success()
+	case codes.Canceled:
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		b.Failure()
+	default:
+		b.Success()
+	}
+}
+
+// newCenterBreaker creates the circuit breaker of the center service with
+// the given name that stays open for cooldown of c and logs its transitions.
+func newCenterBreaker(name string, c clock.Clock, cooldown time.Duration, l *logrus.Logger) *breaker.Breaker {
+	b := breaker.New(breakerThreshold, cooldown)
+	b.Clock = c
+	b.OnStateChange = func(from, to breaker.State) {
+		if to == breaker.Open {
+			l.Warnf("circuit breaker of the %s center is open for %s", name, cooldown)
+			return
+		}
+		l.Infof("circuit breaker of the %s center is %s", name, to)
+	}
+	return b
+}
+
+func newBackoff(c clock.Clock, max time.Duration) *retry.Backoff {
+	b := retry.NewBackoff(minRetryInterval, max)
+	b.Clock = c
+	return b
+}
+
+// waitForReady waits with backoff until the connection to the center is
+// ready. It returns an error once ctx is done or b has run out of MaxElapsed.
+func waitForReady(ctx context.Context, conn *grpc.ClientConn, b *retry.Backoff, l *logrus.Logger,
+	caller string) error {
+	return retry.Do(ctx, b, func() error {
+		if conn.GetState() == connectivity.Ready {
+			return nil
+		}
+		l.Errorf("%s: center connectivity status: NOT READY", caller)
+		return errNotReady
+	})
+}
+
+func dial(ctx context.Context, s entities.Server, l *logrus.Logger, b *retry.Backoff) (*grpc.ClientConn, error) {
+	creds := grpc.WithInsecure()
+	if s.TLS != nil {
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(s.TLS))
+	}
+
+	var conn *grpc.ClientConn
+	err := retry.Do(ctx, b, func() error {
+		var err error
+		if conn, err = grpc.Dial(s.Host+":"+s.Port, creds); err != nil {
+			l.Errorf("dial(): grpc.Dial() has failed: %s", err)
+		}
+		return err
+	})
+	return conn, err
+}
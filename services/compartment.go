@@ -0,0 +1,110 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kostiamol/fridgems/sensors"
+)
+
+// Units of compartment readings.
+const (
+	UnitCelsius    = "C"
+	UnitFahrenheit = "F"
+	UnitKelvin     = "K"
+)
+
+// Compartment is used to describe a fridge compartment: its name, the sensor
+// it's read from, units of the readings and bounds outside of which readings
+// are considered to be sensor faults.
+type Compartment struct {
+	Name   string
+	Unit   string
+	Min    float32
+	Max    float32
+	Sensor sensors.Sensor
+}
+
+// ParseCompartment creates a compartment from its textual specification
+// of the form "name:unit:min:max:sensor", where sensor is a sensor specification
+// accepted by sensors.Parse, e.g. "TopCompart:C:-10:20:random:0,10".
+// It returns initialized compartment.
+func ParseCompartment(spec string) (Compartment, error) {
+	parts := strings.SplitN(spec, ":", 5)
+	if len(parts) != 5 {
+		return Compartment{}, fmt.Errorf("compartment must be specified as name:unit:min:max:sensor: %q", spec)
+	}
+
+	c := Compartment{
+		Name: strings.TrimSpace(parts[0]),
+		Unit: strings.ToUpper(strings.TrimSpace(parts[1])),
+	}
+	if c.Name == "" {
+		return Compartment{}, fmt.Errorf("compartment name is missing: %q", spec)
+	}
+
+	switch c.Unit {
+	case UnitCelsius, UnitFahrenheit, UnitKelvin:
+	default:
+		return Compartment{}, fmt.Errorf("compartment %s: unknown unit: %q", c.Name, parts[1])
+	}
+
+	min, err := strconv.ParseFloat(strings.TrimSpace(parts[2]), 32)
+	if err != nil {
+		return Compartment{}, fmt.Errorf("compartment %s: invalid min: %s", c.Name, err)
+	}
+	max, err := strconv.ParseFloat(strings.TrimSpace(parts[3]), 32)
+	if err != nil {
+		return Compartment{}, fmt.Errorf("compartment %s: invalid max: %s", c.Name, err)
+	}
+	if max < min {
+		return Compartment{}, fmt.Errorf("compartment %s: max %v is less than min %v", c.Name, max, min)
+	}
+	c.Min, c.Max = float32(min), float32(max)
+
+	if c.Sensor, err = sensors.Parse(parts[4]); err != nil {
+		return Compartment{}, fmt.Errorf("compartment %s: %s", c.Name, err)
+	}
+	return c, nil
+}
+
+// OutOfBoundsError is returned by ReadTemp if the reading is out of
+// the compartment's bounds.
+type OutOfBoundsError struct {
+	Temp float32
+	Unit string
+	Min  float32
+	Max  float32
+}
+
+func (e *OutOfBoundsError) Error() string {
+	return fmt.Sprintf("reading %v%s is out of bounds [%v, %v]", e.Temp, e.Unit, e.Min, e.Max)
+}
+
+// ReadTemp reads the sensor and converts the reading to the compartment's units.
+// It returns the reading together with *OutOfBoundsError if the reading is out
+// of the compartment's bounds.
+func (c *Compartment) ReadTemp() (float32, error) {
+	celsius, err := c.Sensor.ReadTemp()
+	if err != nil {
+		return 0, err
+	}
+
+	temp := fromCelsius(celsius, c.Unit)
+	if temp < c.Min || temp > c.Max {
+		return temp, &OutOfBoundsError{Temp: temp, Unit: c.Unit, Min: c.Min, Max: c.Max}
+	}
+	return temp, nil
+}
+
+func fromCelsius(t float32, unit string) float32 {
+	switch unit {
+	case UnitFahrenheit:
+		return t*9/5 + 32
+	case UnitKelvin:
+		return t + 273.15
+	default:
+		return t
+	}
+}
@@ -1,33 +1,53 @@
-// Package services provides two services for device configuration
-// and data handling.
+// Package services provides the services of the device: the configuration,
+// the collection and the delivery of the data, the alarms and the
+// provisioning, together with the payload formats they exchange with the center.
 package services
 
 import (
 	"encoding/json"
-	"math/rand"
 	"sync"
 
 	"bytes"
-	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/kostiamol/fridgems/api/pb"
+	"github.com/kostiamol/fridgems/breaker"
+	"github.com/kostiamol/fridgems/clock"
+	"github.com/kostiamol/fridgems/downsample"
 	"github.com/kostiamol/fridgems/entities"
-	"github.com/golang/protobuf/proto"
+	"github.com/kostiamol/fridgems/retry"
+	"github.com/kostiamol/fridgems/supervisor"
 	"github.com/nats-io/go-nats"
 	"golang.org/x/net/context"
-	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc"
 )
 
 // FridgeConfig is used to store fridge configuration.
 // TurnedOn    specifies whether device is on or off.
 // CollectFreq specifies frequency for data collection on device.
 // SendFreq    specifies frequency for sending data from device to center.
+// Downsample, DownsampleWindow and DownsampleDeviation specify how readings
+// are reduced before sending, see downsample.Settings.
 type FridgeConfig struct {
-	TurnedOn    bool
-	CollectFreq int64
-	SendFreq    int64
+	TurnedOn            bool
+	CollectFreq         int64
+	SendFreq            int64
+	Downsample          downsample.Mode
+	DownsampleWindow    int64
+	DownsampleDeviation float32
+}
+
+// DownsampleSettings returns the settings of downsampling.
+func (c FridgeConfig) DownsampleSettings() downsample.Settings {
+	return downsample.Settings{
+		Mode:      c.Downsample,
+		Window:    c.DownsampleWindow,
+		Deviation: c.DownsampleDeviation,
+	}
 }
 
 // Configuration is used to store fridge's configuration and to
@@ -35,10 +55,15 @@ type FridgeConfig struct {
 type Configuration struct {
 	sync.RWMutex
 	FridgeConfig
+	// PayloadFormat is the format of the fridge data negotiated with the center.
+	PayloadFormat api.PayloadFormat
+	// Version is the revision of the config set by the center.
+	Version  uint64
 	SubsPool map[string]chan struct{}
 }
 
-// Subscribe subscribes clients to configuration patches.
+// Subscribe subscribes clients to configuration patches. Notifications
+// are never blocked on subscribers, so ch should be buffered to not miss them.
 func (c *Configuration) Subscribe(subscriber string, ch chan struct{}) {
 	c.RWMutex.Lock()
 	c.SubsPool[subscriber] = ch
@@ -50,7 +75,10 @@ func (c *Configuration) Subscribe(subscriber string, ch chan struct{}) {
 func (c *Configuration) publishConfigIsPatched() {
 	c.RWMutex.RLock()
 	for _, v := range c.SubsPool {
-		v <- struct{}{}
+		select {
+		case v <- struct{}{}:
+		default:
+		}
 	}
 	c.RWMutex.RUnlock()
 }
@@ -111,109 +139,374 @@ func (c *Configuration) SetSendFreq(sendFreq int64) {
 	c.RWMutex.Unlock()
 }
 
+// GetPayloadFormat returns value of PayloadFormat field.
+func (c *Configuration) GetPayloadFormat() api.PayloadFormat {
+	c.RWMutex.RLock()
+	defer c.RWMutex.RUnlock()
+	return c.PayloadFormat
+}
+
+// SetPayloadFormat sets value for PayloadFormat field.
+func (c *Configuration) SetPayloadFormat(f api.PayloadFormat) {
+	c.RWMutex.Lock()
+	c.PayloadFormat = f
+	c.RWMutex.Unlock()
+}
+
+// GetVersion returns value of Version field.
+func (c *Configuration) GetVersion() uint64 {
+	c.RWMutex.RLock()
+	defer c.RWMutex.RUnlock()
+	return c.Version
+}
+
+// SetVersion sets value for Version field.
+func (c *Configuration) SetVersion(version uint64) {
+	c.RWMutex.Lock()
+	c.Version = version
+	c.RWMutex.Unlock()
+}
+
 // ConfigService is used to handle device's configuration parameters
-// manipulation.
+// manipulation. If SharedCenter or SharedNATS is set, the service uses
+// that connection owned by the caller instead of opening its own one.
+// The tickers, retries and timestamps of the service are driven by Clock,
+// the cooldown of Breaker by the Clock of the breaker.
 type ConfigService struct {
 	Config        *Configuration
 	Center        entities.Server
-	Controller    *entities.ServiceController
 	Meta          *entities.DevMeta
 	Log           *logrus.Logger
 	RetryInterval time.Duration
+	StateInterval time.Duration
+	NATS          NATSConfig
+	Store         *ConfigStore
+	Breaker       *breaker.Breaker
+	SharedCenter  *grpc.ClientConn
+	SharedNATS    *nats.Conn
+	Clock         clock.Clock
+	natsMu        sync.RWMutex
+	natsConn      *nats.Conn
+	natsSub       *nats.Subscription
+	patchMu       sync.Mutex
+	seenEvents    *seenEvents
 }
 
 // NewConfigService creates and initializes new ConfigService object.
 // It returns initialized object.
-func NewConfigService(m *entities.DevMeta, s entities.Server, ctrl *entities.ServiceController,
-	l *logrus.Logger, r time.Duration) *ConfigService {
+func NewConfigService(m *entities.DevMeta, s entities.Server, n NATSConfig, st *ConfigStore,
+	l *logrus.Logger, r, p time.Duration) *ConfigService {
 	return &ConfigService{
 		Meta: m,
 		Config: &Configuration{
 			SubsPool: make(map[string]chan struct{}),
 		},
 		Center:        s,
-		Controller:    ctrl,
+		NATS:          n,
+		Store:         st,
 		Log:           l,
 		RetryInterval: r,
+		StateInterval: p,
+		Breaker:       newCenterBreaker("config", clock.Real, r, l),
+		Clock:         clock.Real,
+		seenEvents:    newSeenEvents(seenEventsLimit),
 	}
 }
 
-// Run sets initial device configuration and listens
-// for configuration patches from the center.
-func (s *ConfigService) Run() {
-	s.setInitConfig()
-	go s.listenConfigPatches()
+// Run applies the cached configuration, or DefaultFridgeConfig if nothing
+// has been cached yet, and starts the workers that reconcile it with the
+// center, listen for configuration patches and publish the reported state under sup.
+func (s *ConfigService) Run(sup *supervisor.Supervisor) {
+	s.loadConfig()
+	sup.Go("initConfig", s.setInitConfig)
+	sup.Go("configPatchListener", s.listenConfigPatches)
+	sup.Go("statePublisher", s.publishReportedState)
 }
 
-func (s *ConfigService) setInitConfig() {
+// loadConfig makes the cached config current, so the fridge can run
+// while the center is unreachable.
+func (s *ConfigService) loadConfig() {
+	config, version := DefaultFridgeConfig, uint64(0)
+	if s.Store != nil {
+		c, v, err := s.Store.Load()
+		switch {
+		case os.IsNotExist(err):
+			s.Log.Info("no cached config has been found, the default one is used")
+		case err != nil:
+			s.Log.Errorf("ConfigService: loadConfig(): Load() has failed, the default config is used: %s", err)
+		case validateConfig(c) != nil:
+			s.Log.Errorf("ConfigService: loadConfig(): cached config is invalid, the default one is used: %s",
+				validateConfig(c))
+		default:
+			config, version = c, v
+		}
+	}
+
+	if config.TurnedOn {
+		s.Log.Info("fridge is running")
+	}
+	s.Config.SetFridgeConfig(config)
+	s.Config.SetVersion(version)
+	s.Log.Infof("current config: %+v, version: %d", config, version)
+}
+
+func (s *ConfigService) setInitConfig(ctx context.Context) error {
 	req := &api.SetDevInitConfigRequest{
-		Time: time.Now().UnixNano(),
+		Time: s.Clock.Now().UnixNano(),
 		Meta: &api.DevMeta{
 			Type: s.Meta.Type,
 			Name: s.Meta.Name,
 			Mac:  s.Meta.MAC,
+			Id:   s.Meta.ID,
 		},
+		PayloadFormats: supportedPayloadFormats,
 	}
 
-	conn := dial(s.Center, s.Log, s.RetryInterval)
-	defer conn.Close()
+	conn := s.SharedCenter
+	if conn == nil {
+		var err error
+		if conn, err = dial(ctx, s.Center, s.Log, newBackoff(s.Clock, s.RetryInterval)); err != nil {
+			return err
+		}
+		defer conn.Close()
+	}
 
-	client := api.NewCenterServiceClient(conn)
-	for conn.GetState() != connectivity.Ready {
-		s.Log.Error("ConfigService: setInitConfig(): center connectivity status: NOT READY")
-		duration := time.Duration(rand.Intn(int(s.RetryInterval.Seconds())))
-		time.Sleep(time.Second*duration + 1)
+	if err := waitForReady(ctx, conn, newBackoff(s.Clock, s.RetryInterval), s.Log, "ConfigService: setInitConfig()"); err != nil {
+		return err
 	}
 
-	resp, err := client.SetDevInitConfig(context.Background(), req)
+	resp, err := newCenterClient(conn, s.Breaker).SetDevInitConfig(ctx, req)
 	if err != nil {
 		s.Log.Error("ConfigService: setInitConfig(): SetDevInitConfig() has failed: ", err)
-		panic("init config hasn't been received")
+		return fmt.Errorf("init config hasn't been received: %s", err)
 	}
 
-	buf := &bytes.Buffer{}
-	if err := binary.Write(buf, binary.BigEndian, resp.Config); err != nil {
-		s.Log.Error("ConfigService: setInitConfig(): Write() has failed: ", err)
-		panic("init config translation to []byte has failed")
+	s.Config.SetPayloadFormat(resp.PayloadFormat)
+	s.Log.Infof("payload format negotiated with the center: %s", resp.PayloadFormat)
+
+	s.patchMu.Lock()
+	defer s.patchMu.Unlock()
+
+	// the config cached from a later patch wins over the outdated one of the center
+	if version := s.Config.GetVersion(); resp.ConfigVersion != 0 && resp.ConfigVersion < version {
+		s.Log.Infof("center config of version %d is older than the current one of version %d and has been ignored",
+			resp.ConfigVersion, version)
+		return nil
 	}
 
-	s.patchConfig(buf)
+	if resp.PayloadFormat == api.PayloadFormat_PROTO {
+		if resp.TypedConfig == nil {
+			s.Log.Error("ConfigService: setInitConfig(): init config is missing in the response")
+			configPatchesTotal.Inc(patchRejected)
+			return nil
+		}
+		err = s.applyConfig(fromProtoFridgeConfig(resp.TypedConfig), resp.ConfigVersion)
+	} else {
+		err = s.decodeConfig(bytes.NewBuffer(resp.Config), resp.ConfigVersion)
+	}
+
+	// the center is asked again only on restart, the current config is kept meanwhile
+	if err != nil {
+		s.Log.Errorf("ConfigService: setInitConfig(): center config has been rejected: %s", err)
+		return nil
+	}
+	s.Log.Info("config has been reconciled with the center")
+	return nil
 }
 
-func (s *ConfigService) listenConfigPatches() {
-	defer func() {
-		if r := recover(); r != nil {
-			s.Log.Errorf("ConfigService: listenConfigPatches(): panic(): %s", r)
-			s.Controller.Terminate()
+func (s *ConfigService) listenConfigPatches(ctx context.Context) error {
+	if s.SharedNATS != nil {
+		return s.listenSharedConfigPatches(ctx)
+	}
+
+	closed := make(chan struct{})
+	var closeOnce sync.Once
+	opts, err := s.NATS.Options()
+	if err != nil {
+		return fmt.Errorf("invalid NATS settings: %s", err)
+	}
+	opts = append(opts,
+		nats.DisconnectHandler(func(nc *nats.Conn) {
+			if nc.IsClosed() {
+				return
+			}
+			s.Log.Error("ConfigService: listenConfigPatches(): nats connectivity status: DISCONNECTED")
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			s.Log.Infof("reconnected to %s", nc.ConnectedUrl())
+			s.Reconnected(nc)
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			closeOnce.Do(func() { close(closed) })
+		}),
+	)
+
+	var conn *nats.Conn
+	err = retry.Do(ctx, newBackoff(s.Clock, s.RetryInterval), func() error {
+		var err error
+		if conn, err = nats.Connect(s.NATS.URL(), opts...); err != nil {
+			s.Log.Errorf("ConfigService: listenConfigPatches(): nats connectivity status: DISCONNECTED: %s", err)
 		}
-	}()
+		return err
+	})
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	s.setNATSConn(conn)
+	defer s.setNATSConn(nil)
+
+	s.Log.Infof("connected to %s", conn.ConnectedUrl())
 
-	conn, err := nats.Connect(nats.DefaultURL)
-	for err != nil {
-		s.Log.Error("ConfigService: listenConfigPatches(): nats connectivity status: DISCONNECTED")
-		duration := time.Duration(rand.Intn(int(s.RetryInterval.Seconds())))
-		time.Sleep(time.Second*duration + 1)
-		conn, err = nats.Connect(nats.DefaultURL)
+	if err := s.subscribe(conn); err != nil {
+		return err
 	}
+	s.sendReportedState(conn)
+
+	select {
+	case <-ctx.Done():
+		s.Log.Info("config patch listening has stopped")
+		return nil
+	case <-closed:
+		return errors.New("nats connection has been closed")
+	}
+}
+
+// listenSharedConfigPatches subscribes to config patches over SharedNATS.
+// The owner of the connection calls Reconnected once it has been restored.
+func (s *ConfigService) listenSharedConfigPatches(ctx context.Context) error {
+	conn := s.SharedNATS
+	s.setNATSConn(conn)
+	defer s.setNATSConn(nil)
 
-	s.Log.Infof("connected to " + nats.DefaultURL)
+	if err := s.subscribe(conn); err != nil {
+		return err
+	}
+	defer s.unsubscribe()
+	s.sendReportedState(conn)
+
+	<-ctx.Done()
+	s.Log.Info("config patch listening has stopped")
+	return nil
+}
+
+// Reconnected restores the subscription to config patches if it hasn't
+// survived the reconnection and publishes the reported state.
+func (s *ConfigService) Reconnected(conn *nats.Conn) {
+	s.resubscribe(conn)
+	s.sendReportedState(conn)
+}
 
+func (s *ConfigService) subscribe(conn *nats.Conn) error {
 	queue := "Config.ConfigPatchQueue"
 	subject := "Config.Patch." + s.Meta.MAC
 
-	conn.QueueSubscribe(subject, queue, func(msg *nats.Msg) {
-		eventStore := api.EventStore{}
-		if err := proto.Unmarshal(msg.Data, &eventStore); err == nil {
-			s.patchConfig(bytes.NewBufferString(eventStore.EventData))
-		}
+	sub, err := conn.QueueSubscribe(subject, queue, func(msg *nats.Msg) {
+		s.handlePatchEvent(conn, msg)
 	})
+	if err != nil {
+		return fmt.Errorf("QueueSubscribe() has failed: %s", err)
+	}
+
+	s.natsMu.Lock()
+	s.natsSub = sub
+	s.natsMu.Unlock()
+	return nil
 }
 
-func (s *ConfigService) patchConfig(buf *bytes.Buffer) {
+func (s *ConfigService) unsubscribe() {
+	s.natsMu.Lock()
+	sub := s.natsSub
+	s.natsSub = nil
+	s.natsMu.Unlock()
+
+	if sub != nil {
+		if err := sub.Unsubscribe(); err != nil {
+			s.Log.Errorf("ConfigService: unsubscribe(): Unsubscribe() has failed: %s", err)
+		}
+	}
+}
+
+// resubscribe restores the subscription to config patches if it hasn't
+// survived the reconnection.
+func (s *ConfigService) resubscribe(conn *nats.Conn) {
+	s.natsMu.RLock()
+	sub := s.natsSub
+	s.natsMu.RUnlock()
+
+	if sub != nil && sub.IsValid() {
+		return
+	}
+	if err := s.subscribe(conn); err != nil {
+		s.Log.Errorf("ConfigService: resubscribe(): %s", err)
+	}
+}
+
+// PatchConfig applies the JSON encoded patch of FridgeConfig the same way
+// as the patches received from the center.
+func (s *ConfigService) PatchConfig(patch []byte) error {
+	s.patchMu.Lock()
+	defer s.patchMu.Unlock()
+	return s.decodeConfig(bytes.NewBuffer(patch), 0)
+}
+
+// NATSConn returns the current connection to NATS or nil if there is none.
+func (s *ConfigService) NATSConn() *nats.Conn {
+	s.natsMu.RLock()
+	defer s.natsMu.RUnlock()
+	return s.natsConn
+}
+
+// NATSState returns the state of the connection to NATS.
+func (s *ConfigService) NATSState() string {
+	s.natsMu.RLock()
+	defer s.natsMu.RUnlock()
+
+	if s.natsConn == nil {
+		return "DISCONNECTED"
+	}
+
+	switch s.natsConn.Status() {
+	case nats.CONNECTED:
+		return "CONNECTED"
+	case nats.CLOSED:
+		return "CLOSED"
+	case nats.RECONNECTING:
+		return "RECONNECTING"
+	case nats.CONNECTING:
+		return "CONNECTING"
+	case nats.DRAINING_SUBS, nats.DRAINING_PUBS:
+		return "DRAINING"
+	default:
+		return "DISCONNECTED"
+	}
+}
+
+func (s *ConfigService) setNATSConn(conn *nats.Conn) {
+	s.natsMu.Lock()
+	s.natsConn = conn
+	s.natsMu.Unlock()
+}
+
+// decodeConfig applies the JSON encoded patch on top of the current config.
+// patchMu must be held.
+func (s *ConfigService) decodeConfig(buf *bytes.Buffer, version uint64) error {
 	var patchedConfig = s.Config.GetFridgeConfig()
 	if err := json.NewDecoder(buf).Decode(&patchedConfig); err != nil {
-		s.Log.Error("ConfigService: patchConfig(): Decode() has failed: ", err)
-		panic("config decoding has failed")
+		s.Log.Error("ConfigService: decodeConfig(): Decode() has failed: ", err)
+		configPatchesTotal.Inc(patchRejected)
+		return fmt.Errorf("config decoding has failed: %s", err)
+	}
+	return s.applyConfig(patchedConfig, version)
+}
+
+// applyConfig validates the config and makes it current. Non-zero version
+// becomes the current config version. patchMu must be held.
+func (s *ConfigService) applyConfig(patchedConfig FridgeConfig, version uint64) error {
+	if err := validateConfig(patchedConfig); err != nil {
+		s.Log.Error("ConfigService: applyConfig(): invalid config: ", err)
+		configPatchesTotal.Inc(patchRejected)
+		return fmt.Errorf("invalid config: %s", err)
 	}
 
 	if patchedConfig.TurnedOn && !s.Config.GetTurnedOn() {
@@ -223,6 +516,16 @@ func (s *ConfigService) patchConfig(buf *bytes.Buffer) {
 	}
 
 	s.Config.SetFridgeConfig(patchedConfig)
-	s.Log.Infof("current config: %+v", s.Config.FridgeConfig)
+	if version != 0 {
+		s.Config.SetVersion(version)
+	}
+	if s.Store != nil {
+		if err := s.Store.Save(patchedConfig, s.Config.GetVersion()); err != nil {
+			s.Log.Errorf("ConfigService: applyConfig(): Save() has failed: %s", err)
+		}
+	}
+	s.Log.Infof("current config: %+v, version: %d", s.Config.GetFridgeConfig(), s.Config.GetVersion())
 	s.Config.publishConfigIsPatched()
+	configPatchesTotal.Inc(patchApplied)
+	return nil
 }
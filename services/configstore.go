@@ -0,0 +1,62 @@
+package services
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// DefaultFridgeConfig is used when neither the center nor the cache
+// has provided a config yet.
+var DefaultFridgeConfig = FridgeConfig{
+	TurnedOn:    true,
+	CollectFreq: 1000,
+	SendFreq:    5000,
+}
+
+// ConfigStore is used to persist the last applied FridgeConfig together with
+// its version, so the fridge can boot with it while the center is unreachable.
+type ConfigStore struct {
+	Path string
+}
+
+type storedConfig struct {
+	Config  FridgeConfig
+	Version uint64
+}
+
+// NewConfigStore creates the directory of the config file if needed and
+// initializes new ConfigStore object.
+// It returns initialized object.
+func NewConfigStore(path string) (*ConfigStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return &ConfigStore{Path: path}, nil
+}
+
+// Load returns the stored config and its version. The returned error
+// satisfies os.IsNotExist if nothing has been stored yet.
+func (s *ConfigStore) Load() (FridgeConfig, uint64, error) {
+	b, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return FridgeConfig{}, 0, err
+	}
+
+	var c storedConfig
+	if err := json.Unmarshal(b, &c); err != nil {
+		return FridgeConfig{}, 0, err
+	}
+	return c.Config, c.Version, nil
+}
+
+// Save stores the config and its version. The file is written to a temporary
+// file first and then renamed, so a crash never leaves a partially written config.
+func (s *ConfigStore) Save(c FridgeConfig, version uint64) error {
+	b, err := json.Marshal(storedConfig{Config: c, Version: version})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.Path, b)
+}
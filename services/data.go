@@ -2,7 +2,10 @@ package services
 
 import (
 	"bytes"
-	"math/rand"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"context"
@@ -11,17 +14,27 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/kostiamol/fridgems/api/pb"
+	"github.com/kostiamol/fridgems/breaker"
+	"github.com/kostiamol/fridgems/clock"
+	"github.com/kostiamol/fridgems/downsample"
 	"github.com/kostiamol/fridgems/entities"
+	"github.com/kostiamol/fridgems/supervisor"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/connectivity"
 )
 
-// FridgeData is used to store maps for each of the two
-// compartments with unix timestamp as a key and temperature
-// at that time as a value.
+// FridgeData is used to store collected data of each of the
+// compartments.
 type FridgeData struct {
-	TopCompart map[int64]float32
-	BotCompart map[int64]float32
+	Compartments []CompartmentData
+}
+
+// CompartmentData is used to store compartment's name, units of
+// its readings and the readings in the order they have been taken.
+type CompartmentData struct {
+	Name    string
+	Unit    string
+	Samples []Sample
 }
 
 // SaveFridgeDataRequest is used to store unix timestamp as a
@@ -33,288 +46,622 @@ type SaveFridgeDataRequest struct {
 	Data FridgeData
 }
 
-// FridgeDatum is used to represent a pair of unix timestamp and
-// temperature.
+// FridgeDatum is used to represent a reading taken in the compartment
+// with name Compart.
 type FridgeDatum struct {
-	Time int64
-	Temp float32
+	Compart string
+	Sample
 }
 
 // DataService is used to handle device's data manipulations.
-// TopCompart channel receives generated data for the first
-// compartment, and BotCompart - for the second one.
+// Readings channel receives data read from the sensors of all
+// the Compartments. The batches are sent to the center by SendWorkers
+// workers that take them from SendQueue, in the order of their sequence
+// numbers.
+// If SharedCenter is set, the service uses that connection owned by the
+// caller instead of dialing.
+// The tickers, timeouts and timestamps of the service are driven by Clock,
+// the cooldown of Breaker by the Clock of the breaker.
 type DataService struct {
 	Config        *Configuration
 	Meta          *entities.DevMeta
-	Controller    *entities.ServiceController
-	TopCompart    chan FridgeDatum
-	BotCompart    chan FridgeDatum
+	Compartments  []Compartment
+	Readings      chan FridgeDatum
 	ReqChan       chan SaveFridgeDataRequest
 	Center        entities.Server
+	Outbox        *Outbox
+	SendQueue     *SendQueue
+	SendWorkers   int
+	Alarms        *AlarmService
 	Log           *logrus.Logger
 	RetryInterval time.Duration
+	FlushTimeout  time.Duration
+	Breaker       *breaker.Breaker
+	SharedCenter  *grpc.ClientConn
+	Clock         clock.Clock
+	collected     chan struct{}
+	collectedOnce sync.Once
+	persisted     chan struct{}
+	persistedOnce sync.Once
+	stateMu       sync.RWMutex
+	lastReadings  map[string]FridgeDatum
+	conn          *grpc.ClientConn
+	streamMu      sync.Mutex
+	stream        *dataStream
+	attemptsMu    sync.Mutex
+	attempts      map[uint64]int
+	removeMu      sync.RWMutex
+	memorySeq     uint64
+	// backlog is set when the outbox may have batches that aren't queued,
+	// refillSeq is the sequence number of the last one refill has queued.
+	backlog     int32
+	refillSeq   uint64
+	spilling    bool
+	downsampler *downsample.Downsampler
+	// streamUnsupported is set once the center has reported that it doesn't
+	// implement StreamDevData, so unary SaveDevData is used from then on.
+	streamUnsupported bool
 }
 
 // NewDataService creates and initializes new DataService object.
 // It returns initialized object.
-func NewDataService(c *Configuration, m *entities.DevMeta, s entities.Server, ctrl *entities.ServiceController,
-	l *logrus.Logger, r time.Duration) *DataService {
+func NewDataService(c *Configuration, m *entities.DevMeta, s entities.Server, comparts []Compartment, o *Outbox,
+	q *SendQueue, w int, a *AlarmService, l *logrus.Logger, r time.Duration, f time.Duration) *DataService {
 	return &DataService{
-		TopCompart:    make(chan FridgeDatum, 100),
-		BotCompart:    make(chan FridgeDatum, 100),
+		Compartments:  comparts,
+		Readings:      make(chan FridgeDatum, 100*len(comparts)),
 		ReqChan:       make(chan SaveFridgeDataRequest),
 		Config:        c,
 		Meta:          m,
 		Center:        s,
-		Controller:    ctrl,
+		Outbox:        o,
+		SendQueue:     q,
+		SendWorkers:   w,
+		Alarms:        a,
 		Log:           l,
 		RetryInterval: r,
+		FlushTimeout:  f,
+		Breaker:       newCenterBreaker("data", clock.Real, r, l),
+		Clock:         clock.Real,
+		collected:     make(chan struct{}),
+		persisted:     make(chan struct{}),
+		lastReadings:  make(map[string]FridgeDatum, len(comparts)),
+		attempts:      make(map[uint64]int),
+		backlog:       1,
+		downsampler:   downsample.NewDownsampler(),
+	}
+}
+
+// LastReadings returns the last reading of each compartment.
+func (s *DataService) LastReadings() map[string]FridgeDatum {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+
+	readings := make(map[string]FridgeDatum, len(s.lastReadings))
+	for k, v := range s.lastReadings {
+		readings[k] = v
+	}
+	return readings
+}
+
+// CenterState returns the state of the connection to the center.
+func (s *DataService) CenterState() string {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+
+	if s.conn == nil {
+		return connectivity.Shutdown.String()
 	}
+	return s.conn.GetState().String()
 }
 
-// Run runs the service's inner goroutines for data generation,
-// collection and sending to the center.
-func (s *DataService) Run() {
-	go s.generateData()
-	go s.collectData()
-	go s.sendData()
+func (s *DataService) getConn() *grpc.ClientConn {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+	return s.conn
 }
 
-func (s *DataService) generateData() {
-	defer func() {
-		if r := recover(); r != nil {
-			s.Log.Errorf("DataService: generateData(): panic(): %s", r)
-			s.Controller.Terminate()
+func (s *DataService) setConn(conn *grpc.ClientConn) {
+	s.stateMu.Lock()
+	s.conn = conn
+	s.stateMu.Unlock()
+}
+
+func (s *DataService) isStreamUnsupported() bool {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+	return s.streamUnsupported
+}
+
+// setStreamUnsupported marks streaming as unsupported by the center.
+// It returns whether it has been marked before.
+func (s *DataService) setStreamUnsupported() bool {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	was := s.streamUnsupported
+	s.streamUnsupported = true
+	return was
+}
+
+// Run starts the service's workers for data reading, collection,
+// persisting and sending to the center under sup. When sup is
+// stopped, the readings that haven't been sent yet are collected
+// into the final batch and the service tries to deliver all the
+// pending batches within FlushTimeout; the undelivered ones stay
+// in the outbox till the next run.
+func (s *DataService) Run(sup *supervisor.Supervisor) {
+	conn := s.SharedCenter
+	if conn == nil {
+		var err error
+		if conn, err = dial(sup.Context(), s.Center, s.Log, newBackoff(s.Clock, s.RetryInterval)); err != nil {
+			s.Log.Errorf("DataService: Run(): dial() has failed: %s", err)
+			return
 		}
-	}()
+	}
+	s.setConn(conn)
 
-	duration := s.Config.GetCollectFreq()
-	ticker := time.NewTicker(time.Duration(duration) * time.Millisecond)
-	stopInner := make(chan struct{})
+	sup.Go("dataGenerator", s.generateData)
+	sup.Go("dataCollector", s.collectData)
+	sup.Go("dataPersister", s.persistData)
+	for i := 1; i <= s.SendWorkers; i++ {
+		sup.Go(fmt.Sprintf("dataSender%d", i), s.newSender().run)
+	}
+	sup.Go("dataReplayer", s.replayData)
+}
 
-	configIsPatched := make(chan struct{})
+func (s *DataService) generateData(ctx context.Context) error {
+	configIsPatched := make(chan struct{}, 1)
 	s.Config.Subscribe("dataGenerator", configIsPatched)
 
-	if s.Config.GetTurnedOn() {
-		go s.dataGenerator(ticker, s.TopCompart, s.BotCompart, stopInner)
-	}
+	ticker := s.newTicker(s.Config.GetCollectFreq())
+	defer func() { ticker.stop() }()
 
 	for {
 		select {
 		case <-configIsPatched:
-			state := s.Config.GetTurnedOn()
-			switch state {
-			case true:
-				select {
-				case <-stopInner:
-					stopInner = make(chan struct{})
-					ticker = time.NewTicker(time.Duration(s.Config.GetCollectFreq()) * time.Millisecond)
-					go s.dataGenerator(ticker, s.TopCompart, s.BotCompart, stopInner)
-				default:
-					close(stopInner)
-					ticker.Stop()
-					stopInner = make(chan struct{})
-					ticker = time.NewTicker(time.Duration(s.Config.GetCollectFreq()) * time.Millisecond)
-					go s.dataGenerator(ticker, s.TopCompart, s.BotCompart, stopInner)
+			ticker.stop()
+			ticker = s.newTicker(s.Config.GetCollectFreq())
+		case <-ticker.c:
+			for i := range s.Compartments {
+				c := &s.Compartments[i]
+				quality := QualityGood
+				temp, err := c.ReadTemp()
+				if _, ok := err.(*OutOfBoundsError); ok {
+					s.Log.Errorf("DataService: generateData(): %s: %s", c.Name, err)
+					quality = QualityBad
+				} else if err != nil {
+					s.Log.Errorf("DataService: generateData(): %s: ReadTemp() has failed: %s", c.Name, err)
+					continue
+				}
+				d := FridgeDatum{
+					Compart: c.Name,
+					Sample:  Sample{Time: s.timestamp(), Temp: temp, Unit: c.Unit, Quality: quality},
 				}
-			case false:
+				readingsTotal.Inc(c.Name)
+				temperature.Set(float64(temp), c.Name, c.Unit)
+				s.stateMu.Lock()
+				s.lastReadings[c.Name] = d
+				s.stateMu.Unlock()
+
 				select {
-				case <-stopInner:
-					ticker = time.NewTicker(time.Duration(s.Config.GetCollectFreq()) * time.Millisecond)
-				default:
-					close(stopInner)
+				case s.Readings <- d:
+				case <-ctx.Done():
 				}
 			}
-		case <-s.Controller.StopChan:
+		case <-ctx.Done():
 			s.Log.Info("data generation has stopped")
-			return
+			return nil
 		}
 	}
 }
 
-func (s *DataService) dataGenerator(t *time.Ticker, topCompart chan<- FridgeDatum, botCompart chan<- FridgeDatum,
-	stopInner chan struct{}) {
-	defer func() {
-		if r := recover(); r != nil {
-			s.Log.Errorf("DataService: dataGenerator(): panic(): %s", r)
-			s.Controller.Terminate()
-		}
-	}()
+// timestamp returns the current time of the clock in milliseconds.
+func (s *DataService) timestamp() int64 {
+	return s.Clock.Now().UnixNano() / int64(time.Millisecond)
+}
+
+func (s *DataService) collectData(ctx context.Context) error {
+	configIsPatched := make(chan struct{}, 1)
+	s.Config.Subscribe("dataCollector", configIsPatched)
+
+	ticker := s.newTicker(s.Config.GetSendFreq())
+	defer func() { ticker.stop() }()
+
+	series := s.newSeries()
 
 	for {
 		select {
-		case <-t.C:
-			topCompart <- FridgeDatum{Time: currentTimestamp(), Temp: rand.Float32() * 10}
-			botCompart <- FridgeDatum{Time: currentTimestamp(), Temp: (rand.Float32() * 10) - 8}
-		case <-stopInner:
-			return
+		case <-configIsPatched:
+			wasOn := ticker.c != nil
+			ticker.stop()
+			ticker = s.newTicker(s.Config.GetSendFreq())
+			// hand over the readings collected before the device was paused
+			if wasOn && ticker.c == nil && countReadings(series) > 0 {
+				s.ReqChan <- s.newSaveFridgeDataRequest(series)
+				series = s.newSeries()
+			}
+		case d := <-s.Readings:
+			s.addReading(series, d)
+		case <-ticker.c:
+			s.ReqChan <- s.newSaveFridgeDataRequest(series)
+			series = s.newSeries()
+		case <-ctx.Done():
+		drain:
+			for {
+				select {
+				case d := <-s.Readings:
+					s.addReading(series, d)
+				default:
+					break drain
+				}
+			}
+			if countReadings(series) > 0 {
+				select {
+				case s.ReqChan <- s.newSaveFridgeDataRequest(series):
+				case <-s.Clock.After(s.FlushTimeout):
+					s.Log.Error("DataService: collectData(): final batch hasn't been handed over in time")
+				}
+			}
+			s.collectedOnce.Do(func() { close(s.collected) })
+			s.Log.Info("data collection has stopped")
+			return nil
 		}
 	}
 }
 
-func currentTimestamp() int64 {
-	return time.Now().UnixNano() / int64(time.Millisecond)
+func (s *DataService) addReading(series map[string][]Sample, d FridgeDatum) {
+	if samples, ok := series[d.Compart]; ok {
+		series[d.Compart] = append(samples, d.Sample)
+	}
+	if s.Alarms != nil && d.Quality == QualityGood {
+		s.Alarms.Observe(d)
+	}
+}
+
+func countReadings(series map[string][]Sample) int {
+	n := 0
+	for _, samples := range series {
+		n += len(samples)
+	}
+	return n
 }
 
-func (s *DataService) collectData() {
-	defer func() {
-		if r := recover(); r != nil {
-			s.Log.Errorf("DataService: collectData(): panic(): %s", r)
-			s.Controller.Terminate()
+func (s *DataService) newSeries() map[string][]Sample {
+	series := make(map[string][]Sample, len(s.Compartments))
+	for _, c := range s.Compartments {
+		series[c.Name] = nil
+	}
+	return series
+}
+
+func (s *DataService) newSaveFridgeDataRequest(series map[string][]Sample) SaveFridgeDataRequest {
+	data := FridgeData{
+		Compartments: make([]CompartmentData, 0, len(s.Compartments)),
+	}
+	settings := s.Config.GetFridgeConfig().DownsampleSettings()
+	for _, c := range s.Compartments {
+		data.Compartments = append(data.Compartments, CompartmentData{
+			Name:    c.Name,
+			Unit:    c.Unit,
+			Samples: s.downsample(c, series[c.Name], settings),
+		})
+	}
+
+	return SaveFridgeDataRequest{
+		Time: s.Clock.Now().UnixNano(),
+		Meta: *s.Meta,
+		Data: data,
+	}
+}
+
+// downsample reduces the good samples of the compartment with the given
+// settings. The bad samples are always kept as they are.
+func (s *DataService) downsample(c Compartment, samples []Sample, settings downsample.Settings) []Sample {
+	if settings.Mode == downsample.None {
+		return samples
+	}
+
+	var bad []Sample
+	points := make([]downsample.Point, 0, len(samples))
+	for _, sample := range samples {
+		if sample.Quality != QualityGood {
+			bad = append(bad, sample)
+			continue
 		}
-	}()
+		points = append(points, downsample.Point{Time: sample.Time, Temp: sample.Temp})
+	}
 
-	duration := s.Config.GetSendFreq()
-	stopInner := make(chan struct{})
-	ticker := time.NewTicker(time.Duration(duration) * time.Millisecond)
+	reduced := s.downsampler.Reduce(c.Name, points, settings)
+	readingsDownsampledTotal.Add(float64(len(points)-len(reduced)), c.Name)
 
-	configIsPatched := make(chan struct{})
-	s.Config.Subscribe("dataCollector", configIsPatched)
+	downsampled := make([]Sample, 0, len(reduced)+len(bad))
+	for _, p := range reduced {
+		downsampled = append(downsampled, Sample{Time: p.Time, Temp: p.Temp, Unit: c.Unit, Quality: QualityGood})
+	}
+	if len(bad) > 0 {
+		downsampled = append(downsampled, bad...)
+		sort.SliceStable(downsampled, func(i, j int) bool { return downsampled[i].Time < downsampled[j].Time })
+	}
+	return downsampled
+}
+
+// pipelineTicker is used to tick with the configured frequency while the
+// device is turned on; its channel is nil while the device is paused.
+type pipelineTicker struct {
+	t clock.Ticker
+	c <-chan time.Time
+}
+
+func (s *DataService) newTicker(freq int64) pipelineTicker {
+	if !s.Config.GetTurnedOn() || freq <= 0 {
+		return pipelineTicker{}
+	}
+	t := s.Clock.NewTicker(time.Duration(freq) * time.Millisecond)
+	return pipelineTicker{t: t, c: t.C()}
+}
 
-	if s.Config.GetTurnedOn() {
-		go s.dataCollector(ticker, s.TopCompart, s.BotCompart, s.ReqChan, stopInner)
+func (t pipelineTicker) stop() {
+	if t.t != nil {
+		t.t.Stop()
 	}
+}
 
+// persistData stores the batches handed over by the collector in the outbox
+// and pushes them to the send queue.
+func (s *DataService) persistData(ctx context.Context) error {
 	for {
 		select {
-		case <-configIsPatched:
-			state := s.Config.GetTurnedOn()
-			switch state {
-			case true:
-				select {
-				case <-stopInner:
-					stopInner = make(chan struct{})
-					ticker = time.NewTicker(time.Duration(s.Config.GetSendFreq()) * time.Millisecond)
-					go s.dataCollector(ticker, s.TopCompart, s.BotCompart, s.ReqChan, stopInner)
-				default:
-					close(stopInner)
-					stopInner = make(chan struct{})
-					ticker = time.NewTicker(time.Duration(s.Config.GetSendFreq()) * time.Millisecond)
-					go s.dataCollector(ticker, s.TopCompart, s.BotCompart, s.ReqChan, stopInner)
-				}
-			case false:
+		case r := <-s.ReqChan:
+			s.persist(r)
+		case <-ctx.Done():
+			// persist the final batch handed over by the collector
+			timeout := s.Clock.After(s.FlushTimeout)
+			for collected := false; !collected; {
 				select {
-				case <-stopInner:
-					ticker = time.NewTicker(time.Duration(s.Config.GetSendFreq()) * time.Millisecond)
-				default:
-					close(stopInner)
+				case r := <-s.ReqChan:
+					s.persist(r)
+				case <-s.collected:
+					collected = true
+				case <-timeout:
+					s.Log.Error("DataService: persistData(): final batch hasn't been collected in time")
+					collected = true
 				}
 			}
-		case <-s.Controller.StopChan:
-			s.Log.Info("data collection has stopped")
-			return
+			s.persistedOnce.Do(func() { close(s.persisted) })
+			s.Log.Info("data persisting has stopped")
+			return nil
 		}
 	}
 }
 
-func (s *DataService) dataCollector(t *time.Ticker, topCompart <-chan FridgeDatum, botCompart <-chan FridgeDatum,
-	ReqChan chan SaveFridgeDataRequest, stopInner chan struct{}) {
-	defer func() {
-		if r := recover(); r != nil {
-			s.Log.Errorf("DataService: dataCollector(): panic(): %s", r)
-			s.Controller.Terminate()
+// persist stores the batch in the outbox and queues it for sending.
+// With SpillToDisk the batch is queued from the outbox behind the ones
+// waiting there, so the batches are sent in order. A batch that can't be
+// stored in the outbox is kept in memory only.
+func (s *DataService) persist(r SaveFridgeDataRequest) {
+	seq, err := s.Outbox.Put(r)
+	if err != nil {
+		s.Log.Errorf("DataService: persist(): Put() has failed: %s", err)
+		seq = memorySeqBase + atomic.AddUint64(&s.memorySeq, 1)
+	}
+
+	policy := s.SendQueue.GetPolicy()
+	if policy == SpillToDisk && !isMemoryOnly(seq) {
+		atomic.StoreInt32(&s.backlog, 1)
+		if s.refill() < seq {
+			batchesSpilledTotal.Inc()
 		}
-	}()
+		return
+	}
+
+	dropped, _ := s.SendQueue.Push(OutboxEntry{Seq: seq, Req: r})
+	for _, e := range dropped {
+		batchesDroppedTotal.Inc(string(policy))
+		s.Log.Warnf("DataService: persist(): send queue is full, batch %d has been dropped (%s)",
+			e.Seq, policy)
+		s.removeFromOutbox(e.Seq)
+	}
+}
 
-	var timeTempTopCompart = make(map[int64]float32)
-	var timeTempBotCompart = make(map[int64]float32)
+// replayData queues the batches left in the outbox from the previous run
+// and the spilled ones whenever there is room in the send queue, and
+// retries every RetryInterval.
+func (s *DataService) replayData(ctx context.Context) error {
+	ticker := s.Clock.NewTicker(s.RetryInterval)
+	defer ticker.Stop()
+
+	s.refill()
 
 	for {
 		select {
-		case t := <-topCompart:
-			timeTempTopCompart[t.Time] = t.Temp
-		case b := <-botCompart:
-			timeTempBotCompart[b.Time] = b.Temp
-		case <-t.C:
-			ReqChan <- s.newSaveFridgeDataRequest(timeTempTopCompart, timeTempBotCompart)
-			timeTempTopCompart = make(map[int64]float32)
-			timeTempBotCompart = make(map[int64]float32)
-		case <-stopInner:
-			return
+		case <-s.SendQueue.Room():
+			s.refill()
+		case <-ticker.C():
+			s.refill()
+		case <-ctx.Done():
+			s.shutdown()
+			s.Log.Info("data sending has stopped")
+			return nil
 		}
 	}
 }
 
-func (s *DataService) newSaveFridgeDataRequest(topCompart map[int64]float32, botCompart map[int64]float32) SaveFridgeDataRequest {
-	return SaveFridgeDataRequest{
-		Time: time.Now().UnixNano(),
-		Meta: *s.Meta,
-		Data: FridgeData{
-			TopCompart: topCompart,
-			BotCompart: botCompart,
-		},
+// refill queues the batches waiting in the outbox in order while there is
+// room in the send queue. Only the batches after the last queued one are
+// read, no more than there is room for. It returns the sequence number
+// of the last batch that has been queued.
+func (s *DataService) refill() uint64 {
+	// batches mustn't be removed between reading and queueing them,
+	// otherwise a delivered batch would be queued again
+	s.removeMu.Lock()
+	defer s.removeMu.Unlock()
+
+	if atomic.SwapInt32(&s.backlog, 0) == 0 {
+		return s.refillSeq
 	}
-}
 
-func (s *DataService) sendData() {
-	defer func() {
-		if r := recover(); r != nil {
-			s.Log.Errorf("DataService: sendData(): panic(): %s", r)
-			s.Controller.Terminate()
+	for {
+		free := s.SendQueue.Free()
+		if free == 0 {
+			s.spilled()
+			return s.refillSeq
 		}
-	}()
-
-	conn := dial(s.Center, s.Log, s.RetryInterval)
-	defer conn.Close()
 
-	for {
-		select {
-		case r := <-s.ReqChan:
-			go s.saveFridgeData(r, conn)
-		case <-s.Controller.StopChan:
-			s.Log.Info("data sending has stopped")
-			return
+		entries, err := s.Outbox.Pending(s.refillSeq, free)
+		if err != nil {
+			s.Log.Errorf("DataService: refill(): Pending() has failed: %s", err)
+		}
+		for _, e := range entries {
+			if !s.SendQueue.Offer(e) {
+				s.spilled()
+				return s.refillSeq
+			}
+			s.refillSeq = e.Seq
+		}
+		if err != nil {
+			// the batches after the failed ones are read on the next refill
+			atomic.StoreInt32(&s.backlog, 1)
+			return s.refillSeq
+		}
+		if len(entries) < free {
+			s.spilling = false
+			return s.refillSeq
 		}
 	}
 }
 
-func (s *DataService) saveFridgeData(fr SaveFridgeDataRequest, conn *grpc.ClientConn) {
-	defer func() {
-		if r := recover(); r != nil {
-			s.Log.Errorf("DataService: saveFridgeData(): panic(): %s", r)
-			s.Controller.Terminate()
+// spilled marks that the outbox has batches that aren't queued, removeMu must be held.
+func (s *DataService) spilled() {
+	atomic.StoreInt32(&s.backlog, 1)
+	if !s.spilling {
+		s.spilling = true
+		s.Log.Warn("DataService: refill(): send queue is full, batches are spilled to the outbox")
+	}
+}
+
+// shutdown waits for the final batches to be persisted and for the sender
+// workers to stop and tries to deliver all the pending batches within
+// FlushTimeout.
+func (s *DataService) shutdown() {
+	conn := s.getConn()
+	if conn != s.SharedCenter {
+		defer conn.Close()
+	}
+	defer s.setConn(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.FlushTimeout)
+	defer cancel()
+
+	select {
+	case <-s.persisted:
+	case <-ctx.Done():
+		return
+	}
+	if err := s.SendQueue.WaitIdle(ctx); err != nil {
+		s.Log.Error("DataService: shutdown(): sender workers haven't stopped in time")
+		return
+	}
+
+	entries, err := s.Outbox.Pending(0, 0)
+	if err != nil {
+		s.Log.Errorf("DataService: shutdown(): Pending() has failed: %s", err)
+	}
+	memOnly := s.SendQueue.TakeMemoryOnly()
+	entries = append(entries, memOnly...)
+
+	snd := s.newSender()
+	defer s.resetStream()
+	sent := snd.send(ctx, conn, entries)
+	for _, e := range entries[sent:] {
+		if isMemoryOnly(e.Seq) {
+			s.Log.Errorf("DataService: shutdown(): unpersisted batch %d has been lost", e.Seq)
 		}
-	}()
+	}
+	if n := s.Outbox.Len(); n > 0 {
+		s.Log.Errorf("DataService: shutdown(): %d batch(es) are left in the outbox", n)
+	}
+}
 
-	fr.Time = time.Now().UnixNano()
+func (s *DataService) countAttempt(seq uint64) {
+	s.attemptsMu.Lock()
+	defer s.attemptsMu.Unlock()
 
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(fr.Data); err != nil {
-		s.Log.Errorf("DataService: saveFridgeData(): Encode() has failed: %s", err)
-		panic("FridgeData can't be encoded for sending")
+	if s.attempts[seq] > 0 {
+		batchesRetriedTotal.Inc()
 	}
+	s.attempts[seq]++
+}
+
+// removeFromOutbox removes the batch that has been delivered or dropped
+// from the outbox and releases its place in the send queue.
+func (s *DataService) removeFromOutbox(seq uint64) {
+	s.removeMu.RLock()
+	defer s.removeMu.RUnlock()
+
+	s.attemptsMu.Lock()
+	delete(s.attempts, seq)
+	s.attemptsMu.Unlock()
 
+	if !isMemoryOnly(seq) {
+		if err := s.Outbox.Remove(seq); err != nil {
+			s.Log.Errorf("DataService: removeFromOutbox(): Remove() has failed: %s", err)
+		}
+	}
+	s.SendQueue.Done(seq)
+}
+
+// newSaveDevDataRequest encodes the request in the payload format
+// negotiated with the center.
+func (s *DataService) newSaveDevDataRequest(fr SaveFridgeDataRequest) (*api.SaveDevDataRequest, error) {
 	req := &api.SaveDevDataRequest{
-		Time: fr.Time,
+		Time: s.Clock.Now().UnixNano(),
 		Meta: &api.DevMeta{
 			Type: fr.Meta.Type,
 			Name: fr.Meta.Name,
 			Mac:  fr.Meta.MAC,
+			Id:   fr.Meta.ID,
 		},
-		Data: buf.Bytes(),
 	}
 
-	client := api.NewCenterServiceClient(conn)
-	for conn.GetState() != connectivity.Ready {
-		s.Log.Error("DataService: saveFridgeData(): center connectivity status: NOT READY")
-		duration := time.Duration(rand.Intn(int(s.RetryInterval.Seconds())))
-		time.Sleep(time.Second*duration + 1)
+	if s.Config.GetPayloadFormat() == api.PayloadFormat_PROTO {
+		req.TypedData = toProtoFridgeData(fr.Data)
+		return req, nil
 	}
 
-	resp, err := client.SaveDevData(context.Background(), req)
-	if err != nil {
-		s.Log.Errorf("DataService: saveFridgeData(): SaveDevData() has failed: %s", err)
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(toLegacyFridgeData(fr.Data)); err != nil {
+		s.Log.Errorf("DataService: newSaveDevDataRequest(): Encode() has failed: %s", err)
+		return nil, err
 	}
-	s.Log.Infof("center has received FridgeData with status: %s", resp.Status)
+	req.Data = buf.Bytes()
+	return req, nil
 }
 
-func dial(s entities.Server, l *logrus.Logger, reconnInterval time.Duration) *grpc.ClientConn {
-	conn, err := grpc.Dial(s.Host+":"+s.Port, grpc.WithInsecure())
-	for err != nil {
-		l.Error("dial(): grpc.Dial(): failed to dial remote server")
-		duration := time.Duration(rand.Intn(int(reconnInterval.Seconds())))
-		time.Sleep(time.Second*duration + 1)
-		conn, err = grpc.Dial(s.Host+":"+s.Port, grpc.WithInsecure())
+func (s *DataService) saveFridgeData(ctx context.Context, fr SaveFridgeDataRequest, conn *grpc.ClientConn) error {
+	req, err := s.newSaveDevDataRequest(fr)
+	if err != nil {
+		return err
 	}
-	return conn
+
+	b := newBackoff(s.Clock, s.RetryInterval)
+	b.MaxElapsed = s.RetryInterval
+	if err := waitForReady(ctx, conn, b, s.Log, "DataService: saveFridgeData()"); err != nil {
+		return err
+	}
+
+	start := s.Clock.Now()
+	resp, err := newCenterClient(conn, s.Breaker).SaveDevData(ctx, req)
+	saveDevDataDuration.Observe(s.Clock.Since(start).Seconds())
+	if err == breaker.ErrOpen {
+		s.Log.Errorf("DataService: saveFridgeData(): SaveDevData() hasn't been called: %s", err)
+		return err
+	}
+	if err != nil {
+		batchesFailedTotal.Inc()
+		s.Log.Errorf("DataService: saveFridgeData(): SaveDevData() has failed: %s", err)
+		return err
+	}
+	batchesSentTotal.Inc()
+	s.Log.Infof("center has received FridgeData with status: %s", resp.Status)
+	return nil
 }
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/clock"
+	"github.com/kostiamol/fridgems/entities"
+)
+
+// waitTimeout limits the real time the tests wait for the workers.
+const waitTimeout = time.Second * 5
+
+type constSensor float32
+
+func (s constSensor) ReadTemp() (float32, error) {
+	return float32(s), nil
+}
+
+// pipeline is used to drive the generator and the collector of DataService
+// by the fake clock.
+type pipeline struct {
+	t      *testing.T
+	s      *DataService
+	clock  *clock.Fake
+	cancel context.CancelFunc
+	// since is the time the tickers of the current config have been started.
+	since time.Time
+}
+
+func startPipeline(t *testing.T, c FridgeConfig) *pipeline {
+	l := logrus.New()
+	l.Out = ioutil.Discard
+
+	config := &Configuration{SubsPool: make(map[string]chan struct{})}
+	config.SetFridgeConfig(c)
+	comparts := []Compartment{
+		{Name: "TopCompart", Unit: UnitCelsius, Min: -10, Max: 20, Sensor: constSensor(4)},
+		{Name: "BotCompart", Unit: UnitCelsius, Min: -30, Max: 10, Sensor: constSensor(-18)},
+	}
+	meta := &entities.DevMeta{Type: "fridge", Name: "fridge-test", MAC: "0A-1B-2C-3D-4E-5F"}
+
+	s := NewDataService(config, meta, entities.Server{}, comparts, nil, nil, 1, nil, l, time.Second, time.Second)
+	p := &pipeline{
+		t:     t,
+		s:     s,
+		clock: clock.NewFake(time.Unix(1543846800, 0)),
+	}
+	s.Clock = p.clock
+	p.since = p.clock.Now()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	go s.generateData(ctx)
+	go s.collectData(ctx)
+	p.waitForTickers(c)
+	return p
+}
+
+func (p *pipeline) stop() {
+	p.cancel()
+	// the collector hands over the readings left on stop
+	select {
+	case <-p.s.ReqChan:
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+// patch applies the config and waits until the workers have restarted
+// their tickers.
+func (p *pipeline) patch(c FridgeConfig) {
+	p.s.Config.SetFridgeConfig(c)
+	p.s.Config.publishConfigIsPatched()
+	p.since = p.clock.Now()
+	p.waitForTickers(c)
+}
+
+func (p *pipeline) waitForTickers(c FridgeConfig) {
+	var want []time.Time
+	if c.TurnedOn {
+		want = []time.Time{
+			p.since.Add(time.Duration(c.CollectFreq) * time.Millisecond),
+			p.since.Add(time.Duration(c.SendFreq) * time.Millisecond),
+		}
+	}
+	p.waitFor("tickers", func() bool {
+		got := p.clock.Deadlines()
+		if len(got) != len(want) {
+			return false
+		}
+		for _, d := range want {
+			if !containsTime(got, d) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// collect advances the clock by n collection periods and waits for the
+// readings of each of them to reach the collector.
+func (p *pipeline) collect(n int) {
+	freq := time.Duration(p.s.Config.GetCollectFreq()) * time.Millisecond
+	for i := 0; i < n; i++ {
+		p.clock.Advance(freq)
+		now := p.clock.Now().UnixNano() / int64(time.Millisecond)
+		p.waitFor("readings", func() bool {
+			readings := p.s.LastReadings()
+			if len(readings) != len(p.s.Compartments) {
+				return false
+			}
+			for _, d := range readings {
+				if d.Time != now {
+					return false
+				}
+			}
+			return len(p.s.Readings) == 0
+		})
+	}
+}
+
+// send moves the clock to the next send tick and returns the batch.
+func (p *pipeline) send() SaveFridgeDataRequest {
+	freq := time.Duration(p.s.Config.GetSendFreq()) * time.Millisecond
+	next := p.since
+	for !next.After(p.clock.Now()) {
+		next = next.Add(freq)
+	}
+	p.clock.Set(next)
+	return p.batch()
+}
+
+func (p *pipeline) batch() SaveFridgeDataRequest {
+	select {
+	case r := <-p.s.ReqChan:
+		return r
+	case <-time.After(waitTimeout):
+		p.t.Fatal("batch hasn't been handed over")
+		return SaveFridgeDataRequest{}
+	}
+}
+
+func (p *pipeline) noBatch() {
+	select {
+	case r := <-p.s.ReqChan:
+		p.t.Errorf("unexpected batch has been handed over: %+v", r.Data)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func (p *pipeline) waitFor(what string, cond func() bool) {
+	deadline := time.Now().Add(waitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			p.t.Fatalf("%s haven't been ready in time", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func containsTime(ts []time.Time, t time.Time) bool {
+	for _, v := range ts {
+		if v.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func countSamples(r SaveFridgeDataRequest) []int {
+	var counts []int
+	for _, c := range r.Data.Compartments {
+		counts = append(counts, len(c.Samples))
+	}
+	return counts
+}
+
+func TestDataPipelineTransitions(t *testing.T) {
+	on := FridgeConfig{TurnedOn: true, CollectFreq: 100, SendFreq: 1050}
+	off := on
+	off.TurnedOn = false
+
+	tests := []struct {
+		name string
+		// config is the config the pipeline is started with.
+		config FridgeConfig
+		// before is the number of the collection periods before the patch.
+		before int
+		patch  FridgeConfig
+		// handedOver is the number of the samples per compartment in the batch
+		// handed over on the patch, -1 if none is expected.
+		handedOver int
+		// sent is the number of the samples per compartment in the batch sent
+		// on the first send tick after the patch, -1 if the device is off.
+		sent int
+	}{
+		{
+			name:       "start",
+			config:     off,
+			patch:      on,
+			handedOver: -1,
+			sent:       10,
+		},
+		{
+			name:       "stop",
+			config:     on,
+			before:     5,
+			patch:      off,
+			handedOver: 5,
+			sent:       -1,
+		},
+		{
+			name:       "stop without readings",
+			config:     on,
+			patch:      off,
+			handedOver: -1,
+			sent:       -1,
+		},
+		{
+			name:       "collect frequency change",
+			config:     on,
+			before:     3,
+			patch:      FridgeConfig{TurnedOn: true, CollectFreq: 250, SendFreq: 1050},
+			handedOver: -1,
+			sent:       3 + 4,
+		},
+		{
+			name:       "send frequency change",
+			config:     on,
+			before:     3,
+			patch:      FridgeConfig{TurnedOn: true, CollectFreq: 100, SendFreq: 550},
+			handedOver: -1,
+			sent:       3 + 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := startPipeline(t, tt.config)
+			defer p.stop()
+
+			if tt.config.TurnedOn {
+				p.collect(tt.before)
+			} else {
+				p.clock.Advance(time.Minute)
+				p.noBatch()
+			}
+
+			p.patch(tt.patch)
+			if tt.handedOver >= 0 {
+				if got := countSamples(p.batch()); got[0] != tt.handedOver || got[1] != tt.handedOver {
+					t.Errorf("handed over batch has %v samples, want %d per compartment", got, tt.handedOver)
+				}
+			}
+
+			if tt.sent < 0 {
+				if n := p.clock.Timers(); n != 0 {
+					t.Errorf("%d tickers are active while the device is off", n)
+				}
+				p.clock.Advance(time.Minute)
+				p.noBatch()
+				return
+			}
+
+			collectFreq := time.Duration(tt.patch.CollectFreq) * time.Millisecond
+			sendFreq := time.Duration(tt.patch.SendFreq) * time.Millisecond
+			p.collect(int(sendFreq / collectFreq))
+			r := p.send()
+			if got := countSamples(r); got[0] != tt.sent || got[1] != tt.sent {
+				t.Errorf("sent batch has %v samples, want %d per compartment", got, tt.sent)
+			}
+			if want := p.clock.Now().UnixNano(); r.Time != want {
+				t.Errorf("batch time = %d, want %d of the fake clock", r.Time, want)
+			}
+		})
+	}
+}
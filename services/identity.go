@@ -0,0 +1,155 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kostiamol/fridgems/clock"
+)
+
+// Names of the files kept in the directory of IdentityStore.
+const (
+	identityFile   = "identity.json"
+	deviceKeyFile  = "device.key"
+	deviceCertFile = "device.crt"
+	centerCAFile   = "ca.crt"
+	natsCredsFile  = "nats.creds"
+)
+
+// Identity is used to store the identity the device has generated on its
+// first boot and the credentials the center has issued to it. The
+// credentials are bound to MAC and are dropped once the MAC has changed.
+type Identity struct {
+	ID          string
+	Serial      string
+	MAC         string
+	Credentials *Credentials `json:",omitempty"`
+}
+
+// Credentials is used to store the paths of the files with the credentials
+// issued to the device by the center. CACert and NATSCreds are empty
+// if the center hasn't issued them.
+type Credentials struct {
+	Cert      string
+	Key       string
+	CACert    string `json:",omitempty"`
+	NATSCreds string `json:",omitempty"`
+	IssuedAt  int64
+}
+
+// IdentityStore is used to persist the identity and the credentials of the
+// device in Dir. The serials of the new identities are dated by Clock.
+type IdentityStore struct {
+	Dir   string
+	Clock clock.Clock
+}
+
+// NewIdentityStore creates the directory of the identity if needed and
+// initializes new IdentityStore object.
+// It returns initialized object.
+func NewIdentityStore(dir string) (*IdentityStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &IdentityStore{Dir: dir, Clock: clock.Real}, nil
+}
+
+// LoadOrCreate returns the stored identity of the device with the given MAC.
+// A new identity is generated and stored on the first boot. If the stored
+// identity belongs to another MAC, the ID and the serial are kept but the
+// credentials are dropped, so the device has to be provisioned again.
+func (s *IdentityStore) LoadOrCreate(mac string) (Identity, error) {
+	b, err := ioutil.ReadFile(filepath.Join(s.Dir, identityFile))
+	if os.IsNotExist(err) {
+		id, err := newIdentity(mac, s.Clock.Now())
+		if err != nil {
+			return Identity{}, err
+		}
+		return id, s.Save(id)
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var id Identity
+	if err := json.Unmarshal(b, &id); err != nil {
+		return Identity{}, fmt.Errorf("identity file is invalid: %s", err)
+	}
+	if id.MAC != mac {
+		id.MAC, id.Credentials = mac, nil
+		return id, s.Save(id)
+	}
+	return id, nil
+}
+
+// Save stores the identity.
+func (s *IdentityStore) Save(id Identity) error {
+	b, err := json.MarshalIndent(id, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.Dir, identityFile), b)
+}
+
+// SaveCredentials stores the PEM encoded key and certificates and the NATS
+// credentials of the device issued at issuedAt and records them in the identity.
+// Empty ca and natsCreds aren't stored.
+func (s *IdentityStore) SaveCredentials(id *Identity, issuedAt time.Time, key, cert, ca, natsCreds []byte) error {
+	c := &Credentials{
+		Cert:     filepath.Join(s.Dir, deviceCertFile),
+		Key:      filepath.Join(s.Dir, deviceKeyFile),
+		IssuedAt: issuedAt.Unix(),
+	}
+	files := []struct {
+		path *string
+		b    []byte
+	}{
+		{&c.Key, key},
+		{&c.Cert, cert},
+		{&c.CACert, ca},
+		{&c.NATSCreds, natsCreds},
+	}
+	if len(ca) > 0 {
+		c.CACert = filepath.Join(s.Dir, centerCAFile)
+	}
+	if len(natsCreds) > 0 {
+		c.NATSCreds = filepath.Join(s.Dir, natsCredsFile)
+	}
+
+	for _, f := range files {
+		if *f.path == "" {
+			continue
+		}
+		if err := writeFileAtomic(*f.path, f.b); err != nil {
+			return err
+		}
+	}
+
+	id.Credentials = c
+	return s.Save(*id)
+}
+
+// newIdentity generates a random ID in the UUID form and a serial made of
+// the date of the first boot, now, and a random suffix, e.g. 181203-4F2A9C1E.
+func newIdentity(mac string, now time.Time) (Identity, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return Identity{}, err
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+
+	u := hex.EncodeToString(b[:16])
+	return Identity{
+		ID:     u[:8] + "-" + u[8:12] + "-" + u[12:16] + "-" + u[16:20] + "-" + u[20:],
+		Serial: now.Format("060102") + "-" + strings.ToUpper(hex.EncodeToString(b[16:])),
+		MAC:    mac,
+	}, nil
+}
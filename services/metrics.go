@@ -0,0 +1,127 @@
+package services
+
+import (
+	"github.com/kostiamol/fridgems/breaker"
+	"github.com/kostiamol/fridgems/metrics"
+	"google.golang.org/grpc/connectivity"
+)
+
+var (
+	readingsTotal = metrics.NewCounterVec("fridgems_readings_total",
+		"Number of readings generated per compartment.", "compartment")
+	readingsDownsampledTotal = metrics.NewCounterVec("fridgems_readings_downsampled_total",
+		"Number of readings left out by downsampling per compartment.", "compartment")
+	temperature = metrics.NewGaugeVec("fridgems_temperature",
+		"The last temperature read in a compartment.", "compartment", "unit")
+	batchesSentTotal = metrics.NewCounterVec("fridgems_batches_sent_total",
+		"Number of data batches saved by the center.")
+	batchesFailedTotal = metrics.NewCounterVec("fridgems_batches_failed_total",
+		"Number of failed attempts to send a data batch to the center.")
+	batchesRetriedTotal = metrics.NewCounterVec("fridgems_batches_retried_total",
+		"Number of repeated attempts to send a data batch to the center.")
+	saveDevDataDuration = metrics.NewHistogram("fridgems_save_dev_data_duration_seconds",
+		"Latency of SaveDevData calls and of streamed batches from sending to the ack.", metrics.DefBuckets)
+	batchesDroppedTotal = metrics.NewCounterVec("fridgems_batches_dropped_total",
+		"Number of data batches dropped because the send queue was full, by overflow policy.", "policy")
+	batchesSpilledTotal = metrics.NewCounterVec("fridgems_batches_spilled_total",
+		"Number of data batches left in the outbox because the send queue was full.")
+	configPatchesTotal = metrics.NewCounterVec("fridgems_config_patches_total",
+		"Number of config patches by result.", "result")
+	alarmsTotal = metrics.NewCounterVec("fridgems_alarms_total",
+		"Number of alarm events by state.", "state")
+	alarmsDroppedTotal = metrics.NewCounterVec("fridgems_alarms_dropped_total",
+		"Number of alarm events dropped unacked because too many of them were pending.")
+)
+
+// Results of config patches reported by fridgems_config_patches_total.
+const (
+	patchApplied  = "applied"
+	patchRejected = "rejected"
+	patchIgnored  = "ignored"
+)
+
+var (
+	grpcStates = []connectivity.State{
+		connectivity.Idle,
+		connectivity.Connecting,
+		connectivity.Ready,
+		connectivity.TransientFailure,
+		connectivity.Shutdown,
+	}
+	natsStates = []string{"CONNECTED", "CONNECTING", "RECONNECTING", "DRAINING", "DISCONNECTED", "CLOSED"}
+)
+
+// RegisterStateMetrics registers the gauges reporting the connection and
+// circuit breaker states of the services and the depths of the outbox and the send queue.
+func RegisterStateMetrics(cs *ConfigService, ds *DataService, as *AlarmService) {
+	metrics.NewGaugeFunc("fridgems_center_connectivity_state",
+		"State of the gRPC connection to the center, 1 for the current state.",
+		func() []metrics.Sample {
+			current := ds.CenterState()
+			samples := make([]metrics.Sample, 0, len(grpcStates))
+			for _, st := range grpcStates {
+				samples = append(samples, oneHot(st.String(), current))
+			}
+			return samples
+		}, "state")
+
+	metrics.NewGaugeFunc("fridgems_nats_connection_state",
+		"State of the connection to NATS, 1 for the current state.",
+		func() []metrics.Sample {
+			current := cs.NATSState()
+			samples := make([]metrics.Sample, 0, len(natsStates))
+			for _, st := range natsStates {
+				samples = append(samples, oneHot(st, current))
+			}
+			return samples
+		}, "state")
+
+	metrics.NewGaugeFunc("fridgems_center_breaker_state",
+		"State of the circuit breakers of the center, 1 for the current state.",
+		func() []metrics.Sample {
+			services := []string{"config", "data"}
+			breakers := []*breaker.Breaker{cs.Breaker, ds.Breaker}
+			samples := make([]metrics.Sample, 0, len(breakers)*len(breaker.States))
+			for i, b := range breakers {
+				current := b.State().String()
+				for _, st := range breaker.States {
+					sample := oneHot(st.String(), current)
+					sample.LabelValues = []string{services[i], st.String()}
+					samples = append(samples, sample)
+				}
+			}
+			return samples
+		}, "service", "state")
+
+	metrics.NewGaugeFunc("fridgems_outbox_batches",
+		"Number of data batches waiting in the outbox.",
+		func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(ds.Outbox.Len())}}
+		})
+
+	metrics.NewGaugeFunc("fridgems_send_queue_batches",
+		"Number of data batches queued or being sent to the center.",
+		func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(ds.SendQueue.Len())}}
+		})
+
+	metrics.NewGaugeFunc("fridgems_alarms_active",
+		"Number of the currently raised alarms.",
+		func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(len(as.Active()))}}
+		})
+
+	metrics.NewGaugeFunc("fridgems_alarms_pending",
+		"Number of alarm events waiting for an ack of the center.",
+		func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(len(as.Pending()))}}
+		})
+}
+
+func oneHot(state, current string) metrics.Sample {
+	s := metrics.Sample{LabelValues: []string{state}}
+	if state == current {
+		s.Value = 1
+	}
+	return s
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nats-io/go-nats"
+)
+
+// NATSConfig is used to store settings of the connection to NATS.
+// Servers       list of NATS server URLs.
+// User/Password credentials for user/password authentication.
+// Token         token for token authentication.
+// NKeySeedFile  file with the NKey seed for NKey authentication.
+// CredsFile     chained file with the user JWT and NKey seed.
+// CACert        file with CA certificates to verify the servers.
+// ClientCert    file with the client certificate for TLS authentication.
+// ClientKey     file with the key of the client certificate.
+// ReconnectWait pause between reconnection attempts.
+type NATSConfig struct {
+	Servers       []string      `yaml:"servers"`
+	User          string        `yaml:"user"`
+	Password      string        `yaml:"password"`
+	Token         string        `yaml:"token"`
+	NKeySeedFile  string        `yaml:"nkey_seed_file"`
+	CredsFile     string        `yaml:"creds_file"`
+	CACert        string        `yaml:"ca_cert"`
+	ClientCert    string        `yaml:"client_cert"`
+	ClientKey     string        `yaml:"client_key"`
+	ReconnectWait time.Duration `yaml:"reconnect_wait"`
+}
+
+// URL returns the comma-separated list of the servers.
+func (c NATSConfig) URL() string {
+	if len(c.Servers) == 0 {
+		return nats.DefaultURL
+	}
+	return strings.Join(c.Servers, ",")
+}
+
+// HasAuth reports whether any of the authentication methods is set.
+func (c NATSConfig) HasAuth() bool {
+	return c.authMethods() > 0
+}
+
+func (c NATSConfig) authMethods() int {
+	n := 0
+	for _, set := range []bool{c.User != "", c.Token != "", c.NKeySeedFile != "", c.CredsFile != ""} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// Options returns the connection options built from the settings.
+// It returns an error if the settings are inconsistent.
+func (c NATSConfig) Options() ([]nats.Option, error) {
+	var opts []nats.Option
+
+	if c.authMethods() > 1 {
+		return nil, errors.New("only one of user/password, token, NKey or credentials file can be used")
+	}
+
+	switch {
+	case c.User != "":
+		opts = append(opts, nats.UserInfo(c.User, c.Password))
+	case c.Token != "":
+		opts = append(opts, nats.Token(c.Token))
+	case c.NKeySeedFile != "":
+		opt, err := nats.NkeyOptionFromSeed(c.NKeySeedFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	case c.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(c.CredsFile))
+	}
+
+	if c.CACert != "" {
+		opts = append(opts, nats.RootCAs(c.CACert))
+	}
+	if c.ClientCert != "" || c.ClientKey != "" {
+		if c.ClientCert == "" || c.ClientKey == "" {
+			return nil, errors.New("both client certificate and key must be specified")
+		}
+		opts = append(opts, nats.ClientCert(c.ClientCert, c.ClientKey))
+	}
+
+	if c.ReconnectWait > 0 {
+		opts = append(opts, nats.ReconnectWait(c.ReconnectWait))
+	}
+	// reconnect forever, the subscription is restored once the server is back
+	opts = append(opts, nats.MaxReconnects(-1))
+
+	return opts, nil
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	segmentExt    = ".seg"
+	segmentTmpExt = ".tmp"
+	segmentBadExt = ".bad"
+)
+
+// OutboxEntry is used to store a pending SaveFridgeDataRequest together
+// with its sequence number in the outbox.
+type OutboxEntry struct {
+	Seq uint64
+	Req SaveFridgeDataRequest
+}
+
+// Outbox is a durable write-ahead queue of SaveFridgeDataRequest batches.
+// Every batch is persisted to its own segment file under Dir before sending
+// and is removed only after the center has confirmed it, so batches survive
+// both center outages and device restarts.
+type Outbox struct {
+	sync.Mutex
+	Dir string
+	seq uint64
+}
+
+// NewOutbox creates the outbox directory if needed and initializes new Outbox
+// object that continues sequence numbering of the segments already stored there.
+// It returns initialized object.
+func NewOutbox(dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	o := &Outbox{Dir: dir}
+	seqs, err := o.seqs()
+	if err != nil {
+		return nil, err
+	}
+	if len(seqs) > 0 {
+		o.seq = seqs[len(seqs)-1]
+	}
+	return o, nil
+}
+
+// Put persists the request in a new segment and returns its sequence number.
+// The segment is written to a temporary file first and then renamed, so a crash
+// never leaves a partially written segment behind.
+func (o *Outbox) Put(r SaveFridgeDataRequest) (uint64, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return 0, err
+	}
+
+	seq := o.seq + 1
+	if err := writeFileAtomic(o.segmentPath(seq), b); err != nil {
+		return 0, err
+	}
+
+	o.seq = seq
+	return seq, nil
+}
+
+// Remove deletes the segment with the given sequence number.
+func (o *Outbox) Remove(seq uint64) error {
+	o.Lock()
+	defer o.Unlock()
+
+	if err := os.Remove(o.segmentPath(seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Pending returns up to max stored entries with sequence numbers greater than
+// after ordered by their sequence numbers, all of them if max isn't positive.
+// Segments that can't be decoded are quarantined by renaming them to *.bad,
+// so they aren't read again, and are reported in the returned error alongside
+// the entries that were read successfully.
+func (o *Outbox) Pending(after uint64, max int) ([]OutboxEntry, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	seqs, err := o.seqs()
+	if err != nil {
+		return nil, err
+	}
+	seqs = seqs[sort.Search(len(seqs), func(i int) bool { return seqs[i] > after }):]
+
+	var corrupted []string
+	entries := make([]OutboxEntry, 0, len(seqs))
+	for _, seq := range seqs {
+		if max > 0 && len(entries) == max {
+			break
+		}
+		path := o.segmentPath(seq)
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return entries, err
+		}
+
+		e := OutboxEntry{Seq: seq}
+		if err := json.Unmarshal(b, &e.Req); err != nil {
+			if err := os.Rename(path, path+segmentBadExt); err != nil {
+				return entries, err
+			}
+			corrupted = append(corrupted, filepath.Base(path))
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	if len(corrupted) > 0 {
+		return entries, fmt.Errorf("corrupted segments have been quarantined: %s", strings.Join(corrupted, ", "))
+	}
+	return entries, nil
+}
+
+// Len returns the number of stored segments.
+func (o *Outbox) Len() int {
+	o.Lock()
+	defer o.Unlock()
+
+	seqs, err := o.seqs()
+	if err != nil {
+		return 0
+	}
+	return len(seqs)
+}
+
+func (o *Outbox) seqs() ([]uint64, error) {
+	files, err := ioutil.ReadDir(o.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var seqs []uint64
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || filepath.Ext(name) != segmentExt {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+func (o *Outbox) segmentPath(seq uint64) string {
+	return filepath.Join(o.Dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// writeFileAtomic writes the file to a temporary file first and then renames it,
+// so a crash never leaves a partially written file behind.
+func writeFileAtomic(path string, b []byte) error {
+	tmp := path + segmentTmpExt
+	if err := writeFileSync(tmp, b); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func writeFileSync(path string, b []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
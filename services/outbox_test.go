@@ -0,0 +1,100 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestOutbox(t *testing.T, n int) *Outbox {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	o, err := NewOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if _, err := o.Put(SaveFridgeDataRequest{Time: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return o
+}
+
+func TestOutboxPending(t *testing.T) {
+	o := newTestOutbox(t, 5)
+
+	tests := []struct {
+		after uint64
+		max   int
+		want  []uint64
+	}{
+		{after: 0, max: 0, want: []uint64{1, 2, 3, 4, 5}},
+		{after: 0, max: 2, want: []uint64{1, 2}},
+		{after: 2, max: 2, want: []uint64{3, 4}},
+		{after: 4, max: 2, want: []uint64{5}},
+		{after: 5, max: 2, want: nil},
+	}
+	for _, tt := range tests {
+		entries, err := o.Pending(tt.after, tt.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := entrySeqs(entries); !equalSeqs(got, tt.want) {
+			t.Errorf("Pending(%d, %d) = %v, want %v", tt.after, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestOutboxQuarantinesCorruptedSegments(t *testing.T) {
+	o := newTestOutbox(t, 3)
+	if err := ioutil.WriteFile(o.segmentPath(2), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := o.Pending(0, 0)
+	if err == nil {
+		t.Error("corrupted segment hasn't been reported")
+	}
+	if got, want := entrySeqs(entries), []uint64{1, 3}; !equalSeqs(got, want) {
+		t.Errorf("Pending() = %v, want %v", got, want)
+	}
+	if _, err := os.Stat(o.segmentPath(2) + segmentBadExt); err != nil {
+		t.Errorf("corrupted segment hasn't been quarantined: %s", err)
+	}
+
+	if _, err := o.Pending(0, 0); err != nil {
+		t.Errorf("quarantined segment has been reported again: %s", err)
+	}
+	if n := o.Len(); n != 2 {
+		t.Errorf("Len() = %d, want 2", n)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(o.Dir, "*"+segmentBadExt)); len(matches) != 1 {
+		t.Errorf("quarantined segments = %v, want one", matches)
+	}
+}
+
+func entrySeqs(entries []OutboxEntry) []uint64 {
+	var seqs []uint64
+	for _, e := range entries {
+		seqs = append(seqs, e.Seq)
+	}
+	return seqs
+}
+
+func equalSeqs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
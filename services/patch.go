@@ -0,0 +1,199 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/kostiamol/fridgems/api/pb"
+	"github.com/kostiamol/fridgems/downsample"
+	"github.com/nats-io/go-nats"
+)
+
+// Bounds of the config fields in milliseconds.
+const (
+	minCollectFreq = 10
+	maxCollectFreq = 60 * 60 * 1000
+	maxSendFreq    = 24 * 60 * 60 * 1000
+)
+
+// seenEventsLimit is the number of the latest patch event IDs that are
+// remembered to drop redelivered events.
+const seenEventsLimit = 256
+
+// Names of the config fields used in ConfigPatch.update_mask.
+const (
+	maskTurnedOn    = "turned_on"
+	maskCollectFreq = "collect_freq"
+	maskSendFreq    = "send_freq"
+
+	maskDownsample          = "downsample"
+	maskDownsampleWindow    = "downsample_window"
+	maskDownsampleDeviation = "downsample_deviation"
+)
+
+// seenEvents is used to store the IDs of the latest config patch events.
+// Once limit is reached, the oldest IDs are forgotten.
+type seenEvents struct {
+	limit int
+	ids   map[string]struct{}
+	order []string
+}
+
+func newSeenEvents(limit int) *seenEvents {
+	return &seenEvents{
+		limit: limit,
+		ids:   make(map[string]struct{}, limit),
+	}
+}
+
+// add remembers id and returns false if it has been seen already.
+func (e *seenEvents) add(id string) bool {
+	if _, ok := e.ids[id]; ok {
+		return false
+	}
+	if len(e.order) == e.limit {
+		delete(e.ids, e.order[0])
+		e.order = e.order[1:]
+	}
+	e.ids[id] = struct{}{}
+	e.order = append(e.order, id)
+	return true
+}
+
+// validateConfig checks that the config can be applied to the fridge.
+func validateConfig(c FridgeConfig) error {
+	if c.CollectFreq < minCollectFreq || c.CollectFreq > maxCollectFreq {
+		return fmt.Errorf("CollectFreq must be within [%d, %d], got %d",
+			minCollectFreq, maxCollectFreq, c.CollectFreq)
+	}
+	if c.SendFreq < c.CollectFreq || c.SendFreq > maxSendFreq {
+		return fmt.Errorf("SendFreq must be within [CollectFreq, %d], got %d",
+			maxSendFreq, c.SendFreq)
+	}
+
+	ds := c.DownsampleSettings()
+	if err := ds.Validate(); err != nil {
+		return err
+	}
+	if ds.Mode.Windowed() && (ds.Window < c.CollectFreq || ds.Window > c.SendFreq) {
+		return fmt.Errorf("DownsampleWindow must be within [CollectFreq, SendFreq], got %d", ds.Window)
+	}
+	return nil
+}
+
+// applyPatchMask returns c with the fields listed in mask taken from patch.
+// An empty mask takes all the fields.
+func applyPatchMask(c FridgeConfig, patch *api.FridgeConfig, mask []string) (FridgeConfig, error) {
+	if patch == nil {
+		return c, fmt.Errorf("config is missing in the patch")
+	}
+	if len(mask) == 0 {
+		return fromProtoFridgeConfig(patch), nil
+	}
+
+	for _, field := range mask {
+		switch field {
+		case maskTurnedOn:
+			c.TurnedOn = patch.TurnedOn
+		case maskCollectFreq:
+			c.CollectFreq = patch.CollectFreq
+		case maskSendFreq:
+			c.SendFreq = patch.SendFreq
+		case maskDownsample:
+			c.Downsample = downsample.Mode(patch.Downsample)
+		case maskDownsampleWindow:
+			c.DownsampleWindow = patch.DownsampleWindow
+		case maskDownsampleDeviation:
+			c.DownsampleDeviation = patch.DownsampleDeviation
+		default:
+			return c, fmt.Errorf("unknown field in update mask: %q", field)
+		}
+	}
+	return c, nil
+}
+
+// handlePatchEvent applies the config patch event received from NATS.
+// Duplicate and stale events are ignored, rejected ones are reported
+// back to the center.
+func (s *ConfigService) handlePatchEvent(conn *nats.Conn, msg *nats.Msg) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.Log.Errorf("ConfigService: handlePatchEvent(): patch handling has panicked: %v", r)
+		}
+	}()
+
+	var event api.EventStore
+	if err := proto.Unmarshal(msg.Data, &event); err != nil {
+		s.Log.Errorf("ConfigService: handlePatchEvent(): Unmarshal() has failed: %s", err)
+		configPatchesTotal.Inc(patchRejected)
+		s.rejectPatch(conn, msg.Reply, &event, err)
+		s.ackPatch(conn, &event, patchRejected, err)
+		return
+	}
+
+	result, err := s.patchConfigEvent(&event)
+	if err != nil {
+		s.Log.Errorf("ConfigService: handlePatchEvent(): patch %q has been rejected: %s", event.EventId, err)
+		s.rejectPatch(conn, msg.Reply, &event, err)
+	}
+	s.ackPatch(conn, &event, result, err)
+}
+
+// patchConfigEvent applies the event and returns the result of the patch.
+func (s *ConfigService) patchConfigEvent(event *api.EventStore) (string, error) {
+	s.patchMu.Lock()
+	defer s.patchMu.Unlock()
+
+	if event.EventId != "" && !s.seenEvents.add(event.EventId) {
+		s.Log.Infof("config patch %q is a duplicate and has been ignored", event.EventId)
+		configPatchesTotal.Inc(patchIgnored)
+		return patchIgnored, nil
+	}
+	if event.Version != 0 && event.Version <= s.Config.GetVersion() {
+		s.Log.Infof("config patch %q of version %d is stale and has been ignored, current version: %d",
+			event.EventId, event.Version, s.Config.GetVersion())
+		configPatchesTotal.Inc(patchIgnored)
+		return patchIgnored, nil
+	}
+
+	config := s.Config.GetFridgeConfig()
+	if event.ConfigPatch != nil {
+		var err error
+		if config, err = applyPatchMask(config, event.ConfigPatch.Config, event.ConfigPatch.UpdateMask); err != nil {
+			configPatchesTotal.Inc(patchRejected)
+			return patchRejected, err
+		}
+	} else if err := json.NewDecoder(bytes.NewBufferString(event.EventData)).Decode(&config); err != nil {
+		configPatchesTotal.Inc(patchRejected)
+		return patchRejected, fmt.Errorf("config decoding has failed: %s", err)
+	}
+
+	if err := s.applyConfig(config, event.Version); err != nil {
+		return patchRejected, err
+	}
+	return patchApplied, nil
+}
+
+// rejectPatch publishes ConfigPatchReject to the reply subject of the patch
+// or to Config.Reject.<MAC> if the patch has no reply subject.
+func (s *ConfigService) rejectPatch(conn *nats.Conn, reply string, event *api.EventStore, reason error) {
+	if reply == "" {
+		reply = "Config.Reject." + s.Meta.MAC
+	}
+
+	b, err := proto.Marshal(&api.ConfigPatchReject{
+		EventId: event.EventId,
+		Version: event.Version,
+		Mac:     s.Meta.MAC,
+		Reason:  reason.Error(),
+	})
+	if err != nil {
+		s.Log.Errorf("ConfigService: rejectPatch(): Marshal() has failed: %s", err)
+		return
+	}
+	if err := conn.Publish(reply, b); err != nil {
+		s.Log.Errorf("ConfigService: rejectPatch(): Publish() has failed: %s", err)
+	}
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"github.com/kostiamol/fridgems/api/pb"
+	"github.com/kostiamol/fridgems/downsample"
+)
+
+// supportedPayloadFormats lists the payload formats the fridge can handle,
+// the preferred one first.
+var supportedPayloadFormats = []api.PayloadFormat{api.PayloadFormat_PROTO, api.PayloadFormat_JSON}
+
+// toProtoFridgeData converts FridgeData to its typed proto representation
+// keeping the order of the readings.
+func toProtoFridgeData(d FridgeData) *api.FridgeData {
+	pd := &api.FridgeData{
+		Compartments: make([]*api.CompartmentSeries, 0, len(d.Compartments)),
+	}
+	for _, c := range d.Compartments {
+		series := &api.CompartmentSeries{
+			Name:     c.Name,
+			Unit:     c.Unit,
+			Readings: make([]*api.FridgeReading, 0, len(c.Samples)),
+		}
+		for _, s := range c.Samples {
+			series.Readings = append(series.Readings, &api.FridgeReading{
+				Time:    s.Time,
+				Temp:    s.Temp,
+				Unit:    s.Unit,
+				Quality: toProtoQuality(s.Quality),
+			})
+		}
+		pd.Compartments = append(pd.Compartments, series)
+	}
+	return pd
+}
+
+func toProtoQuality(q Quality) api.Quality {
+	if q == QualityGood {
+		return api.Quality_GOOD
+	}
+	return api.Quality_BAD
+}
+
+// fromProtoFridgeConfig converts the typed proto config to FridgeConfig.
+func fromProtoFridgeConfig(c *api.FridgeConfig) FridgeConfig {
+	return FridgeConfig{
+		TurnedOn:            c.TurnedOn,
+		CollectFreq:         c.CollectFreq,
+		SendFreq:            c.SendFreq,
+		Downsample:          downsample.Mode(c.Downsample),
+		DownsampleWindow:    c.DownsampleWindow,
+		DownsampleDeviation: c.DownsampleDeviation,
+	}
+}
+
+// toProtoFridgeConfig converts FridgeConfig to its typed proto representation.
+func toProtoFridgeConfig(c FridgeConfig) *api.FridgeConfig {
+	return &api.FridgeConfig{
+		TurnedOn:            c.TurnedOn,
+		CollectFreq:         c.CollectFreq,
+		SendFreq:            c.SendFreq,
+		Downsample:          string(c.Downsample),
+		DownsampleWindow:    c.DownsampleWindow,
+		DownsampleDeviation: c.DownsampleDeviation,
+	}
+}
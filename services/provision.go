@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/api/pb"
+	"github.com/kostiamol/fridgems/clock"
+	"github.com/kostiamol/fridgems/entities"
+	"github.com/kostiamol/fridgems/retry"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// registerTimeout limits the time of a single RegisterDev call.
+const registerTimeout = time.Second * 10
+
+// Provisioner is used to register the device with the center on its first
+// boot. The device sends the provisioning Token and a certificate request
+// for its MAC, and stores the credentials issued by the center in Store.
+// Center mustn't require a client certificate, the device has none yet.
+type Provisioner struct {
+	Center        entities.Server
+	Meta          *entities.DevMeta
+	Store         *IdentityStore
+	Token         string
+	Log           *logrus.Logger
+	RetryInterval time.Duration
+	Clock         clock.Clock
+}
+
+// NewProvisioner creates and initializes new Provisioner object.
+// It returns initialized object.
+func NewProvisioner(s entities.Server, m *entities.DevMeta, st *IdentityStore, token string,
+	l *logrus.Logger, r time.Duration) *Provisioner {
+	return &Provisioner{
+		Center:        s,
+		Meta:          m,
+		Store:         st,
+		Token:         token,
+		Log:           l,
+		RetryInterval: r,
+		Clock:         clock.Real,
+	}
+}
+
+// Provision registers the device unless it has the credentials already.
+// It retries while the center is unreachable and gives up if the center
+// has rejected the device or doesn't support provisioning, or ctx is done.
+// It returns the identity with the credentials.
+func (p *Provisioner) Provision(ctx context.Context, id Identity) (Identity, error) {
+	if id.Credentials != nil {
+		return id, nil
+	}
+	if p.Center.TLS == nil {
+		p.Log.Warn("provisioning token is sent to the center over a plaintext connection")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return id, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: id.MAC, SerialNumber: id.Serial},
+	}, key)
+	if err != nil {
+		return id, err
+	}
+	req := &api.RegisterDevRequest{
+		Meta: &api.DevMeta{
+			Type: p.Meta.Type,
+			Name: p.Meta.Name,
+			Mac:  p.Meta.MAC,
+			Id:   p.Meta.ID,
+		},
+		Serial: id.Serial,
+		Token:  p.Token,
+		Csr:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}),
+	}
+
+	conn, err := dial(ctx, p.Center, p.Log, newBackoff(p.Clock, p.RetryInterval))
+	if err != nil {
+		return id, err
+	}
+	defer conn.Close()
+
+	var resp *api.RegisterDevResponse
+	err = retry.Do(ctx, newBackoff(p.Clock, p.RetryInterval), func() error {
+		callCtx, cancel := context.WithTimeout(ctx, registerTimeout)
+		defer cancel()
+
+		var err error
+		resp, err = api.NewCenterServiceClient(conn).RegisterDev(callCtx, req)
+		switch status.Code(err) {
+		case codes.OK:
+			return nil
+		case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+			p.Log.Errorf("Provisioner: Provision(): RegisterDev() has failed: %s", err)
+			return err
+		case codes.Unimplemented:
+			return retry.Permanent(errors.New("center doesn't support provisioning"))
+		default:
+			return retry.Permanent(fmt.Errorf("center has rejected the device: %s", status.Convert(err).Message()))
+		}
+	})
+	if err != nil {
+		return id, err
+	}
+
+	if err := checkIssuedCert(resp.Certificate, key, id.MAC); err != nil {
+		return id, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return id, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := p.Store.SaveCredentials(&id, p.Clock.Now(), keyPEM, resp.Certificate, resp.CaCert, resp.NatsCreds); err != nil {
+		return id, err
+	}
+
+	p.Log.Infof("device %s has been provisioned", id.ID)
+	return id, nil
+}
+
+// checkIssuedCert checks that the PEM encoded certificate has been issued
+// for the key and the MAC of the device.
+func checkIssuedCert(certPEM []byte, key *ecdsa.PrivateKey, mac string) error {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return errors.New("center hasn't issued a certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+		return errors.New("issued certificate doesn't match the device key")
+	}
+	if cn, err := entities.NormalizeMAC(cert.Subject.CommonName); err != nil || cn != mac {
+		return fmt.Errorf("certificate has been issued for %q, not for the device MAC %q",
+			cert.Subject.CommonName, mac)
+	}
+	return nil
+}
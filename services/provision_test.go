@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/centertest"
+	"github.com/kostiamol/fridgems/clock"
+	"github.com/kostiamol/fridgems/entities"
+)
+
+func TestProvision(t *testing.T) {
+	const mac = "0A-1B-2C-3D-4E-5F"
+	now := time.Unix(1543846800, 0)
+
+	tests := []struct {
+		name string
+		// token is the provisioning token required by the center.
+		token string
+		// issuedCN is the common name the center issues the certificate for.
+		issuedCN string
+		// provisioned means the device has the credentials already.
+		provisioned bool
+		wantErr     bool
+		// wantRegs is the number of the registrations expected by the center.
+		wantRegs int
+	}{
+		{name: "issued for device", token: "secret", wantRegs: 1},
+		{name: "issued for MAC with colons", issuedCN: "0a:1b:2c:3d:4e:5f", wantRegs: 1},
+		{name: "issued for another MAC", issuedCN: "0A-1B-2C-3D-4E-60", wantErr: true, wantRegs: 1},
+		{name: "issued for no MAC", issuedCN: "fridge-test", wantErr: true, wantRegs: 1},
+		{name: "invalid token", token: "other", wantErr: true},
+		{name: "already provisioned", provisioned: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "provision")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c := centertest.NewCenter()
+			c.SetProvisioningToken(tt.token)
+			c.SetIssuedCommonName(tt.issuedCN)
+			if err := c.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer c.Stop()
+
+			l := logrus.New()
+			l.Out = ioutil.Discard
+
+			store, err := NewIdentityStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.Clock = clock.NewFake(now)
+			id, err := store.LoadOrCreate(mac)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.provisioned {
+				id.Credentials = &Credentials{Cert: "device.crt", Key: "device.key"}
+			}
+
+			meta := &entities.DevMeta{Type: "fridge", Name: "fridge-test", MAC: mac}
+			p := NewProvisioner(c.Server(), meta, store, "secret", l, time.Second)
+			p.Clock = clock.NewFake(now.Add(time.Hour))
+			got, err := p.Provision(context.Background(), id)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Provision() error = %v, want error %t", err, tt.wantErr)
+			}
+			if n := len(c.Registrations()); n != tt.wantRegs {
+				t.Errorf("center has %d registration(s), want %d", n, tt.wantRegs)
+			}
+			stored, err := store.LoadOrCreate(mac)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr || tt.provisioned {
+				if stored.Credentials != nil {
+					t.Errorf("credentials %+v have been stored, want none", stored.Credentials)
+				}
+				return
+			}
+
+			if stored.Credentials == nil || *stored.Credentials != *got.Credentials {
+				t.Errorf("stored credentials = %+v, want %+v", stored.Credentials, got.Credentials)
+			}
+			if issuedAt := p.Clock.Now().Unix(); got.Credentials.IssuedAt != issuedAt {
+				t.Errorf("IssuedAt = %d, want %d", got.Credentials.IssuedAt, issuedAt)
+			}
+			checkStoredCert(t, got.Credentials, c.CACert())
+		})
+	}
+}
+
+func TestNewIdentitySerial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewIdentityStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Clock = clock.NewFake(time.Date(2018, time.December, 3, 12, 0, 0, 0, time.UTC))
+	id, err := store.LoadOrCreate("0A-1B-2C-3D-4E-5F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id.Serial) != len("181203-4F2A9C1E") || id.Serial[:7] != "181203-" {
+		t.Errorf("Serial = %q, want 181203-XXXXXXXX", id.Serial)
+	}
+}
+
+// checkStoredCert checks that the stored certificate has been issued by
+// the CA of the center and that the CA certificate has been stored as well.
+func checkStoredCert(t *testing.T, c *Credentials, caPEM []byte) {
+	certPEM, err := ioutil.ReadFile(c.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedCA, err := ioutil.ReadFile(c.CACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(storedCA) != string(caPEM) {
+		t.Error("stored CA certificate isn't the one of the center")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		t.Fatal("stored certificate isn't PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Errorf("stored certificate hasn't been issued by the center: %s", err)
+	}
+	if _, err := ioutil.ReadFile(c.Key); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,421 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// memorySeqBase is the first sequence number of the batches that
+// couldn't be stored in the outbox and are kept in memory only.
+const memorySeqBase = uint64(1) << 63
+
+// errNotTurn is returned when a batch before the given ones is waiting in
+// the queue or has to be sent again, so the given ones can't be sent yet.
+var errNotTurn = errors.New("batches before the ones being sent haven't been sent")
+
+// OverflowPolicy is used to specify what happens to a batch pushed
+// to the full SendQueue.
+type OverflowPolicy string
+
+// Overflow policies of SendQueue.
+const (
+	// SpillToDisk leaves the new batch in the outbox only, the batches are
+	// queued from the outbox in order once there is room. Batches that can't
+	// be stored in the outbox are dropped.
+	SpillToDisk OverflowPolicy = "spill"
+	// DropOldest drops the oldest queued batch to make room for the new one.
+	DropOldest OverflowPolicy = "drop-oldest"
+	// DropNewest drops the new batch.
+	DropNewest OverflowPolicy = "drop-newest"
+)
+
+// ParseOverflowPolicy returns the policy with the given name.
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(name); p {
+	case SpillToDisk, DropOldest, DropNewest:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy: %q", name)
+	}
+}
+
+// SendQueue is used to store the batches waiting for the sender workers,
+// ordered by their sequence numbers. Capacity bounds the number of the
+// batches that are queued or being sent, once it's reached Policy decides
+// which batch gives way. Both can be changed at runtime with SetLimits.
+// The batches being sent are sent in turn: a batch is sent only once all
+// the batches before it have been sent.
+type SendQueue struct {
+	Capacity int
+	Policy   OverflowPolicy
+	mu       sync.Mutex
+	queued   []OutboxEntry
+	inFlight map[uint64]bool
+	sent     map[uint64]bool
+	ready    chan struct{}
+	room     chan struct{}
+	// turn is closed and replaced each time the turn may have changed
+	// or an entry has been released.
+	turn chan struct{}
+}
+
+// NewSendQueue creates and initializes new SendQueue object.
+// It returns initialized object.
+func NewSendQueue(capacity int, p OverflowPolicy) *SendQueue {
+	return &SendQueue{
+		Capacity: capacity,
+		Policy:   p,
+		inFlight: make(map[uint64]bool),
+		sent:     make(map[uint64]bool),
+		ready:    make(chan struct{}, 1),
+		room:     make(chan struct{}, 1),
+		turn:     make(chan struct{}),
+	}
+}
+
+// GetPolicy returns the current overflow policy.
+func (q *SendQueue) GetPolicy() OverflowPolicy {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.Policy
+}
+
+// SetLimits changes the capacity and the overflow policy of the queue.
+// The batches that are over the reduced capacity stay queued, no new ones
+// are queued until there is room again.
+func (q *SendQueue) SetLimits(capacity int, p OverflowPolicy) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.Capacity, q.Policy = capacity, p
+	if q.len() < q.Capacity {
+		notify(q.room)
+	}
+}
+
+// Push queues the entry applying Policy if the queue is full. It returns
+// the entries that have been dropped and whether e has been queued.
+func (q *SendQueue) Push(e OutboxEntry) (dropped []OutboxEntry, queued bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.tracked(e.Seq) {
+		return nil, true
+	}
+	if q.len() < q.Capacity {
+		q.enqueue(e)
+		return nil, true
+	}
+
+	switch {
+	case q.Policy == DropOldest && len(q.queued) > 0:
+		dropped = append(dropped, q.queued[0])
+		q.queued = q.queued[1:]
+		q.enqueue(e)
+		return dropped, true
+	case q.Policy == SpillToDisk && !isMemoryOnly(e.Seq):
+		return nil, false
+	default:
+		return []OutboxEntry{e}, false
+	}
+}
+
+// Offer queues the entry only if there is room and it isn't queued yet.
+// It reports whether there was room.
+func (q *SendQueue) Offer(e OutboxEntry) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.tracked(e.Seq) {
+		return true
+	}
+	if q.len() >= q.Capacity {
+		return false
+	}
+	q.enqueue(e)
+	return true
+}
+
+// Pop waits for the queued entries and returns up to max of them marking
+// them as being sent. The entries returned follow each other, so that none
+// of the entries being sent by the others goes in between them. It returns
+// an error once ctx is done.
+func (q *SendQueue) Pop(ctx context.Context, max int) ([]OutboxEntry, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		q.mu.Lock()
+		if n := q.batch(max); n > 0 {
+			entries := make([]OutboxEntry, n)
+			copy(entries, q.queued)
+			q.queued = q.queued[n:]
+			for _, e := range entries {
+				q.inFlight[e.Seq] = true
+			}
+			if len(q.queued) > 0 {
+				notify(q.ready)
+			}
+			q.mu.Unlock()
+			return entries, nil
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Requeue returns the entries that haven't been sent to the queue in the
+// order of their sequence numbers.
+func (q *SendQueue) Requeue(entries []OutboxEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	q.mu.Lock()
+	for _, e := range entries {
+		if q.inFlight[e.Seq] {
+			delete(q.inFlight, e.Seq)
+			delete(q.sent, e.Seq)
+			q.insert(e)
+		}
+	}
+	q.mu.Unlock()
+	notify(q.ready)
+}
+
+// Done releases the entry that has been sent or dropped.
+func (q *SendQueue) Done(seq uint64) {
+	q.mu.Lock()
+	delete(q.inFlight, seq)
+	delete(q.sent, seq)
+	q.changed()
+	q.mu.Unlock()
+	notify(q.room)
+}
+
+// Sent marks the entry being sent as written to the center, so it's the
+// turn of the entries after it.
+func (q *SendQueue) Sent(seq uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.inFlight[seq] {
+		q.sent[seq] = true
+		q.changed()
+	}
+}
+
+// Unsent marks the entry that has been written to the center but hasn't
+// been acked as not sent, so the entries after it wait until it's sent again.
+func (q *SendQueue) Unsent(seq uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.sent, seq)
+}
+
+// IsTurn reports whether all the entries before seq have been sent.
+func (q *SendQueue) IsTurn(seq uint64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.isTurn(seq, true) == nil
+}
+
+// WaitTurn waits until all the entries being sent before seq have been sent.
+// It returns errNotTurn if an entry before seq is queued, since it has to
+// be taken from the queue before seq can be sent, or an error once ctx is done.
+func (q *SendQueue) WaitTurn(ctx context.Context, seq uint64) error {
+	return q.wait(ctx, seq, true)
+}
+
+// WaitDone works like WaitTurn but waits until the entries before seq have
+// been released, since a sent entry that hasn't been acked may have to be
+// sent again.
+func (q *SendQueue) WaitDone(ctx context.Context, seq uint64) error {
+	return q.wait(ctx, seq, false)
+}
+
+func (q *SendQueue) wait(ctx context.Context, seq uint64, sent bool) error {
+	for {
+		q.mu.Lock()
+		err := q.isTurn(seq, sent)
+		turn := q.turn
+		q.mu.Unlock()
+
+		switch {
+		case err == nil:
+			return nil
+		case err == errNotTurn:
+			return err
+		}
+
+		select {
+		case <-turn:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// WaitIdle waits until none of the entries is being sent or ctx is done.
+func (q *SendQueue) WaitIdle(ctx context.Context) error {
+	for {
+		q.mu.Lock()
+		n := len(q.inFlight)
+		turn := q.turn
+		q.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-turn:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Room returns the channel that is notified when an entry has been released.
+func (q *SendQueue) Room() <-chan struct{} {
+	return q.room
+}
+
+// Len returns the number of the entries that are queued or being sent.
+func (q *SendQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.len()
+}
+
+// Free returns the number of the entries that can be queued until the
+// queue is full.
+func (q *SendQueue) Free() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if n := q.Capacity - q.len(); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// InFlight returns the number of the entries that are being sent.
+func (q *SendQueue) InFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.inFlight)
+}
+
+// TakeMemoryOnly marks all the queued entries as being sent, so that they
+// don't wait for each other's turn, and returns the ones that aren't stored
+// in the outbox.
+func (q *SendQueue) TakeMemoryOnly() []OutboxEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var entries []OutboxEntry
+	for _, e := range q.queued {
+		q.inFlight[e.Seq] = true
+		if isMemoryOnly(e.Seq) {
+			entries = append(entries, e)
+		}
+	}
+	q.queued = nil
+	return entries
+}
+
+// errWaitTurn is returned by isTurn when an entry being sent before seq
+// hasn't been sent yet.
+var errWaitTurn = errors.New("batches before the ones being sent are being sent")
+
+// isTurn checks the entries before seq. The entries that have been sent
+// are skipped if sent is set.
+func (q *SendQueue) isTurn(seq uint64, sent bool) error {
+	if len(q.queued) > 0 && q.queued[0].Seq < seq {
+		return errNotTurn
+	}
+	for s := range q.inFlight {
+		if s < seq && !(sent && q.sent[s]) {
+			return errWaitTurn
+		}
+	}
+	return nil
+}
+
+// batch returns the number of the queued entries from the head that can be
+// taken at once: up to max of them and none after an entry being sent.
+func (q *SendQueue) batch(max int) int {
+	n := 0
+	for n < len(q.queued) && n < max {
+		if n > 0 && q.inFlightBetween(q.queued[n-1].Seq, q.queued[n].Seq) {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+func (q *SendQueue) inFlightBetween(from, to uint64) bool {
+	for s := range q.inFlight {
+		if s > from && s < to {
+			return true
+		}
+	}
+	return false
+}
+
+// changed wakes up the entries waiting for their turn.
+func (q *SendQueue) changed() {
+	close(q.turn)
+	q.turn = make(chan struct{})
+}
+
+func (q *SendQueue) len() int {
+	return len(q.queued) + len(q.inFlight)
+}
+
+func (q *SendQueue) tracked(seq uint64) bool {
+	if q.inFlight[seq] {
+		return true
+	}
+	for _, e := range q.queued {
+		if e.Seq == seq {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *SendQueue) enqueue(e OutboxEntry) {
+	q.insert(e)
+	notify(q.ready)
+}
+
+// insert puts the entry to the queue keeping the queue ordered by sequence numbers.
+func (q *SendQueue) insert(e OutboxEntry) {
+	i := sort.Search(len(q.queued), func(i int) bool { return q.queued[i].Seq > e.Seq })
+	q.queued = append(q.queued, OutboxEntry{})
+	copy(q.queued[i+1:], q.queued[i:])
+	q.queued[i] = e
+	q.changed()
+}
+
+func isMemoryOnly(seq uint64) bool {
+	return seq >= memorySeqBase
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/entities"
+)
+
+func TestSendQueueKeepsOrder(t *testing.T) {
+	q := NewSendQueue(10, SpillToDisk)
+	for _, seq := range []uint64{3, 1, 4} {
+		q.Offer(OutboxEntry{Seq: seq})
+	}
+
+	entries := popAll(t, q)
+	if got, want := entrySeqs(entries), []uint64{1, 3, 4}; !equalSeqs(got, want) {
+		t.Fatalf("Pop() = %v, want %v", got, want)
+	}
+
+	q.Offer(OutboxEntry{Seq: 2})
+	q.Offer(OutboxEntry{Seq: 5})
+	q.Requeue(entries[1:])
+	if got, want := entrySeqs(popAll(t, q)), []uint64{2, 3, 4, 5}; !equalSeqs(got, want) {
+		t.Errorf("Pop() after Requeue() = %v, want %v", got, want)
+	}
+}
+
+func popAll(t *testing.T, q *SendQueue) []OutboxEntry {
+	entries, err := q.Pop(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestSendQueueTurn(t *testing.T) {
+	q := NewSendQueue(10, SpillToDisk)
+	for _, seq := range []uint64{1, 2, 3, 4} {
+		q.Offer(OutboxEntry{Seq: seq})
+	}
+	if _, err := q.Pop(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	second, err := q.Pop(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		// prepare changes the state of the queue before the checks of seq.
+		prepare  func()
+		seq      uint64
+		wantTurn bool
+		wantDone bool
+	}{
+		{name: "first", prepare: func() {}, seq: 1, wantTurn: true, wantDone: true},
+		{name: "before sent", prepare: func() {}, seq: 2},
+		{name: "sent", prepare: func() { q.Sent(1) }, seq: 2, wantTurn: true},
+		{name: "unsent", prepare: func() { q.Unsent(1) }, seq: 2},
+		{name: "done", prepare: func() { q.Done(1) }, seq: 2, wantTurn: true, wantDone: true},
+		{name: "requeued", prepare: func() { q.Requeue(second) }, seq: 3},
+	}
+
+	for _, tt := range tests {
+		tt.prepare()
+		if got := q.IsTurn(tt.seq); got != tt.wantTurn {
+			t.Errorf("%s: IsTurn(%d) = %t, want %t", tt.name, tt.seq, got, tt.wantTurn)
+		}
+		if err := q.WaitTurn(ctx, tt.seq); (err == nil) != tt.wantTurn {
+			t.Errorf("%s: WaitTurn(%d) = %v", tt.name, tt.seq, err)
+		}
+		if err := q.WaitDone(ctx, tt.seq); (err == nil) != tt.wantDone {
+			t.Errorf("%s: WaitDone(%d) = %v", tt.name, tt.seq, err)
+		}
+	}
+	if err := q.WaitTurn(context.Background(), 3); err != errNotTurn {
+		t.Errorf("WaitTurn() with a batch before it queued = %v, want %v", err, errNotTurn)
+	}
+}
+
+func TestSendQueuePopsAdjacent(t *testing.T) {
+	q := NewSendQueue(10, SpillToDisk)
+	for _, seq := range []uint64{1, 2, 3, 4} {
+		q.Offer(OutboxEntry{Seq: seq})
+	}
+	entries := popAll(t, q)
+	// 3 is being sent by another worker while 2 and 4 are sent again
+	q.Requeue([]OutboxEntry{entries[1], entries[3]})
+	q.Done(1)
+
+	if got, want := entrySeqs(popAll(t, q)), []uint64{2}; !equalSeqs(got, want) {
+		t.Errorf("Pop() = %v, want %v", got, want)
+	}
+	if got, want := entrySeqs(popAll(t, q)), []uint64{4}; !equalSeqs(got, want) {
+		t.Errorf("Pop() = %v, want %v", got, want)
+	}
+}
+
+// overflowTest is used to persist the batches of DataService to the outbox
+// and the send queue that nothing takes the batches from.
+type overflowTest struct {
+	t   *testing.T
+	s   *DataService
+	dir string
+}
+
+func newOverflowTest(t *testing.T, capacity int, p OverflowPolicy) *overflowTest {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox, err := NewOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := logrus.New()
+	l.Out = ioutil.Discard
+	meta := &entities.DevMeta{Type: "fridge", Name: "fridge-test", MAC: "0A-1B-2C-3D-4E-5F"}
+	s := NewDataService(&Configuration{SubsPool: make(map[string]chan struct{})}, meta, entities.Server{}, nil,
+		outbox, NewSendQueue(capacity, p), 1, nil, l, time.Second, time.Second)
+	return &overflowTest{t: t, s: s, dir: dir}
+}
+
+func (ot *overflowTest) persist(n int) {
+	for i := 0; i < n; i++ {
+		ot.s.persist(SaveFridgeDataRequest{Meta: *ot.s.Meta})
+	}
+}
+
+// popAll takes the queued batches as the sender does.
+func (ot *overflowTest) popAll() []uint64 {
+	entries, err := ot.s.SendQueue.Pop(context.Background(), 10)
+	if err != nil {
+		ot.t.Fatal(err)
+	}
+	return entrySeqs(entries)
+}
+
+func (ot *overflowTest) outboxSeqs() []uint64 {
+	entries, err := ot.s.Outbox.Pending(0, 0)
+	if err != nil {
+		ot.t.Fatal(err)
+	}
+	return entrySeqs(entries)
+}
+
+func TestSendQueueOverflow(t *testing.T) {
+	tests := []struct {
+		policy OverflowPolicy
+		// queued and outbox are the batches expected in the queue and in
+		// the outbox after 3 batches have been persisted to the queue of 2.
+		queued  []uint64
+		outbox  []uint64
+		dropped float64
+		spilled float64
+	}{
+		{policy: DropOldest, queued: []uint64{2, 3}, outbox: []uint64{2, 3}, dropped: 1},
+		{policy: DropNewest, queued: []uint64{1, 2}, outbox: []uint64{1, 2}, dropped: 1},
+		{policy: SpillToDisk, queued: []uint64{1, 2}, outbox: []uint64{1, 2, 3}, spilled: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			ot := newOverflowTest(t, 2, tt.policy)
+			defer os.RemoveAll(ot.dir)
+
+			dropped := batchesDroppedTotal.Value(string(tt.policy))
+			spilled := batchesSpilledTotal.Value()
+			ot.persist(3)
+
+			if got := ot.popAll(); !equalSeqs(got, tt.queued) {
+				t.Errorf("queued batches = %v, want %v", got, tt.queued)
+			}
+			if got := ot.outboxSeqs(); !equalSeqs(got, tt.outbox) {
+				t.Errorf("batches in the outbox = %v, want %v", got, tt.outbox)
+			}
+			if got := batchesDroppedTotal.Value(string(tt.policy)) - dropped; got != tt.dropped {
+				t.Errorf("fridgems_batches_dropped_total{policy=%q} has grown by %v, want %v", tt.policy, got, tt.dropped)
+			}
+			if got := batchesSpilledTotal.Value() - spilled; got != tt.spilled {
+				t.Errorf("fridgems_batches_spilled_total has grown by %v, want %v", got, tt.spilled)
+			}
+		})
+	}
+}
+
+func TestSpilledBatchesAreReplayed(t *testing.T) {
+	ot := newOverflowTest(t, 2, SpillToDisk)
+	defer os.RemoveAll(ot.dir)
+
+	ot.persist(5)
+	for _, want := range [][]uint64{{1, 2}, {3, 4}, {5}} {
+		got := ot.popAll()
+		if !equalSeqs(got, want) {
+			t.Fatalf("queued batches = %v, want %v", got, want)
+		}
+		// the batches have been sent, the replayer refills the queue
+		for _, seq := range got {
+			ot.s.removeFromOutbox(seq)
+		}
+		ot.s.refill()
+	}
+	if n := ot.s.Outbox.Len(); n != 0 {
+		t.Errorf("%d batch(es) are left in the outbox", n)
+	}
+}
+
+func TestSendQueueWaitIdle(t *testing.T) {
+	q := NewSendQueue(10, SpillToDisk)
+	for _, seq := range []uint64{1, 2} {
+		q.Offer(OutboxEntry{Seq: seq})
+	}
+	entries := popAll(t, q)
+
+	idle := make(chan error, 1)
+	go func() { idle <- q.WaitIdle(context.Background()) }()
+
+	q.Done(entries[0].Seq)
+	q.Requeue(entries[1:])
+	select {
+	case err := <-idle:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("WaitIdle() hasn't returned once no entry is being sent")
+	}
+
+	popAll(t, q)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := q.WaitIdle(ctx); err != context.Canceled {
+		t.Errorf("WaitIdle() with an entry being sent = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+
+	"github.com/kostiamol/fridgems/retry"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// sender is used to send the batches taken from the send queue by one of
+// the sender workers and to back off on failures. The workers send the
+// batches in the order of their sequence numbers: a worker waits until all
+// the batches before its ones have been written to the stream shared by the
+// workers, or, over unary calls, have been saved.
+type sender struct {
+	s       *DataService
+	backoff *retry.Backoff
+}
+
+func (s *DataService) newSender() *sender {
+	return &sender{
+		s:       s,
+		backoff: newBackoff(s.Clock, s.RetryInterval),
+	}
+}
+
+// run takes up to streamWindow batches from the send queue at a time and
+// sends them to the center once it's their turn. The batches that haven't been delivered are
+// returned to the queue and the worker backs off up to RetryInterval, so the
+// number of the batches being sent never exceeds the capacity of the queue.
+func (snd *sender) run(ctx context.Context) error {
+	for {
+		entries, err := snd.s.SendQueue.Pop(ctx, streamWindow)
+		if err != nil {
+			return nil
+		}
+
+		if err := snd.s.SendQueue.WaitTurn(ctx, entries[0].Seq); err != nil {
+			// the batches before these ones are taken from the queue first
+			snd.s.SendQueue.Requeue(entries)
+			continue
+		}
+
+		conn := snd.s.getConn()
+		sent := snd.send(ctx, conn, entries)
+		if sent == len(entries) {
+			snd.backoff.Reset()
+			continue
+		}
+		snd.s.SendQueue.Requeue(entries[sent:])
+		if snd.backOff(ctx, conn); ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// backOff waits for the next delay of the backoff. If the connection to the
+// center isn't ready, it stops waiting as soon as the connection has been restored.
+func (snd *sender) backOff(ctx context.Context, conn *grpc.ClientConn) {
+	d, _ := snd.backoff.Next()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	t := snd.s.Clock.AfterFunc(d, cancel)
+	defer t.Stop()
+
+	if conn == nil || conn.GetState() == connectivity.Ready {
+		<-ctx.Done()
+		return
+	}
+	for st := conn.GetState(); st != connectivity.Ready; st = conn.GetState() {
+		if !conn.WaitForStateChange(ctx, st) {
+			return
+		}
+	}
+}
+
+// send sends the entries in order and removes each of them from the outbox
+// once the center has saved it. The entries are streamed unless the center
+// doesn't support streaming. It stops at the first failure and returns the
+// number of the entries that have been delivered.
+func (snd *sender) send(ctx context.Context, conn *grpc.ClientConn, entries []OutboxEntry) int {
+	if conn == nil || len(entries) == 0 {
+		return 0
+	}
+
+	sent := 0
+	if !snd.s.isStreamUnsupported() {
+		n, err := snd.streamBatches(ctx, conn, entries)
+		if err == nil || !snd.s.isStreamUnsupported() {
+			return n
+		}
+		sent = n
+	}
+
+	if err := snd.s.SendQueue.WaitDone(ctx, entries[sent].Seq); err != nil {
+		return sent
+	}
+	for _, e := range entries[sent:] {
+		snd.s.countAttempt(e.Seq)
+		if err := snd.s.saveFridgeData(ctx, e.Req, conn); err != nil {
+			snd.s.Log.Errorf("DataService: send(): %d batch(es) haven't been sent", len(entries)-sent)
+			return sent
+		}
+		snd.s.removeFromOutbox(e.Seq)
+		sent++
+	}
+	return sent
+}
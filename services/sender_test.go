@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/api/pb"
+	"github.com/kostiamol/fridgems/centertest"
+	"github.com/kostiamol/fridgems/entities"
+)
+
+// senderTest is used to send the batches of DataService to the test center.
+type senderTest struct {
+	t      *testing.T
+	s      *DataService
+	center *centertest.Center
+	dir    string
+}
+
+func startSenderTest(ctx context.Context, t *testing.T, streaming bool, workers int) *senderTest {
+	dir, err := ioutil.TempDir("", "sender")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := centertest.NewCenter()
+	c.DisableStreaming(!streaming)
+	c.SetLatency(time.Millisecond * 2)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	l := logrus.New()
+	l.Out = ioutil.Discard
+
+	outbox, err := NewOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Configuration{SubsPool: make(map[string]chan struct{})}
+	config.SetPayloadFormat(api.PayloadFormat_PROTO)
+	meta := &entities.DevMeta{Type: "fridge", Name: "fridge-test", MAC: "0A-1B-2C-3D-4E-5F"}
+	s := NewDataService(config, meta, c.Server(), nil, outbox, NewSendQueue(64, DropNewest),
+		workers, nil, l, time.Millisecond*20, time.Second)
+
+	conn, err := dial(ctx, c.Server(), l, newBackoff(s.Clock, s.RetryInterval))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.setConn(conn)
+	return &senderTest{t: t, s: s, center: c, dir: dir}
+}
+
+func (st *senderTest) stop() {
+	st.center.Stop()
+	os.RemoveAll(st.dir)
+}
+
+// persist persists n batches identified by the time of their reading.
+func (st *senderTest) persist(n int) {
+	for i := int64(1); i <= int64(n); i++ {
+		st.s.persist(SaveFridgeDataRequest{
+			Meta: *st.s.Meta,
+			Data: FridgeData{Compartments: []CompartmentData{
+				{Name: "TopCompart", Unit: UnitCelsius, Samples: []Sample{{Time: i, Temp: 4}}},
+			}},
+		})
+	}
+}
+
+// checkSaved checks that n batches have been saved once each and in order.
+func (st *senderTest) checkSaved(ctx context.Context, n int) {
+	saved, err := st.center.WaitSaved(ctx, n)
+	if err != nil {
+		st.t.Fatalf("batches haven't been saved: %s", err)
+	}
+	for i, r := range saved {
+		if got := r.TypedData.Compartments[0].Readings[0].Time; got != int64(i+1) {
+			st.t.Fatalf("batch %d has been saved as the batch %d", got, i+1)
+		}
+	}
+}
+
+func TestSendWorkersKeepOrder(t *testing.T) {
+	const batches = 40
+
+	tests := []struct {
+		name      string
+		streaming bool
+		workers   int
+		// method is the method the batches are expected to be saved with.
+		method string
+	}{
+		{name: "streaming, one worker", streaming: true, workers: 1, method: centertest.MethodStreamDevData},
+		{name: "streaming, four workers", streaming: true, workers: 4, method: centertest.MethodStreamDevData},
+		{name: "SaveDevData, one worker", streaming: false, workers: 1, method: centertest.MethodSaveDevData},
+		{name: "SaveDevData, four workers", streaming: false, workers: 4, method: centertest.MethodSaveDevData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+			defer cancel()
+
+			st := startSenderTest(ctx, t, tt.streaming, tt.workers)
+			defer st.stop()
+
+			// the failures make the workers send the batches again
+			st.center.FailNext(tt.method, centertest.ErrUnavailable, centertest.ErrUnavailable)
+			for i := 0; i < tt.workers; i++ {
+				go st.s.newSender().run(ctx)
+			}
+			st.persist(batches)
+			st.checkSaved(ctx, batches)
+		})
+	}
+}
+
+func TestShutdownFlushesInOrder(t *testing.T) {
+	// more than one stream window is left
+	const batches = streamWindow*2 + 3
+
+	for _, streaming := range []bool{true, false} {
+		ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+		defer cancel()
+
+		st := startSenderTest(ctx, t, streaming, 1)
+		defer st.stop()
+
+		st.persist(batches)
+		close(st.s.persisted)
+		st.s.shutdown()
+		st.checkSaved(ctx, batches)
+		if n := st.s.Outbox.Len(); n != 0 {
+			t.Errorf("streaming %t: %d batch(es) are left in the outbox", streaming, n)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package services
+
+// Quality is used to mark whether a reading can be trusted.
+type Quality string
+
+// Qualities of the readings.
+const (
+	// QualityGood marks the readings within the bounds of the compartment.
+	QualityGood Quality = "good"
+	// QualityBad marks the readings out of the bounds of the compartment,
+	// which are likely to be sensor faults.
+	QualityBad Quality = "bad"
+)
+
+// Sample is used to store a reading of a compartment: unix timestamp
+// in milliseconds, temperature in Unit and its Quality.
+type Sample struct {
+	Time    int64
+	Temp    float32
+	Unit    string
+	Quality Quality
+}
+
+// legacyFridgeData is used to encode FridgeData in the format of the
+// centers that only support the JSON payload: an object with a map of unix
+// timestamps in milliseconds to temperatures per compartment, keyed by the
+// compartment name, e.g. {"TopCompart":{"1543846800000":4.2},"BotCompart":{...}}.
+type legacyFridgeData map[string]map[int64]float32
+
+// toLegacyFridgeData converts FridgeData to the legacy format. The format
+// has neither units nor qualities, so the temperatures are sent in the units
+// of the compartments and the bad samples aren't sent at all; they are only
+// delivered to the centers supporting the protobuf payload. The later of
+// the samples taken in the same millisecond wins.
+func toLegacyFridgeData(d FridgeData) legacyFridgeData {
+	ld := make(legacyFridgeData, len(d.Compartments))
+	for _, c := range d.Compartments {
+		temps := make(map[int64]float32, len(c.Samples))
+		for _, s := range c.Samples {
+			if s.Quality == QualityGood {
+				temps[s.Time] = s.Temp
+			}
+		}
+		ld[c.Name] = temps
+	}
+	return ld
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLegacyFridgeData(t *testing.T) {
+	d := FridgeData{
+		Compartments: []CompartmentData{
+			{
+				Name: "TopCompart",
+				Unit: "C",
+				Samples: []Sample{
+					{Time: 1543846800000, Temp: 4.2, Unit: "C", Quality: QualityGood},
+					{Time: 1543846801000, Temp: 35, Unit: "C", Quality: QualityBad},
+					{Time: 1543846802000, Temp: 4.5, Unit: "C", Quality: QualityGood},
+				},
+			},
+			{
+				Name: "BotCompart",
+				Unit: "C",
+				Samples: []Sample{
+					{Time: 1543846800000, Temp: -18.5, Unit: "C", Quality: QualityGood},
+				},
+			},
+		},
+	}
+	const golden = `{"BotCompart":{"1543846800000":-18.5},"TopCompart":{"1543846800000":4.2,"1543846802000":4.5}}` + "\n"
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(toLegacyFridgeData(d)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != golden {
+		t.Errorf("legacy FridgeData = %s, want %s", buf.String(), golden)
+	}
+
+	// the centers supporting only the JSON payload decode it this way
+	var old struct {
+		TopCompart map[int64]float32
+		BotCompart map[int64]float32
+	}
+	if err := json.Unmarshal(buf.Bytes(), &old); err != nil {
+		t.Fatal(err)
+	}
+	wantTop := map[int64]float32{1543846800000: 4.2, 1543846802000: 4.5}
+	if !reflect.DeepEqual(old.TopCompart, wantTop) {
+		t.Errorf("TopCompart = %v, want %v", old.TopCompart, wantTop)
+	}
+	wantBot := map[int64]float32{1543846800000: -18.5}
+	if !reflect.DeepEqual(old.BotCompart, wantBot) {
+		t.Errorf("BotCompart = %v, want %v", old.BotCompart, wantBot)
+	}
+}
+
+func TestLegacyFridgeDataOfEmptyCompartment(t *testing.T) {
+	d := FridgeData{Compartments: []CompartmentData{{Name: "TopCompart", Unit: "C"}}}
+	b, err := json.Marshal(toLegacyFridgeData(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"TopCompart":{}}`; string(b) != want {
+		t.Errorf("legacy FridgeData = %s, want %s", b, want)
+	}
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/kostiamol/fridgems/api/pb"
+	"github.com/kostiamol/fridgems/clock"
+	"github.com/nats-io/go-nats"
+)
+
+// Types of the events published by the device.
+const (
+	configAckEvent     = "ConfigAck"
+	reportedStateEvent = "ReportedState"
+)
+
+// newEventID returns a random ID for the published events or the current
+// time of c if no random ID can be generated.
+func newEventID(c clock.Clock) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return c.Now().Format(time.RFC3339Nano)
+	}
+	return hex.EncodeToString(b)
+}
+
+// ackPatch publishes the result of the config patch event to Config.Ack.<MAC>
+// together with the config the device runs with after it.
+func (s *ConfigService) ackPatch(conn *nats.Conn, patch *api.EventStore, result string, reason error) {
+	ack := &api.ConfigAck{
+		PatchEventId: patch.EventId,
+		PatchVersion: patch.Version,
+		Result:       result,
+		Config:       toProtoFridgeConfig(s.Config.GetFridgeConfig()),
+		Version:      s.Config.GetVersion(),
+	}
+	if reason != nil {
+		ack.Reason = reason.Error()
+	}
+
+	s.publishEvent(conn, "Config.Ack."+s.Meta.MAC, &api.EventStore{
+		EventType: configAckEvent,
+		ConfigAck: ack,
+	})
+}
+
+// publishReportedState publishes the reported state of the device every
+// StateInterval and whenever the config is patched, so that the center
+// can detect drift between the desired and the reported state.
+func (s *ConfigService) publishReportedState(ctx context.Context) error {
+	patched := make(chan struct{}, 1)
+	s.Config.Subscribe("statePublisher", patched)
+
+	ticker := s.Clock.NewTicker(s.StateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-patched:
+		case <-ctx.Done():
+			s.Log.Info("reported state publishing has stopped")
+			return nil
+		}
+
+		s.sendReportedState(s.NATSConn())
+	}
+}
+
+// sendReportedState publishes the reported state to State.Reported.<MAC>.
+// Nothing is published while NATS is disconnected, the state is sent again
+// once the connection is restored.
+func (s *ConfigService) sendReportedState(conn *nats.Conn) {
+	if conn == nil || !conn.IsConnected() {
+		return
+	}
+
+	s.publishEvent(conn, "State.Reported."+s.Meta.MAC, &api.EventStore{
+		EventType: reportedStateEvent,
+		ReportedState: &api.ReportedState{
+			Time:          s.Clock.Now().UnixNano(),
+			Config:        toProtoFridgeConfig(s.Config.GetFridgeConfig()),
+			ConfigVersion: s.Config.GetVersion(),
+			PayloadFormat: s.Config.GetPayloadFormat(),
+		},
+	})
+}
+
+func (s *ConfigService) publishEvent(conn *nats.Conn, subject string, e *api.EventStore) {
+	e.AggregateId = s.Meta.MAC
+	e.AggregateType = s.Meta.Type
+	e.EventId = newEventID(s.Clock)
+
+	b, err := proto.Marshal(e)
+	if err != nil {
+		s.Log.Errorf("ConfigService: publishEvent(): Marshal() has failed: %s", err)
+		return
+	}
+	if err := conn.Publish(subject, b); err != nil {
+		s.Log.Errorf("ConfigService: publishEvent(): Publish() has failed: %s", err)
+	}
+}
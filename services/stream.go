@@ -0,0 +1,340 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/kostiamol/fridgems/api/pb"
+	"github.com/kostiamol/fridgems/breaker"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// streamWindow limits the number of batches a sender worker sends to
+	// the center without an ack.
+	streamWindow = 8
+	// streamAckTimeout limits the time the center has to ack a batch
+	// before the stream is considered to be broken.
+	streamAckTimeout = time.Second * 30
+)
+
+// errStreamClosed is the error of the stream closed on shutdown.
+var errStreamClosed = errors.New("stream has been closed")
+
+// dataStream is used to store the long-lived StreamDevData stream shared by
+// the sender workers together with the function that cancels it. The workers
+// write their batches to the stream in turn, in the order of the sequence
+// numbers. The center acks the batches in the same order, the acks are read
+// by the receiver of the stream and handed over to the workers waiting for them.
+type dataStream struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	client  api.CenterService_StreamDevDataClient
+	queue   *SendQueue
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	unacked []uint64
+	acks    map[uint64]chan error
+	err     error
+	// received is closed once the receiver has stopped.
+	received chan struct{}
+	// reported is used to report the failure of the stream only once,
+	// however many workers have been sending over it.
+	reported sync.Once
+}
+
+// streamBatches pushes the entries to the center over the shared stream
+// up to streamWindow at a time and removes each of them from the outbox once
+// the center has acked it. On any failure the stream is reset. The time from
+// sending a batch to its ack is observed as its save latency. It returns
+// the number of the entries that have been acked.
+func (snd *sender) streamBatches(ctx context.Context, conn *grpc.ClientConn, entries []OutboxEntry) (int, error) {
+	acked := 0
+	for acked < len(entries) {
+		window := entries[acked:]
+		if len(window) > streamWindow {
+			window = window[:streamWindow]
+		}
+
+		n, err := snd.sendWindow(ctx, conn, window)
+		acked += n
+		if err != nil {
+			return acked, err
+		}
+	}
+
+	snd.s.Log.Infof("center has acked %d FridgeData batch(es)", acked)
+	return acked, nil
+}
+
+// sendWindow writes the entries to the stream once all the batches before
+// them have been written and waits for their acks.
+func (snd *sender) sendWindow(ctx context.Context, conn *grpc.ClientConn, entries []OutboxEntry) (int, error) {
+	s := snd.s
+
+	var (
+		st     *dataStream
+		sentAt []time.Time
+		acks   []chan error
+		err    error
+	)
+	for {
+		if err := s.SendQueue.WaitTurn(ctx, entries[0].Seq); err != nil {
+			return 0, err
+		}
+		if st, err = s.openStream(ctx, conn); err != nil {
+			return 0, snd.streamFailed(nil, err)
+		}
+		if sentAt, acks, err = snd.write(st, entries); err != errNotTurn {
+			break
+		}
+	}
+	if err != nil {
+		// the stream fails, so the acks of the entries that have been written are settled
+		err = snd.streamFailed(st, err)
+	}
+
+	for i, ack := range acks {
+		if err := snd.waitAck(ctx, st, ack); err != nil {
+			return i, snd.streamFailed(st, err)
+		}
+
+		saveDevDataDuration.Observe(s.Clock.Since(sentAt[i]).Seconds())
+		batchesSentTotal.Inc()
+		s.removeFromOutbox(entries[i].Seq)
+	}
+	if err != nil {
+		return len(acks), err
+	}
+	return len(entries), nil
+}
+
+// write writes the entries to the stream if it's still their turn. Each
+// entry is marked as sent before it's written, so the entries after it
+// can be written by the other workers once this one has finished. It
+// returns the times the entries have been sent and the channels their
+// acks are handed over to, on failure only of the entries that have been written.
+func (snd *sender) write(st *dataStream, entries []OutboxEntry) ([]time.Time, []chan error, error) {
+	s := snd.s
+	st.writeMu.Lock()
+	defer st.writeMu.Unlock()
+
+	st.mu.Lock()
+	switch {
+	case st.err != nil:
+		err := st.err
+		st.mu.Unlock()
+		return nil, nil, err
+	case !s.SendQueue.IsTurn(entries[0].Seq):
+		st.mu.Unlock()
+		return nil, nil, errNotTurn
+	}
+	st.mu.Unlock()
+
+	sentAt := make([]time.Time, 0, len(entries))
+	acks := make([]chan error, 0, len(entries))
+	for _, e := range entries {
+		req, err := s.newSaveDevDataRequest(e.Req)
+		if err != nil {
+			return sentAt, acks, err
+		}
+
+		ack := make(chan error, 1)
+		st.mu.Lock()
+		if st.err != nil {
+			err := st.err
+			st.mu.Unlock()
+			return sentAt, acks, err
+		}
+		st.acks[e.Seq] = ack
+		st.unacked = append(st.unacked, e.Seq)
+		s.SendQueue.Sent(e.Seq)
+		st.mu.Unlock()
+
+		s.countAttempt(e.Seq)
+		sentAt = append(sentAt, s.Clock.Now())
+		acks = append(acks, ack)
+		if err := st.client.Send(&api.DevDataBatch{Seq: e.Seq, Request: req}); err != nil {
+			if err == io.EOF {
+				// the stream has been aborted by the center, Recv reports why
+				<-st.received
+				err = st.failure()
+			}
+			return sentAt, acks, err
+		}
+	}
+	return sentAt, acks, nil
+}
+
+// waitAck waits for the ack no longer than streamAckTimeout.
+func (snd *sender) waitAck(ctx context.Context, st *dataStream, ack <-chan error) error {
+	t := snd.s.Clock.NewTimer(streamAckTimeout)
+	defer t.Stop()
+
+	select {
+	case err := <-ack:
+		return err
+	case <-t.C():
+		return status.Errorf(codes.DeadlineExceeded, "no ack has been received within %s", streamAckTimeout)
+	case <-ctx.Done():
+		st.fail(ctx.Err())
+		return ctx.Err()
+	}
+}
+
+// openStream returns the stream shared by the sender workers or opens a new
+// one if there is no stream, it has failed or it has been bound to a context
+// that is already done.
+func (s *DataService) openStream(ctx context.Context, conn *grpc.ClientConn) (*dataStream, error) {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+
+	if st := s.stream; st != nil {
+		if st.alive() {
+			return st, nil
+		}
+		st.fail(errStreamClosed)
+		s.stream = nil
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	client, err := newCenterClient(conn, s.Breaker).StreamDevData(streamCtx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	st := &dataStream{
+		ctx:      streamCtx,
+		cancel:   cancel,
+		client:   client,
+		queue:    s.SendQueue,
+		acks:     make(map[uint64]chan error),
+		received: make(chan struct{}),
+	}
+	go st.receive()
+	s.stream = st
+	return st, nil
+}
+
+// resetStream closes the shared stream, the next batch opens a new one.
+func (s *DataService) resetStream() {
+	s.streamMu.Lock()
+	st := s.stream
+	s.stream = nil
+	s.streamMu.Unlock()
+
+	if st != nil {
+		st.writeMu.Lock()
+		st.client.CloseSend()
+		st.writeMu.Unlock()
+		st.fail(errStreamClosed)
+	}
+}
+
+// streamFailed fails the stream, reports the failure to the breaker and
+// falls back to unary calls if the center doesn't implement streaming.
+// The failure of a stream is reported once, by the first of the workers
+// that have been sending over it.
+func (snd *sender) streamFailed(st *dataStream, err error) error {
+	if st != nil {
+		st.fail(err)
+		first := false
+		st.reported.Do(func() { first = true })
+		if !first {
+			return err
+		}
+	}
+	if err == errStreamClosed || err == context.Canceled {
+		return err
+	}
+
+	if err == breaker.ErrOpen {
+		snd.s.Log.Errorf("DataService: streamBatches(): StreamDevData() hasn't been called: %s", err)
+		return err
+	}
+	reportCenterCall(snd.s.Breaker, err)
+
+	if status.Code(err) == codes.Unimplemented {
+		if !snd.s.setStreamUnsupported() {
+			snd.s.Log.Info("center doesn't support data streaming, falling back to SaveDevData")
+		}
+		return err
+	}
+	batchesFailedTotal.Inc()
+	snd.s.Log.Errorf("DataService: streamBatches(): %s", err)
+	return err
+}
+
+// receive reads the acks and hands each of them over to the worker waiting
+// for it until the stream fails. The acks are expected in the order the
+// batches have been sent.
+func (st *dataStream) receive() {
+	defer close(st.received)
+
+	for {
+		ack, err := st.client.Recv()
+		if err != nil {
+			st.fail(err)
+			return
+		}
+
+		st.mu.Lock()
+		if len(st.unacked) == 0 || ack.Seq != st.unacked[0] {
+			expected := "none"
+			if len(st.unacked) > 0 {
+				expected = fmt.Sprint(st.unacked[0])
+			}
+			st.mu.Unlock()
+			st.fail(fmt.Errorf("ack for batch %d has been received instead of %s", ack.Seq, expected))
+			return
+		}
+		if ack.Error != "" {
+			st.mu.Unlock()
+			st.fail(fmt.Errorf("center has rejected batch %d: %s", ack.Seq, ack.Error))
+			return
+		}
+		st.unacked = st.unacked[1:]
+		st.acks[ack.Seq] <- nil
+		delete(st.acks, ack.Seq)
+		st.mu.Unlock()
+	}
+}
+
+// fail cancels the stream and hands err over to the workers waiting for the
+// acks. The batches that haven't been acked are marked as unsent, so the
+// batches after them wait until they are sent again.
+func (st *dataStream) fail(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.err != nil {
+		return
+	}
+	st.err = err
+	st.cancel()
+	for _, seq := range st.unacked {
+		st.queue.Unsent(seq)
+		st.acks[seq] <- err
+		delete(st.acks, seq)
+	}
+	st.unacked = nil
+}
+
+func (st *dataStream) failure() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.err
+}
+
+func (st *dataStream) alive() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.err == nil && st.ctx.Err() == nil
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/kostiamol/fridgems/entities"
+)
+
+// CenterTLS is used to store settings of the TLS connection to the center.
+// Enabled      enables TLS even if none of the files is specified.
+// CACert       file with CA certificates to verify the center.
+// ClientCert   device certificate for mutual TLS issued for the device MAC.
+// ClientKey    file with the key of the device certificate.
+// ServerName   name to verify the center certificate against.
+// PinnedSHA256 hex encoded SHA-256 fingerprint of the center certificate.
+type CenterTLS struct {
+	Enabled      bool   `yaml:"enabled"`
+	CACert       string `yaml:"ca_cert"`
+	ClientCert   string `yaml:"client_cert"`
+	ClientKey    string `yaml:"client_key"`
+	ServerName   string `yaml:"server_name"`
+	PinnedSHA256 string `yaml:"pinned_sha256"`
+}
+
+// NewCenterTLSConfig builds the TLS configuration of the connection to the
+// center for the device with the given MAC.
+// It returns nil if TLS isn't enabled.
+func NewCenterTLSConfig(c CenterTLS, mac string) (*tls.Config, error) {
+	if !c.Enabled && c.CACert == "" && c.ClientCert == "" && c.PinnedSHA256 == "" {
+		return nil, nil
+	}
+
+	conf := &tls.Config{
+		ServerName: c.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if c.CACert != "" {
+		pem, err := ioutil.ReadFile(c.CACert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no CA certificates found in %s", c.CACert)
+		}
+		conf.RootCAs = pool
+	}
+
+	if c.ClientCert != "" || c.ClientKey != "" {
+		if c.ClientCert == "" || c.ClientKey == "" {
+			return nil, errors.New("both client certificate and key must be specified")
+		}
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkCertSubject(cert, mac); err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.PinnedSHA256 != "" {
+		pin, err := hex.DecodeString(strings.Replace(c.PinnedSHA256, ":", "", -1))
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 fingerprint: %q", c.PinnedSHA256)
+		}
+		// the pinned certificate is trusted even if it's self-signed, unless
+		// the CA to verify the chain against is specified explicitly
+		conf.InsecureSkipVerify = c.CACert == ""
+		conf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("center hasn't presented a certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if !bytes.Equal(sum[:], pin) {
+				return fmt.Errorf("center certificate fingerprint %x doesn't match the pinned one", sum)
+			}
+			return nil
+		}
+	}
+
+	return conf, nil
+}
+
+// checkCertSubject checks that the certificate has been issued for the device.
+func checkCertSubject(cert tls.Certificate, mac string) error {
+	if len(cert.Certificate) == 0 {
+		return errors.New("client certificate is empty")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+
+	cn, err := entities.NormalizeMAC(leaf.Subject.CommonName)
+	if want, _ := entities.NormalizeMAC(mac); err != nil || cn != want {
+		return fmt.Errorf("client certificate has been issued for %q, not for the device MAC %q",
+			leaf.Subject.CommonName, mac)
+	}
+	return nil
+}
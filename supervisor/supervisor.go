@@ -0,0 +1,260 @@
+// Package supervisor provides a tree of supervisors that own long-running
+// workers via context.Context, restart them with backoff when they panic
+// or fail and report their health.
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/clock"
+	"github.com/kostiamol/fridgems/retry"
+)
+
+const (
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+// Worker states reported by Health.
+const (
+	StateRunning    = "running"
+	StateRestarting = "restarting"
+	StateFinished   = "finished"
+	StateStopped    = "stopped"
+)
+
+// Worker is a long-running function owned by a supervisor. It must return
+// once ctx is done. A worker that returns nil before ctx is done is
+// considered to be finished, e.g. a one-shot initialization, while a worker
+// that panics or returns an error before ctx is done is restarted after
+// a backoff.
+type Worker func(ctx context.Context) error
+
+// WorkerHealth is used to store the state of a worker: its full name
+// in the tree, state, number of restarts, the last failure and the time
+// the worker has entered the current state.
+type WorkerHealth struct {
+	Name      string
+	State     string
+	Restarts  int
+	LastError string
+	Since     time.Time
+}
+
+// Supervisor is used to run workers and child supervisors bound to a
+// common context. Stopping a supervisor cancels the context of all its
+// workers and children. The restart backoff and the times reported by
+// Health are driven by Clock, which the children inherit.
+type Supervisor struct {
+	Name       string
+	Log        *logrus.Logger
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Clock      clock.Clock
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	parent  *Supervisor
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+	workers map[string]*WorkerHealth
+	kids    []*Supervisor
+}
+
+// New creates and initializes new root Supervisor object bound to ctx.
+// It returns initialized object.
+func New(ctx context.Context, l *logrus.Logger) *Supervisor {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Supervisor{
+		Log:        l,
+		MinBackoff: defaultMinBackoff,
+		MaxBackoff: defaultMaxBackoff,
+		Clock:      clock.Real,
+		ctx:        ctx,
+		cancel:     cancel,
+		workers:    make(map[string]*WorkerHealth),
+	}
+}
+
+// Child creates a supervisor that is stopped together with s, but can
+// also be stopped on its own. Its workers are reported by s.Health and
+// waited by s.Wait.
+func (s *Supervisor) Child(name string) *Supervisor {
+	ctx, cancel := context.WithCancel(s.ctx)
+	c := &Supervisor{
+		Name:       s.fullName(name),
+		Log:        s.Log,
+		MinBackoff: s.MinBackoff,
+		MaxBackoff: s.MaxBackoff,
+		Clock:      s.Clock,
+		ctx:        ctx,
+		cancel:     cancel,
+		parent:     s,
+		workers:    make(map[string]*WorkerHealth),
+	}
+
+	s.mu.Lock()
+	s.kids = append(s.kids, c)
+	s.mu.Unlock()
+	return c
+}
+
+// Context returns the context the workers of the supervisor are bound to.
+func (s *Supervisor) Context() context.Context {
+	return s.ctx
+}
+
+// Done returns a channel that is closed once the supervisor is stopped.
+func (s *Supervisor) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Stop cancels the context of all the workers and children.
+func (s *Supervisor) Stop() {
+	s.cancel()
+}
+
+// Go runs the worker in a new goroutine and restarts it with exponential
+// backoff with full jitter from MinBackoff up to MaxBackoff until it returns
+// nil or the supervisor is stopped.
+func (s *Supervisor) Go(name string, w Worker) {
+	h := &WorkerHealth{
+		Name:  s.fullName(name),
+		State: StateRunning,
+		Since: s.Clock.Now(),
+	}
+
+	s.mu.Lock()
+	s.workers[h.Name] = h
+	s.mu.Unlock()
+
+	s.add(1)
+	go func() {
+		defer s.add(-1)
+		s.supervise(h, w)
+	}()
+}
+
+// Wait waits until all the workers of the supervisor and its children
+// have exited.
+func (s *Supervisor) Wait() {
+	s.wg.Wait()
+}
+
+// WaitTimeout waits for the workers like Wait, but no longer than timeout
+// of the real time, since it bounds the shutdown whatever Clock is.
+// It reports whether all the workers have exited in time.
+func (s *Supervisor) WaitTimeout(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Health returns the health of all the workers of the supervisor and its
+// children sorted by name.
+func (s *Supervisor) Health() []WorkerHealth {
+	s.mu.RLock()
+	hs := make([]WorkerHealth, 0, len(s.workers))
+	for _, h := range s.workers {
+		hs = append(hs, *h)
+	}
+	kids := s.kids
+	s.mu.RUnlock()
+
+	for _, c := range kids {
+		hs = append(hs, c.Health()...)
+	}
+
+	sort.Slice(hs, func(i, j int) bool { return hs[i].Name < hs[j].Name })
+	return hs
+}
+
+func (s *Supervisor) supervise(h *WorkerHealth, w Worker) {
+	b := retry.NewBackoff(s.MinBackoff, s.MaxBackoff)
+	b.Clock = s.Clock
+	for {
+		started := s.Clock.Now()
+		err := s.run(w)
+		if s.ctx.Err() != nil {
+			s.setState(h, StateStopped, err)
+			return
+		}
+		if err == nil {
+			s.setState(h, StateFinished, nil)
+			return
+		}
+
+		// a worker that has been running long enough is considered healthy,
+		// so its next failure is restarted quickly again
+		if s.Clock.Since(started) > s.MaxBackoff {
+			b.Reset()
+		}
+
+		d, _ := b.Next()
+		s.Log.Errorf("Supervisor: %s has failed, restarting in %s: %s", h.Name, d, err)
+		s.setState(h, StateRestarting, err)
+
+		t := s.Clock.NewTimer(d)
+		select {
+		case <-t.C():
+		case <-s.ctx.Done():
+			t.Stop()
+			s.setState(h, StateStopped, nil)
+			return
+		}
+
+		s.mu.Lock()
+		h.Restarts++
+		s.mu.Unlock()
+		s.setState(h, StateRunning, nil)
+	}
+}
+
+func (s *Supervisor) run(w Worker) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.Log.Debugf("Supervisor: panic stack: %s", debug.Stack())
+			err = fmt.Errorf("panic(): %v", r)
+		}
+	}()
+	return w(s.ctx)
+}
+
+func (s *Supervisor) setState(h *WorkerHealth, state string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h.State = state
+	h.Since = s.Clock.Now()
+	if err != nil {
+		h.LastError = err.Error()
+	}
+}
+
+func (s *Supervisor) add(delta int) {
+	for p := s; p != nil; p = p.parent {
+		p.wg.Add(delta)
+	}
+}
+
+func (s *Supervisor) fullName(name string) string {
+	if s.Name == "" {
+		return name
+	}
+	return s.Name + "/" + name
+}
@@ -0,0 +1,206 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kostiamol/fridgems/clock"
+)
+
+// waitTimeout limits the real time the tests wait for the workers.
+const waitTimeout = time.Second * 5
+
+// errFailed is returned by the workers failing on purpose.
+var errFailed = errors.New("worker has failed")
+
+// worker is used to run a worker function whose every run is controlled by
+// the test: the run reports its start and returns what it's told to.
+type worker struct {
+	t       *testing.T
+	started chan struct{}
+	results chan func() error
+}
+
+func newWorker(t *testing.T) *worker {
+	return &worker{
+		t:       t,
+		started: make(chan struct{}),
+		results: make(chan func() error),
+	}
+}
+
+func (w *worker) run(ctx context.Context) error {
+	w.started <- struct{}{}
+	select {
+	case result := <-w.results:
+		return result()
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+func (w *worker) expectStart() {
+	select {
+	case <-w.started:
+	case <-time.After(waitTimeout):
+		w.t.Fatal("worker hasn't been started")
+	}
+}
+
+func (w *worker) expectNoStart() {
+	select {
+	case <-w.started:
+		w.t.Fatal("worker has been restarted too early")
+	case <-time.After(time.Millisecond * 20):
+	}
+}
+
+// finish makes the current run of the worker return result.
+func (w *worker) finish(result func() error) {
+	select {
+	case w.results <- result:
+	case <-time.After(waitTimeout):
+		w.t.Fatal("worker isn't running")
+	}
+}
+
+func fail() error {
+	return errFailed
+}
+
+func newSupervisor() (*Supervisor, *clock.Fake) {
+	l := logrus.New()
+	l.Out = ioutil.Discard
+
+	c := clock.NewFake(time.Unix(1543846800, 0))
+	s := New(context.Background(), l)
+	s.Clock = c
+	s.MinBackoff = time.Second
+	s.MaxBackoff = time.Second * 8
+	return s, c
+}
+
+// restartDelay waits for the worker to be restarting and returns the delay
+// before its restart.
+func restartDelay(t *testing.T, c *clock.Fake) time.Duration {
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+
+	if err := c.WaitForTimers(ctx, 1); err != nil {
+		t.Fatal("worker isn't waiting to be restarted")
+	}
+	return c.Deadlines()[0].Sub(c.Now())
+}
+
+func health(t *testing.T, s *Supervisor, name string) WorkerHealth {
+	for _, h := range s.Health() {
+		if h.Name == name {
+			return h
+		}
+	}
+	t.Fatalf("worker %s isn't reported", name)
+	return WorkerHealth{}
+}
+
+func TestRestartOnPanic(t *testing.T) {
+	s, c := newSupervisor()
+	defer s.Stop()
+
+	w := newWorker(t)
+	s.Child("child").Go("worker", w.run)
+	w.expectStart()
+	w.finish(func() error { panic("worker is broken") })
+
+	d := restartDelay(t, c)
+	h := health(t, s, "child/worker")
+	if h.State != StateRestarting || !strings.Contains(h.LastError, "worker is broken") {
+		t.Errorf("health = %+v, want %s after the panic", h, StateRestarting)
+	}
+
+	c.Advance(d)
+	w.expectStart()
+	if h := health(t, s, "child/worker"); h.State != StateRunning || h.Restarts != 1 {
+		t.Errorf("health = %+v, want %s after 1 restart", h, StateRunning)
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	s, c := newSupervisor()
+	defer s.Stop()
+
+	w := newWorker(t)
+	s.Go("worker", w.run)
+	w.expectStart()
+
+	// the ceilings of the delays of the consecutive failures
+	for _, ceiling := range []time.Duration{1, 2, 4, 8, 8} {
+		ceiling *= time.Second
+		w.finish(fail)
+
+		d := restartDelay(t, c)
+		if d < 0 || d > ceiling {
+			t.Fatalf("restart delay = %s, want up to %s", d, ceiling)
+		}
+		if d > 0 {
+			c.Advance(d - time.Nanosecond)
+			w.expectNoStart()
+		}
+		c.Advance(time.Nanosecond)
+		w.expectStart()
+	}
+
+	// a worker that has been running longer than MaxBackoff is restarted
+	// after MinBackoff at most again
+	c.Advance(s.MaxBackoff + time.Second)
+	w.finish(fail)
+	if d := restartDelay(t, c); d > s.MinBackoff {
+		t.Errorf("restart delay after a long run = %s, want up to %s", d, s.MinBackoff)
+	}
+}
+
+func TestFinishedWorker(t *testing.T) {
+	s, c := newSupervisor()
+	defer s.Stop()
+
+	w := newWorker(t)
+	s.Go("worker", w.run)
+	w.expectStart()
+	w.finish(func() error { return nil })
+
+	if !s.WaitTimeout(waitTimeout) {
+		t.Fatal("finished worker hasn't exited")
+	}
+	if h := health(t, s, "worker"); h.State != StateFinished || h.Restarts != 0 {
+		t.Errorf("health = %+v, want %s without restarts", h, StateFinished)
+	}
+	if n := c.Timers(); n != 0 {
+		t.Errorf("%d timer(s) are left after the worker has finished", n)
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	s, _ := newSupervisor()
+
+	stuck := make(chan struct{})
+	s.Go("worker", func(ctx context.Context) error {
+		<-stuck
+		return nil
+	})
+	s.Stop()
+
+	if s.WaitTimeout(time.Millisecond * 20) {
+		t.Fatal("WaitTimeout() has reported a stuck worker as exited")
+	}
+	close(stuck)
+	if !s.WaitTimeout(waitTimeout) {
+		t.Fatal("WaitTimeout() hasn't reported the worker as exited")
+	}
+	if h := health(t, s, "worker"); h.State != StateStopped {
+		t.Errorf("health = %+v, want %s", h, StateStopped)
+	}
+}